@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// Ответы участников на приглашение
+const (
+	rsvpNeedsAction = "needs-action"
+	rsvpAccepted    = "accepted"
+	rsvpDeclined    = "declined"
+	rsvpTentative   = "tentative"
+)
+
+// Attendee - приглашённый на событие пользователь и его ответ.
+// Ответ меняет только сам участник через /rsvp_event, организатор задаёт лишь список участников
+type Attendee struct {
+	UserID int    `json:"user_id"`
+	Status string `json:"status"`
+}
+
+// validateAttendees проверяет список участников: организатор не приглашает сам себя, участники не повторяются
+func (ev *Event) validateAttendees() error {
+	var errs ValidationError
+	seen := make(map[int]bool, len(ev.Attendees))
+	for _, a := range ev.Attendees {
+		switch {
+		case a.UserID <= 0:
+			errs.add("attendees", "user_id must be positive")
+		case a.UserID == ev.UserID:
+			errs.add("attendees", "organizer can't be an attendee")
+		case seen[a.UserID]:
+			errs.add("attendees", fmt.Sprintf("user %v is listed twice", a.UserID))
+		}
+		seen[a.UserID] = true
+	}
+	return errs.err()
+}
+
+// mergeAttendees переносит ответы из old в новый список участников, новые участники ещё не ответили
+func mergeAttendees(attendees, old []Attendee) []Attendee {
+	if len(attendees) == 0 {
+		return nil
+	}
+
+	statuses := make(map[int]string, len(old))
+	for _, a := range old {
+		statuses[a.UserID] = a.Status
+	}
+
+	res := make([]Attendee, 0, len(attendees))
+	for _, a := range attendees {
+		status, ok := statuses[a.UserID]
+		if !ok {
+			status = rsvpNeedsAction
+		}
+		res = append(res, Attendee{UserID: a.UserID, Status: status})
+	}
+	return res
+}
+
+// parseRSVPStatus разбирает ответ участника
+func parseRSVPStatus(value string) (string, error) {
+	switch value {
+	case rsvpAccepted, rsvpDeclined, rsvpTentative:
+		return value, nil
+	default:
+		return "", &FieldError{Field: "status", Reason: "must be accepted, declined or tentative"}
+	}
+}
+
+// withResponse возвращает событие с ответом участника userID
+func (ev Event) withResponse(userID int, status string) (Event, error) {
+	for i, a := range ev.Attendees {
+		if a.UserID == userID {
+			ev.Attendees = append([]Attendee(nil), ev.Attendees...)
+			ev.Attendees[i].Status = status
+			return ev, nil
+		}
+	}
+	return ev, businessErrorf("user %v is not invited to event %v of user %v", userID, ev.EventID, ev.UserID)
+}
+
+// eventRef - ссылка на событие другого пользователя
+type eventRef struct {
+	userID  int
+	eventID int
+}
+
+// inviteIndex - для каждого пользователя события других пользователей, куда его пригласили.
+// Блокировка индекса берётся после блокировки шарда, но никогда наоборот
+type inviteIndex struct {
+	mu     sync.RWMutex
+	byUser map[int]map[eventRef]struct{}
+}
+
+func newInviteIndex() *inviteIndex {
+	return &inviteIndex{byUser: make(map[int]map[eventRef]struct{})}
+}
+
+// set заменяет участников события old на attendees
+func (ix *inviteIndex) set(ref eventRef, old, attendees []Attendee) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	for _, a := range old {
+		delete(ix.byUser[a.UserID], ref)
+		if len(ix.byUser[a.UserID]) == 0 {
+			delete(ix.byUser, a.UserID)
+		}
+	}
+	for _, a := range attendees {
+		refs := ix.byUser[a.UserID]
+		if refs == nil {
+			refs = make(map[eventRef]struct{})
+			ix.byUser[a.UserID] = refs
+		}
+		refs[ref] = struct{}{}
+	}
+}
+
+// refs возвращает события, куда приглашён пользователь
+func (ix *inviteIndex) refs(userID int) []eventRef {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+
+	res := make([]eventRef, 0, len(ix.byUser[userID]))
+	for ref := range ix.byUser[userID] {
+		res = append(res, ref)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].userID != res[j].userID {
+			return res[i].userID < res[j].userID
+		}
+		return res[i].eventID < res[j].eventID
+	})
+	return res
+}
+
+// RSVPEventHandler /rsvp_event handler: участник отвечает на приглашение в событие organizer_id
+func (srv *Server) RSVPEventHandler(w http.ResponseWriter, r *http.Request) {
+	values, err := formValues(r)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	b := binder{values: values}
+	userID := b.userID(r)
+	organizerID := b.int("organizer_id", true)
+	eventID := b.int("event_id", true)
+	status, err := parseRSVPStatus(b.str("status"))
+	b.errs.merge(err)
+	if err := b.err(); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	ev, err := srv.storage.Respond(organizerID, eventID, userID, status)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(ev.Version))
+	getResponse(w, "Ответ на приглашение сохранён!", []Event{*ev}, http.StatusOK)
+}
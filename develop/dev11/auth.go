@@ -0,0 +1,388 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenTTL - срок жизни токена, выданного /login
+const tokenTTL = 24 * time.Hour
+
+// publicPaths - пути, доступные без токена. /metrics показывает число событий каждого пользователя
+// и требует токен
+var publicPaths = []string{"/login", "/openapi.json"}
+
+// passwordIterations - число итераций PBKDF2 для hashPassword, тесты его снижают
+var passwordIterations = 600000
+
+// Параметры хэша пароля: pbkdf2-sha256$<итерации>$<соль>$<ключ>, соль и ключ в base64 без выравнивания
+const (
+	passwordScheme  = "pbkdf2-sha256"
+	passwordSaltLen = 16
+	passwordKeyLen  = sha256.Size
+)
+
+// AuthError - запрос без действительного токена, отдаётся с кодом 401
+type AuthError struct {
+	Msg string
+}
+
+func (e *AuthError) Error() string {
+	return e.Msg
+}
+
+// ForbiddenError - пользователь из токена обращается к чужим событиям, отдаётся с кодом 403
+type ForbiddenError struct {
+	Msg string
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Msg
+}
+
+// User - учётная запись локального хранилища пользователей
+type User struct {
+	UserID int    `json:"user_id"`
+	Login  string `json:"login"`
+	// PasswordHash - хэш PBKDF2 с солью пользователя, см. hashPassword
+	PasswordHash string `json:"password_hash"`
+}
+
+// passwordHash - разобранный хэш пароля
+type passwordHash struct {
+	iterations int
+	salt, key  []byte
+}
+
+// pbkdf2 - PBKDF2-HMAC-SHA256 (RFC 8018)
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha256.New, password)
+	var key []byte
+	u := make([]byte, 0, prf.Size())
+	var index [4]byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(index[:], block)
+		prf.Write(index[:])
+		u = prf.Sum(u[:0])
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
+// parsePasswordHash разбирает хэш из файла пользователей
+func parsePasswordHash(s string) (passwordHash, error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 4 || parts[0] != passwordScheme {
+		return passwordHash{}, errors.New("password_hash must be a " + passwordScheme + " hash, see dev11 hash-password")
+	}
+
+	var (
+		h   passwordHash
+		err error
+	)
+	h.iterations, err = strconv.Atoi(parts[1])
+	if err != nil || h.iterations <= 0 {
+		return passwordHash{}, errors.New("password_hash: invalid iteration count")
+	}
+	h.salt, err = base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil || len(h.salt) < passwordSaltLen {
+		return passwordHash{}, errors.New("password_hash: invalid salt")
+	}
+	h.key, err = base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil || len(h.key) != passwordKeyLen {
+		return passwordHash{}, errors.New("password_hash: invalid key")
+	}
+	return h, nil
+}
+
+// matches сравнивает пароль с хэшем за время, не зависящее от места расхождения
+func (h *passwordHash) matches(password string) bool {
+	key := pbkdf2([]byte(password), h.salt, h.iterations, len(h.key))
+	return subtle.ConstantTimeCompare(key, h.key) == 1
+}
+
+// UserStore - пользователи из JSON файла (массив User), читается один раз при старте
+type UserStore struct {
+	byLogin map[string]User
+	hashes  map[string]passwordHash
+	// dummy сравнивается с паролем неизвестного логина, чтобы по времени ответа нельзя было узнать, есть ли логин
+	dummy passwordHash
+}
+
+// newUserStore читает пользователей из файла
+func newUserStore(path string) (*UserStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var users []User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, fmt.Errorf("users file %v: %v", path, err)
+	}
+
+	s := &UserStore{byLogin: make(map[string]User, len(users)), hashes: make(map[string]passwordHash, len(users))}
+	dummyIterations := passwordIterations
+	for i, u := range users {
+		if u.UserID <= 0 || u.Login == "" {
+			return nil, fmt.Errorf("users file %v: user_id and login are required", path)
+		}
+		h, err := parsePasswordHash(u.PasswordHash)
+		if err != nil {
+			return nil, fmt.Errorf("users file %v: user %v: %v", path, u.Login, err)
+		}
+		// фиктивный хэш не дешевле настоящих
+		if i == 0 || h.iterations > dummyIterations {
+			dummyIterations = h.iterations
+		}
+		s.byLogin[u.Login] = u
+		s.hashes[u.Login] = h
+	}
+
+	s.dummy = passwordHash{iterations: dummyIterations, salt: make([]byte, passwordSaltLen)}
+	if _, err := rand.Read(s.dummy.salt); err != nil {
+		return nil, err
+	}
+	s.dummy.key = pbkdf2([]byte("dummy password"), s.dummy.salt, s.dummy.iterations, passwordKeyLen)
+	return s, nil
+}
+
+// hashPassword хэширует пароль PBKDF2-HMAC-SHA256 со случайной солью
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := pbkdf2([]byte(password), salt, passwordIterations, passwordKeyLen)
+	return fmt.Sprintf("%s$%d$%s$%s", passwordScheme, passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// authenticate возвращает пользователя по логину и паролю
+func (s *UserStore) authenticate(login, password string) (User, error) {
+	u, ok := s.byLogin[login]
+	h := s.dummy
+	if ok {
+		h = s.hashes[login]
+	}
+	// сравнение выполняется и для неизвестного логина, ответ занимает столько же времени
+	if !h.matches(password) || !ok {
+		return User{}, &AuthError{Msg: "invalid login or password"}
+	}
+	return u, nil
+}
+
+// TokenIssuer выпускает и проверяет токены вида base64(claims).base64(HMAC-SHA256(claims))
+type TokenIssuer struct {
+	secret []byte
+	now    func() time.Time
+}
+
+// tokenClaims - содержимое токена
+type tokenClaims struct {
+	UserID  int   `json:"uid"`
+	Expires int64 `json:"exp"`
+}
+
+// newTokenIssuer - без секрета генерируется случайный, и токены не переживают перезапуск.
+// now - часы сервера, по ним считается срок действия токенов
+func newTokenIssuer(secret string, now func() time.Time) (*TokenIssuer, error) {
+	key := []byte(secret)
+	if secret == "" {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		log.Println("AUTH_SECRET is not set, tokens will be invalidated on restart")
+	}
+	return &TokenIssuer{secret: key, now: now}, nil
+}
+
+func (t *TokenIssuer) sign(payload string) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// issue выпускает токен пользователя
+func (t *TokenIssuer) issue(userID int) (string, time.Time, error) {
+	expires := t.now().Add(tokenTTL)
+	data, err := json.Marshal(tokenClaims{UserID: userID, Expires: expires.Unix()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + t.sign(payload), expires, nil
+}
+
+// verify проверяет подпись и срок действия токена и возвращает пользователя
+func (t *TokenIssuer) verify(token string) (int, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(t.sign(payload))) {
+		return 0, &AuthError{Msg: "invalid token"}
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return 0, &AuthError{Msg: "invalid token"}
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return 0, &AuthError{Msg: "invalid token"}
+	}
+	if t.now().Unix() >= claims.Expires {
+		return 0, &AuthError{Msg: "token expired"}
+	}
+
+	return claims.UserID, nil
+}
+
+// Auth - middleware аутентификации: пропускает только запросы с действительным
+// Authorization: Bearer <token> и кладёт пользователя из токена в контекст запроса
+type Auth struct {
+	handler http.Handler
+	tokens  *TokenIssuer
+	// public - пути, доступные без токена
+	public map[string]bool
+}
+
+// Конструктор middleware аутентификации
+func newAuth(handler http.Handler, tokens *TokenIssuer, public ...string) *Auth {
+	a := &Auth{handler: handler, tokens: tokens, public: make(map[string]bool)}
+	for _, p := range public {
+		a.public[p] = true
+	}
+	return a
+}
+
+type userKey struct{}
+
+// actingUser возвращает пользователя из токена; ok == false, если аутентификация выключена
+func actingUser(ctx context.Context) (int, bool) {
+	userID, ok := ctx.Value(userKey{}).(int)
+	return userID, ok
+}
+
+func (a *Auth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.public[r.URL.Path] {
+		a.handler.ServeHTTP(w, r)
+		return
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="dev11"`)
+		getErrorResponse(w, &AuthError{Msg: "bearer token is required"})
+		return
+	}
+
+	userID, err := a.tokens.verify(strings.TrimSpace(token))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="dev11", error="invalid_token"`)
+		getErrorResponse(w, err)
+		return
+	}
+
+	a.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, userID)))
+}
+
+// authorize сверяет указанного в запросе пользователя с пользователем из токена.
+// Если user_id не указан, подставляется пользователь из токена; без аутентификации userID не меняется.
+func authorize(r *http.Request, userID *int) error {
+	acting, ok := actingUser(r.Context())
+	return authorizeUser(acting, ok, userID)
+}
+
+// authorizeUser - authorize для пользователя acting, authenticated == false - аутентификация выключена
+func authorizeUser(acting int, authenticated bool, userID *int) error {
+	if !authenticated {
+		return nil
+	}
+	if *userID != 0 && *userID != acting {
+		return &ForbiddenError{Msg: fmt.Sprintf("access to events of user %v is denied", *userID)}
+	}
+	*userID = acting
+	return nil
+}
+
+// LoginHandler /login handler: выдаёт токен по логину и паролю
+func LoginHandler(users *UserStore, tokens *TokenIssuer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var creds struct {
+			Login    string `json:"login"`
+			Password string `json:"password"`
+		}
+
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+				getErrorResponse(w, bodyError(err))
+				return
+			}
+		} else {
+			if err := r.ParseForm(); err != nil {
+				getErrorResponse(w, bodyError(err))
+				return
+			}
+			creds.Login, creds.Password = r.PostForm.Get("login"), r.PostForm.Get("password")
+		}
+
+		var errs ValidationError
+		if creds.Login == "" {
+			errs.add("login", "is required")
+		}
+		if creds.Password == "" {
+			errs.add("password", "is required")
+		}
+		if err := errs.err(); err != nil {
+			getErrorResponse(w, err)
+			return
+		}
+
+		u, err := users.authenticate(creds.Login, creds.Password)
+		if err != nil {
+			getErrorResponse(w, err)
+			return
+		}
+
+		token, expires, err := tokens.issue(u.UserID)
+		if err != nil {
+			getErrorResponse(w, err)
+			return
+		}
+
+		resp := struct {
+			Result    string    `json:"result"`
+			UserID    int       `json:"user_id"`
+			Token     string    `json:"token"`
+			ExpiresAt time.Time `json:"expires_at"`
+		}{Result: "Вход выполнен!", UserID: u.UserID, Token: token, ExpiresAt: expires}
+
+		writeJSON(w, resp, http.StatusOK)
+	}
+}
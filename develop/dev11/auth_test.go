@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func init() {
+	// хэши в тестах считаются с малым числом итераций, иначе под -race вход занимает секунды
+	passwordIterations = 1000
+}
+
+func TestPBKDF2(t *testing.T) {
+	// известные векторы PBKDF2-HMAC-SHA256 для P = "password", S = "salt"
+	tests := []struct {
+		iterations, keyLen int
+		want               string
+	}{
+		{1, 32, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b"},
+		{2, 32, "ae4d0c95af6b46d32d0adff928f06dd02a303f8ef3c251dfd6e2d85a95474c43"},
+		{4096, 32, "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a"},
+		{1, 40, "120fb6cffcf8b32c43e7225256c4f837a86548c92ccc35480805987cb70be17b4dbf3a2f3dad3377"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(pbkdf2([]byte("password"), []byte("salt"), tt.iterations, tt.keyLen)); got != tt.want {
+			t.Errorf("%v iterations, %v bytes: %v", tt.iterations, tt.keyLen, got)
+		}
+	}
+}
+
+// userStoreFixture - хранилище с пользователем alice (user_id 1) и паролем secret
+func userStoreFixture(t *testing.T) *UserStore {
+	t.Helper()
+	hash, err := hashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := newUserStore(writeFile(t, "users.json", fmt.Sprintf(`[{"user_id": 1, "login": "alice", "password_hash": %q}]`, hash)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users
+}
+
+func TestUserStoreAuthenticate(t *testing.T) {
+	users := userStoreFixture(t)
+
+	if u, err := users.authenticate("alice", "secret"); err != nil || u.UserID != 1 {
+		t.Fatalf("valid password: %+v, %v", u, err)
+	}
+	for _, creds := range [][2]string{{"alice", "wrong"}, {"bob", "secret"}} {
+		var authErr *AuthError
+		if _, err := users.authenticate(creds[0], creds[1]); !errors.As(err, &authErr) {
+			t.Errorf("%v/%v: %v", creds[0], creds[1], err)
+		}
+	}
+
+	// соль случайная: хэши одного пароля разные
+	a, _ := hashPassword("secret")
+	b, _ := hashPassword("secret")
+	if a == b || !strings.HasPrefix(a, "pbkdf2-sha256$1000$") {
+		t.Errorf("hashes %q and %q", a, b)
+	}
+}
+
+func TestUserStoreRejectsWeakHashes(t *testing.T) {
+	tests := []struct {
+		name, hash string
+	}{
+		{"no hash", ""},
+		{"plain sha256", "sha256$00$2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"},
+		{"zero iterations", "pbkdf2-sha256$0$c2FsdHNhbHRzYWx0c2FsdA$EgT2z/z4syxD5yJSVsT4N6hlSMkszDVICAWYfLcL4Xs"},
+		{"short salt", "pbkdf2-sha256$1000$c2FsdA$EgT2z/z4syxD5yJSVsT4N6hlSMkszDVICAWYfLcL4Xs"},
+		{"short key", "pbkdf2-sha256$1000$c2FsdHNhbHRzYWx0c2FsdA$EgT2z/z4"},
+	}
+	for _, tt := range tests {
+		users := fmt.Sprintf(`[{"user_id": 1, "login": "alice", "password_hash": %q}]`, tt.hash)
+		if _, err := newUserStore(writeFile(t, "users.json", users)); err == nil {
+			t.Errorf("%v is accepted", tt.name)
+		}
+	}
+}
+
+func TestAuthenticateUnknownLoginTakesAsLong(t *testing.T) {
+	users := userStoreFixture(t)
+	measure := func(login string) time.Duration {
+		start := time.Now()
+		for i := 0; i < 3; i++ {
+			users.authenticate(login, "wrong")
+		}
+		return time.Since(start)
+	}
+
+	known, unknown := measure("alice"), measure("bob")
+	// без сравнения с фиктивным хэшем неизвестный логин отвечает на порядки быстрее
+	if unknown < known/4 {
+		t.Errorf("unknown login %v, known login %v", unknown, known)
+	}
+}
+
+func TestTokenExpiresByServerClock(t *testing.T) {
+	clock := newTestClock()
+	tokens, err := newTokenIssuer("test secret", clock.now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, expires, err := tokens.issue(1)
+	if err != nil || !expires.Equal(clock.now().Add(tokenTTL)) {
+		t.Fatalf("issue: expires %v, %v", expires, err)
+	}
+
+	clock.advance(tokenTTL - time.Second)
+	if userID, err := tokens.verify(token); err != nil || userID != 1 {
+		t.Errorf("before expiry: %v, %v", userID, err)
+	}
+	clock.advance(time.Second)
+	if _, err := tokens.verify(token); err == nil || err.Error() != "token expired" {
+		t.Errorf("after expiry: %v", err)
+	}
+}
+
+func TestRateLimitBeforeAuth(t *testing.T) {
+	tokens, err := newTokenIssuer("test secret", time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(newMemoryStorage(time.Now), time.Now)
+	srv.Handle(http.MethodPost, "/login", LoginHandler(userStoreFixture(t), tokens))
+	// порядок как в main: ограничитель снаружи аутентификации
+	h := newRateLimiter(newAuth(srv, tokens, publicPaths...), 0.01, 3)
+
+	tests := []struct {
+		name    string
+		request func() *http.Request
+		status  int
+	}{
+		{"password guessing", func() *http.Request {
+			r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"login": "alice", "password": "guess"}`))
+			r.Header.Set("Content-Type", "application/json")
+			return r
+		}, http.StatusUnauthorized},
+		{"bad token", func() *http.Request {
+			r := httptest.NewRequest(http.MethodGet, "/events_for_day?date=2024-01-10", nil)
+			r.Header.Set("Authorization", "Bearer guess")
+			return r
+		}, http.StatusUnauthorized},
+		{"no token", func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/events_for_day?date=2024-01-10", nil)
+		}, http.StatusUnauthorized},
+	}
+	for i, tt := range tests {
+		addr := fmt.Sprintf("10.0.0.%v:5000", i+1)
+		for n := 0; n < 4; n++ {
+			r := tt.request()
+			r.RemoteAddr = addr
+			want := tt.status
+			if n == 3 {
+				want = http.StatusTooManyRequests
+			}
+			if w := limitedRequest(h, r); w.Code != want {
+				t.Errorf("%v, attempt %v: %v, want %v", tt.name, n+1, w.Code, want)
+			}
+		}
+	}
+}
+
+func TestLoginAndBearerToken(t *testing.T) {
+	tokens, err := newTokenIssuer("test secret", time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := newServer(newMemoryStorage(time.Now), time.Now)
+	srv.Handle(http.MethodPost, "/login", LoginHandler(userStoreFixture(t), tokens))
+	ts := httptest.NewServer(newAuth(srv, tokens, "/login"))
+	defer ts.Close()
+
+	resp, err := http.PostForm(ts.URL+"/login", url.Values{"login": {"alice"}, "password": {"wrong"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("wrong password: %v", resp.StatusCode)
+	}
+
+	resp, err = http.Post(ts.URL+"/login", "application/json", strings.NewReader(`{"login": "alice", "password": "secret"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var login struct {
+		UserID int    `json:"user_id"`
+		Token  string `json:"token"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&login)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || login.UserID != 1 {
+		t.Fatalf("login: %v, %+v, %v", resp.StatusCode, login, err)
+	}
+
+	get := func(path, token string) int {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := get("/events_for_day?date=2024-01-10", ""); status != http.StatusUnauthorized {
+		t.Errorf("without token: %v", status)
+	}
+	if status := get("/events_for_day?date=2024-01-10", login.Token+"x"); status != http.StatusUnauthorized {
+		t.Errorf("forged token: %v", status)
+	}
+	if status := get("/events_for_day?user_id=2&date=2024-01-10", login.Token); status != http.StatusForbidden {
+		t.Errorf("another user: %v", status)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+)
+
+// maxBatchOps - сколько операций можно передать в одном пакете
+const maxBatchOps = 10000
+
+// errNotApplied - операция атомарного пакета откатена или не выполнялась из-за ошибки другой операции
+var errNotApplied = errors.New("not applied: another operation of the atomic batch failed")
+
+// BatchOp - операция пакета: create, update или delete события
+type BatchOp struct {
+	Op    string `json:"op"`
+	Event Event  `json:"event"`
+}
+
+// BatchResult - результат операции пакета: событие после операции (для delete - удалённое)
+// и пересечения, либо ошибка
+type BatchResult struct {
+	Event    *Event
+	Warnings []Event
+	Err      error
+}
+
+// batchOpResult - ответ по одной операции пакета, Status - HTTP статус, который вернул бы одиночный запрос
+type batchOpResult struct {
+	Op        string       `json:"op"`
+	Status    int          `json:"status"`
+	Event     *Event       `json:"event,omitempty"`
+	Warnings  []Event      `json:"warnings,omitempty"`
+	Error     string       `json:"error,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	Conflicts []Event      `json:"conflicts,omitempty"`
+}
+
+// setError заполняет ответ по ошибке так же, как getErrorResponse
+func (res *batchOpResult) setError(err error) {
+	var (
+		validationErr *ValidationError
+		fieldErr      *FieldError
+		conflictErr   *ConflictError
+	)
+	res.Status = errorStatus(err)
+	res.Error = err.Error()
+	switch {
+	case errors.Is(err, errNotApplied):
+		res.Status = http.StatusFailedDependency
+	case errors.As(err, &validationErr):
+		res.Fields = validationErr.Fields
+	case errors.As(err, &fieldErr):
+		res.Fields = []FieldError{*fieldErr}
+	case errors.As(err, &conflictErr):
+		res.Conflicts = conflictErr.Conflicts
+	case res.Status == http.StatusInternalServerError:
+		log.Printf("internal error: %v", err)
+		res.Error = "internal server error"
+	}
+}
+
+// bindBatchOp разбирает и проверяет одну операцию пакета
+func bindBatchOp(r *http.Request, raw json.RawMessage, op *BatchOp) error {
+	var aux struct {
+		Op    string          `json:"op"`
+		Event json.RawMessage `json:"event"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&aux); err != nil {
+		var errs ValidationError
+		errs.merge(jsonFieldError(err))
+		return &errs
+	}
+	op.Op = aux.Op
+
+	var check func(*Event) error
+	switch op.Op {
+	case opCreate:
+		check = (*Event).validate
+	case opUpdate:
+		check = (*Event).validateUpdate
+	case opDelete:
+		check = (*Event).validateRef
+	default:
+		return &FieldError{Field: "op", Reason: "must be create, update or delete"}
+	}
+	if len(aux.Event) == 0 {
+		return &FieldError{Field: "event", Reason: "is required"}
+	}
+
+	if err := op.Event.decode(bytes.NewReader(aux.Event)); err != nil {
+		return err
+	}
+	if err := authorize(r, &op.Event.UserID); err != nil {
+		return err
+	}
+	return check(&op.Event)
+}
+
+// BatchHandler /events/batch handler: пакет операций create, update и delete.
+// Без atomic операции выполняются независимо; с atomic=true первая ошибка откатывает весь пакет,
+// ответ получает её статус, а остальные операции - 424
+func (srv *Server) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	b := binder{values: r.URL.Query()}
+	atomic := b.bool("atomic")
+	policy, err := parseConflictPolicy(b.str("on_conflict"))
+	b.errs.merge(err)
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		b.errs.add("content_type", "must be application/json")
+	}
+	if err := b.err(); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	var req struct {
+		Operations []json.RawMessage `json:"operations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		getErrorResponse(w, bodyError(err))
+		return
+	}
+	switch {
+	case len(req.Operations) == 0:
+		getErrorResponse(w, &FieldError{Field: "operations", Reason: "is required"})
+		return
+	case len(req.Operations) > maxBatchOps:
+		getErrorResponse(w, &FieldError{Field: "operations", Reason: fmt.Sprintf("must have at most %v items", maxBatchOps)})
+		return
+	}
+
+	results := make([]batchOpResult, len(req.Operations))
+	ops := make([]BatchOp, 0, len(req.Operations))
+	// index - номер операции в запросе для каждой операции, переданной хранилищу
+	index := make([]int, 0, len(req.Operations))
+	failed := -1
+	for i, raw := range req.Operations {
+		var op BatchOp
+		err := bindBatchOp(r, raw, &op)
+		results[i].Op = op.Op
+		if err != nil {
+			results[i].setError(err)
+			if failed < 0 {
+				failed = i
+			}
+			continue
+		}
+		ops = append(ops, op)
+		index = append(index, i)
+	}
+
+	// в атомарном пакете с неверной операцией хранилище не трогается
+	if atomic && failed >= 0 {
+		for i := range results {
+			if i != failed {
+				results[i].setError(errNotApplied)
+			}
+		}
+		writeBatchResponse(w, results, failed)
+		return
+	}
+
+	applied, err := srv.storage.Batch(ops, policy, atomic)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+	for j, res := range applied {
+		i := index[j]
+		if res.Err != nil {
+			results[i].setError(res.Err)
+			if failed < 0 && !errors.Is(res.Err, errNotApplied) {
+				failed = i
+			}
+			continue
+		}
+		results[i].Status = http.StatusOK
+		if ops[j].Op == opCreate {
+			results[i].Status = http.StatusCreated
+		}
+		results[i].Event = res.Event
+		results[i].Warnings = res.Warnings
+	}
+
+	if !atomic {
+		failed = -1
+	}
+	writeBatchResponse(w, results, failed)
+}
+
+// writeBatchResponse отвечает результатами операций; failed >= 0 - номер операции, сорвавшей атомарный пакет
+func writeBatchResponse(w http.ResponseWriter, results []batchOpResult, failed int) {
+	if failed >= 0 {
+		resp := struct {
+			Error   string          `json:"error"`
+			Results []batchOpResult `json:"results"`
+		}{Error: fmt.Sprintf("operation %v: %v", failed, results[failed].Error), Results: results}
+		writeJSON(w, resp, results[failed].Status)
+		return
+	}
+
+	resp := struct {
+		Result  string          `json:"result"`
+		Results []batchOpResult `json:"results"`
+	}{Result: "Пакет обработан!", Results: results}
+	writeJSON(w, resp, http.StatusOK)
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rollbackBatch - атомарный пакет, последняя операция которого падает
+func rollbackBatch(t *testing.T, s Storage, existing Event) {
+	t.Helper()
+	ops := []BatchOp{
+		{Op: opUpdate, Event: Event{UserID: 1, EventID: existing.EventID, Title: "Изменено", Date: existing.Date}},
+		{Op: opCreate, Event: Event{UserID: 5, Title: "Новый пользователь", Date: existing.Date}},
+		{Op: opCreate, Event: Event{UserID: 5, Title: "Ещё одно", Date: existing.Date.Add(time.Hour)}},
+		{Op: opDelete, Event: Event{UserID: 1, EventID: 1000}},
+	}
+	results, err := s.Batch(ops, conflictWarn, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results[:3] {
+		if !errors.Is(r.Err, errNotApplied) {
+			t.Errorf("operation %v: %v, want not applied", i, r.Err)
+		}
+	}
+	var be *BusinessError
+	if !errors.As(results[3].Err, &be) {
+		t.Errorf("failed operation: %v", results[3].Err)
+	}
+}
+
+// checkRolledBack проверяет, что после отката хранилище такое же, как до пакета
+func checkRolledBack(t *testing.T, s Storage, existing Event) {
+	t.Helper()
+	ev, err := s.getEvent(1, existing.EventID)
+	if err != nil || ev.Title != existing.Title || ev.Version != existing.Version {
+		t.Errorf("updated event is not restored: %+v, %v", ev, err)
+	}
+	history, err := s.getHistory(1, existing.EventID)
+	if err != nil || len(history) != 1 {
+		t.Errorf("history %v revisions, %v", len(history), err)
+	}
+
+	if ids := s.getUserIDs(); len(ids) != 1 || ids[0] != 1 {
+		t.Errorf("users after rollback %v", ids)
+	}
+	_, err = s.getEventsInRange(5, existing.Date.AddDate(0, 0, -1), existing.Date.AddDate(0, 0, 1))
+	var be *BusinessError
+	if !errors.As(err, &be) {
+		t.Errorf("user of the rolled back create: %v", err)
+	}
+}
+
+func TestMemoryBatchAtomicRollback(t *testing.T) {
+	s := newMemoryStorage(time.Now)
+	existing := Event{UserID: 1, Title: "Было", Date: time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)}
+	if _, err := s.Create(&existing, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+
+	rollbackBatch(t, s, existing)
+	checkRolledBack(t, s, existing)
+
+	// после отката создание для того же пользователя начинает нумерацию заново
+	ev := Event{UserID: 5, Title: "x", Date: existing.Date}
+	if _, err := s.Create(&ev, conflictWarn); err != nil || ev.EventID != 1 {
+		t.Errorf("create after rollback: id %v, %v", ev.EventID, err)
+	}
+}
+
+func TestMemoryBatchNotAtomic(t *testing.T) {
+	s := newMemoryStorage(time.Now)
+	date := time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)
+	ops := []BatchOp{
+		{Op: opCreate, Event: Event{UserID: 1, Title: "a", Date: date}},
+		{Op: opDelete, Event: Event{UserID: 1, EventID: 1000}},
+		{Op: opCreate, Event: Event{UserID: 1, Title: "b", Date: date.Add(time.Hour)}},
+	}
+	results, err := s.Batch(ops, conflictWarn, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Err != nil || results[1].Err == nil || results[2].Err != nil {
+		t.Fatalf("results %+v", results)
+	}
+	events, err := s.getEventsForDay(1, date)
+	if err != nil || len(events) != 2 {
+		t.Errorf("events %+v, %v", events, err)
+	}
+}
+
+func TestFileBatchAtomicRollback(t *testing.T) {
+	path := t.TempDir() + "/events.log"
+	s, err := newFileStorage(path, time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing := Event{UserID: 1, Title: "Было", Date: time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)}
+	if _, err := s.Create(&existing, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+
+	rollbackBatch(t, s, existing)
+	checkRolledBack(t, s, existing)
+
+	// откатанный пакет не попадает в журнал
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = newFileStorage(path, time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	checkRolledBack(t, s, existing)
+}
+
+func TestFileBatchLargeRestart(t *testing.T) {
+	path := t.TempDir() + "/events.log"
+	s, err := newFileStorage(path, time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// пакеты предельного размера с длинными описаниями - десятки мегабайт журнала
+	date := time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)
+	description := strings.Repeat("d", 2048)
+	ops := make([]BatchOp, maxBatchOps)
+	for i := range ops {
+		ops[i] = BatchOp{Op: opCreate, Event: Event{UserID: 1, Title: "x", Description: description, Date: date.Add(time.Duration(i) * time.Minute)}}
+	}
+	results, err := s.Batch(ops, conflictWarn, true)
+	if err != nil || results[0].Err != nil {
+		t.Fatalf("create batch: %v", err)
+	}
+	for i := range ops {
+		ops[i] = BatchOp{Op: opDelete, Event: Event{UserID: 1, EventID: results[i].Event.EventID}}
+	}
+	ops = append(ops[:maxBatchOps-1], BatchOp{Op: opCreate, Event: Event{UserID: 1, Title: "остался", Date: date}})
+	if results, err := s.Batch(ops, conflictWarn, true); err != nil || results[0].Err != nil {
+		t.Fatalf("delete batch: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = newFileStorage(path, time.Now)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer s.Close()
+	events, err := s.getUserEvents(1)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("after restart %v events, %v", len(events), err)
+	}
+	if trash, _ := s.getTrash(1); len(trash) != maxBatchOps-1 {
+		t.Errorf("after restart %v events in trash", len(trash))
+	}
+}
+
+func TestFileBatchTornLastPart(t *testing.T) {
+	path := t.TempDir() + "/events.log"
+	s, err := newFileStorage(path, time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	date := time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)
+	before := Event{UserID: 1, Title: "до пакета", Date: date}
+	if _, err := s.Create(&before, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+	ops := make([]BatchOp, 2*maxBatchRecordOps+1)
+	for i := range ops {
+		ops[i] = BatchOp{Op: opCreate, Event: Event{UserID: 2, Title: "x", Date: date.Add(time.Duration(i) * time.Hour)}}
+	}
+	if _, err := s.Batch(ops, conflictWarn, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// падение во время записи последней части: от неё осталась половина строки
+	data, err := os.ReadFile(path + ".log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("%v log records, want create and 3 batch parts", len(lines))
+	}
+	last := lines[3]
+	torn := strings.Join(lines[:3], "") + last[:len(last)/2]
+	if err := os.WriteFile(path+".log", []byte(torn), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = newFileStorage(path, time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.getEvent(1, before.EventID); err != nil {
+		t.Errorf("record before the batch: %v", err)
+	}
+	if ids := s.getUserIDs(); len(ids) != 1 {
+		t.Errorf("users %v: parts of an unfinished batch are applied", ids)
+	}
+}
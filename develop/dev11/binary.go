@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Методы бинарного протокола кроме opCreate, opUpdate и opDelete
+const (
+	binEventsForDay   = "events_for_day"
+	binEventsForWeek  = "events_for_week"
+	binEventsForMonth = "events_for_month"
+	// binEvents - вхождения в окне [From, To)
+	binEvents = "events"
+)
+
+// BinaryRequest - запрос бинарного протокола. Кадр - 4 байта длины (big endian) и gob сообщение.
+// Token нужен, если включена аутентификация: тот же, что выдаёт /login
+type BinaryRequest struct {
+	ID     uint64
+	Method string
+	Token  string
+	// Event - событие create/update/delete, у update и delete Version - ожидаемая версия (0 - любая)
+	Event  Event
+	Reject bool
+	// UserID, Date, From, To, Calendars, Tags - параметры выборок
+	UserID    int
+	Date      time.Time
+	From      time.Time
+	To        time.Time
+	Calendars []int
+	Tags      []string
+}
+
+// BinaryResponse - ответ на запрос с тем же ID. Status - HTTP статус, который вернул бы такой же HTTP запрос
+type BinaryResponse struct {
+	ID        uint64
+	Status    int
+	Events    []Event
+	Warnings  []Event
+	Error     string
+	Fields    []FieldError
+	Conflicts []Event
+}
+
+// setError заполняет ответ по ошибке как /events/batch
+func (resp *BinaryResponse) setError(err error) {
+	var res batchOpResult
+	res.setError(err)
+	resp.Status, resp.Error, resp.Fields, resp.Conflicts = res.Status, res.Error, res.Fields, res.Conflicts
+}
+
+// readFrame читает кадр не длиннее max байт
+func readFrame(r io.Reader, max int64) ([]byte, error) {
+	var size uint32
+	if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if int64(size) > max {
+		return nil, fmt.Errorf("frame of %v bytes is larger than %v bytes", size, max)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// writeFrame пишет v как кадр: длина и gob сообщение
+func writeFrame(w io.Writer, v interface{}) error {
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 4))
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data, uint32(len(data)-4))
+	_, err := w.Write(data)
+	return err
+}
+
+// defaultBinaryMaxConns - сколько соединений бинарного протокола обслуживается одновременно
+const defaultBinaryMaxConns = 1000
+
+// BinaryServer - второй транспорт к тому же хранилищу: кадры с gob сообщениями поверх TCP.
+// Запросы одного соединения выполняются по очереди, ответы идут в порядке запросов
+type BinaryServer struct {
+	storage Storage
+	// tokens == nil - аутентификация выключена
+	tokens *TokenIssuer
+	// maxFrame - предел размера кадра запроса, как max_body_size у HTTP
+	maxFrame int64
+	// Таймауты как у HTTP сервера, 0 - без ограничения: idleTimeout - ожидание следующего запроса,
+	// readTimeout - чтение начатого кадра, writeTimeout - запись ответа
+	idleTimeout, readTimeout, writeTimeout time.Duration
+	// maxConns - предел одновременных соединений, лишним отвечается 503 и соединение закрывается
+	maxConns int
+
+	mu       sync.Mutex
+	ln       net.Listener
+	conns    map[net.Conn]bool
+	closing  bool
+	handlers sync.WaitGroup
+}
+
+func newBinaryServer(s Storage, tokens *TokenIssuer, maxFrame int64) *BinaryServer {
+	return &BinaryServer{storage: s, tokens: tokens, maxFrame: maxFrame, maxConns: defaultBinaryMaxConns, conns: make(map[net.Conn]bool)}
+}
+
+// Serve принимает соединения до Close
+func (s *BinaryServer) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return ln.Close()
+	}
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if closing {
+				return nil
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		if s.maxConns > 0 && len(s.conns) >= s.maxConns {
+			s.mu.Unlock()
+			go s.reject(conn)
+			continue
+		}
+		s.conns[conn] = true
+		s.handlers.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// reject отвечает соединению сверх maxConns ошибкой с ID 0 и закрывает его
+func (s *BinaryServer) reject(conn net.Conn) {
+	defer conn.Close()
+	resp := BinaryResponse{Status: http.StatusServiceUnavailable, Error: "too many connections"}
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	if err := writeFrame(conn, &resp); err != nil {
+		log.Printf("binary %v: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// setReadDeadline ставит дедлайн чтения через d (0 - без дедлайна). После Close дедлайн не сдвигается,
+// чтобы не отменить прерывание чтения, false - сервер закрывается
+func (s *BinaryServer) setReadDeadline(conn net.Conn, d time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closing {
+		return false
+	}
+	var deadline time.Time
+	if d > 0 {
+		deadline = time.Now().Add(d)
+	}
+	conn.SetReadDeadline(deadline)
+	return true
+}
+
+// Close перестаёт принимать соединения и запросы; начатые запросы дорабатывают, Close ждёт их ответов
+func (s *BinaryServer) Close() error {
+	s.mu.Lock()
+	s.closing = true
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	// чтение следующего запроса прерывается, запись ответа - нет
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+	s.mu.Unlock()
+
+	s.handlers.Wait()
+	return err
+}
+
+func (s *BinaryServer) serveConn(conn net.Conn) {
+	defer s.handlers.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	logErr := func(err error) {
+		var netErr net.Error
+		if err != io.EOF && !(errors.As(err, &netErr) && netErr.Timeout()) {
+			log.Printf("binary %v: %v", conn.RemoteAddr(), err)
+		}
+	}
+
+	r := bufio.NewReader(conn)
+	for {
+		// между запросами действует idleTimeout, с первого байта кадра - readTimeout
+		if !s.setReadDeadline(conn, s.idleTimeout) {
+			return
+		}
+		if _, err := r.Peek(1); err != nil {
+			logErr(err)
+			return
+		}
+		if !s.setReadDeadline(conn, s.readTimeout) {
+			return
+		}
+		data, err := readFrame(r, s.maxFrame)
+		if err != nil {
+			logErr(err)
+			return
+		}
+
+		var req BinaryRequest
+		var resp BinaryResponse
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&req); err != nil {
+			resp.setError(&FieldError{Field: "body", Reason: err.Error()})
+		} else {
+			resp = s.handle(&req)
+		}
+
+		if s.writeTimeout > 0 {
+			conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		}
+		if err := writeFrame(conn, &resp); err != nil {
+			log.Printf("binary %v: %v", conn.RemoteAddr(), err)
+			return
+		}
+	}
+}
+
+// authorize сверяет пользователя запроса с пользователем токена, как authorize у HTTP
+func (s *BinaryServer) authorize(req *BinaryRequest, userID *int) error {
+	if s.tokens == nil {
+		return nil
+	}
+	if req.Token == "" {
+		return &AuthError{Msg: "token is required"}
+	}
+	acting, err := s.tokens.verify(req.Token)
+	if err != nil {
+		return err
+	}
+	return authorizeUser(acting, true, userID)
+}
+
+// handle выполняет запрос с теми же проверками, что и HTTP хэндлеры
+func (s *BinaryServer) handle(req *BinaryRequest) BinaryResponse {
+	resp := BinaryResponse{ID: req.ID}
+	events, warnings, err := s.call(req)
+	if err != nil {
+		resp.setError(err)
+		return resp
+	}
+
+	resp.Status = http.StatusOK
+	if req.Method == opCreate {
+		resp.Status = http.StatusCreated
+	}
+	resp.Events, resp.Warnings = events, warnings
+	return resp
+}
+
+func (s *BinaryServer) call(req *BinaryRequest) ([]Event, []Event, error) {
+	policy := conflictWarn
+	if req.Reject {
+		policy = conflictReject
+	}
+
+	ev := req.Event
+	switch req.Method {
+	case opCreate, opUpdate, opDelete:
+		if err := s.authorize(req, &ev.UserID); err != nil {
+			return nil, nil, err
+		}
+	case binEventsForDay, binEventsForWeek, binEventsForMonth, binEvents:
+		if err := s.authorize(req, &req.UserID); err != nil {
+			return nil, nil, err
+		}
+	default:
+		return nil, nil, &FieldError{Field: "method", Reason: fmt.Sprintf("unknown %q", req.Method)}
+	}
+
+	// время приходит готовыми значениями: переводим в пояс события, как setTimes у HTTP
+	if loc, err := ev.location(); err == nil && (req.Method == opCreate || req.Method == opUpdate) {
+		ev.inLocation(loc)
+	}
+
+	switch req.Method {
+	case opCreate:
+		if err := ev.validate(); err != nil {
+			return nil, nil, err
+		}
+		warnings, err := s.storage.Create(&ev, policy)
+		return []Event{ev}, warnings, err
+	case opUpdate:
+		if err := ev.validateUpdate(); err != nil {
+			return nil, nil, err
+		}
+		warnings, err := s.storage.Update(&ev, policy)
+		return []Event{ev}, warnings, err
+	case opDelete:
+		if err := ev.validateRef(); err != nil {
+			return nil, nil, err
+		}
+		deleted, err := s.storage.Delete(&ev)
+		if err != nil {
+			return nil, nil, err
+		}
+		return []Event{*deleted}, nil, nil
+	}
+
+	var errs ValidationError
+	if req.UserID <= 0 {
+		errs.add("user_id", "must be positive")
+	}
+	if req.Method == binEvents {
+		switch {
+		case req.From.IsZero() || req.To.IsZero() || !req.To.After(req.From):
+			errs.add("to", "must be after from")
+		case req.To.Sub(req.From) > maxQueryWindow:
+			errs.add("to", fmt.Sprintf("window must be at most %v days", int(maxQueryWindow.Hours()/24)))
+		}
+	} else if req.Date.IsZero() {
+		errs.add("date", "is required")
+	}
+	if err := errs.err(); err != nil {
+		return nil, nil, err
+	}
+
+	var events []Event
+	var err error
+	switch req.Method {
+	case binEventsForDay:
+		events, err = s.storage.getEventsForDay(req.UserID, req.Date)
+	case binEventsForWeek:
+		events, err = s.storage.getEventsForWeek(req.UserID, req.Date)
+	case binEventsForMonth:
+		events, err = s.storage.getEventsForMonth(req.UserID, req.Date)
+	default:
+		events, err = s.storage.getEventsInRange(req.UserID, req.From, req.To)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	filter := EventFilter{UserID: req.UserID, Calendars: req.Calendars, Tags: req.Tags}
+	return filter.apply(events), nil, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFrameRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	req := BinaryRequest{ID: 7, Method: binEventsForDay, UserID: 3, Date: time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)}
+	if err := writeFrame(&buf, &req); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFrame(&buf, &req); err != nil {
+		t.Fatal(err)
+	}
+	if size := binary.BigEndian.Uint32(buf.Bytes()); int(size) != buf.Len()/2-4 {
+		t.Fatalf("length prefix %v for %v bytes", size, buf.Len()/2-4)
+	}
+
+	// кадры читаются по одному, второй не теряется за первым
+	r := bufio.NewReader(&buf)
+	for i := 0; i < 2; i++ {
+		data, err := readFrame(r, 1<<10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got BinaryRequest
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != 7 || got.Method != binEventsForDay || !got.Date.Equal(req.Date) {
+			t.Fatalf("frame %v: %+v", i, got)
+		}
+	}
+	if _, err := readFrame(r, 1<<10); err == nil {
+		t.Fatal("read past the last frame")
+	}
+}
+
+func TestReadFrameErrors(t *testing.T) {
+	var big bytes.Buffer
+	binary.Write(&big, binary.BigEndian, uint32(2048))
+	if _, err := readFrame(&big, 1024); err == nil {
+		t.Error("frame over the limit is accepted")
+	}
+
+	var short bytes.Buffer
+	binary.Write(&short, binary.BigEndian, uint32(10))
+	short.WriteString("abc")
+	if _, err := readFrame(&short, 1024); err == nil {
+		t.Error("truncated frame is accepted")
+	}
+}
+
+// binaryConn - соединение с тестовым бинарным сервером
+type binaryConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func startBinary(t *testing.T, configure func(*BinaryServer)) (*BinaryServer, string) {
+	t.Helper()
+	s := newBinaryServer(newMemoryStorage(time.Now), nil, 1<<20)
+	if configure != nil {
+		configure(s)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+	return s, ln.Addr().String()
+}
+
+func dialBinary(t *testing.T, addr string) *binaryConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	return &binaryConn{Conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *binaryConn) read(t *testing.T) BinaryResponse {
+	t.Helper()
+	data, err := readFrame(c.r, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp BinaryResponse
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func (c *binaryConn) call(t *testing.T, req BinaryRequest) BinaryResponse {
+	t.Helper()
+	if err := writeFrame(c, &req); err != nil {
+		t.Fatal(err)
+	}
+	resp := c.read(t)
+	if resp.ID != req.ID {
+		t.Fatalf("response %v to request %v", resp.ID, req.ID)
+	}
+	return resp
+}
+
+func TestBinaryNormalizesTimes(t *testing.T) {
+	_, addr := startBinary(t, nil)
+	c := dialBinary(t, addr)
+
+	moscow, _ := time.LoadLocation("Europe/Moscow")
+	// 23:00 UTC 9 января - уже 10 января в Москве
+	date := time.Date(2024, 1, 9, 23, 0, 0, 0, time.UTC)
+	resp := c.call(t, BinaryRequest{ID: 1, Method: opCreate, Event: Event{UserID: 1, Title: "Праздник", Date: date, AllDay: true, TimeZone: "Europe/Moscow"}})
+	if resp.Status != http.StatusCreated {
+		t.Fatalf("create: %+v", resp)
+	}
+	want := time.Date(2024, 1, 10, 0, 0, 0, 0, moscow)
+	if got := resp.Events[0].Date; !got.Equal(want) {
+		t.Errorf("all-day date %v, want %v", got, want)
+	}
+
+	// тот же день через HTTP биндер даёт то же время
+	var viaHTTP Event
+	viaHTTP.AllDay, viaHTTP.TimeZone = true, "Europe/Moscow"
+	if err := viaHTTP.setTimes(date.Format(time.RFC3339), ""); err != nil {
+		t.Fatal(err)
+	}
+	if !viaHTTP.Date.Equal(resp.Events[0].Date) {
+		t.Errorf("binary %v, http %v", resp.Events[0].Date, viaHTTP.Date)
+	}
+
+	resp = c.call(t, BinaryRequest{ID: 2, Method: binEventsForDay, UserID: 1, Date: want})
+	if resp.Status != http.StatusOK || len(resp.Events) != 1 {
+		t.Fatalf("events_for_day: %+v", resp)
+	}
+
+	resp = c.call(t, BinaryRequest{ID: 3, Method: opCreate, Event: Event{UserID: 1, Title: "x", Date: date, TimeZone: "Mars/Olympus"}})
+	if resp.Status != http.StatusBadRequest {
+		t.Errorf("unknown time zone: %+v", resp)
+	}
+}
+
+func TestBinaryTimeouts(t *testing.T) {
+	_, addr := startBinary(t, func(s *BinaryServer) {
+		s.idleTimeout = 200 * time.Millisecond
+		s.readTimeout = 100 * time.Millisecond
+	})
+
+	// соединение без запросов закрывается по idleTimeout
+	idle := dialBinary(t, addr)
+	if _, err := idle.r.ReadByte(); err == nil {
+		t.Error("idle connection is not closed")
+	}
+
+	// начатый и не дописанный кадр обрывается по readTimeout, хотя idleTimeout больше
+	partial := dialBinary(t, addr)
+	start := time.Now()
+	partial.Write([]byte{0, 0, 0, 100, 1, 2})
+	if _, err := partial.r.ReadByte(); err == nil {
+		t.Error("connection with a partial frame is not closed")
+	}
+	if d := time.Since(start); d > 180*time.Millisecond {
+		t.Errorf("partial frame closed after %v, read timeout is 100ms", d)
+	}
+
+	// запросы в пределах таймаутов обслуживаются
+	c := dialBinary(t, addr)
+	for i := uint64(1); i <= 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		c.call(t, BinaryRequest{ID: i, Method: binEventsForDay, UserID: 1, Date: time.Now()})
+	}
+}
+
+func TestBinaryMaxConns(t *testing.T) {
+	_, addr := startBinary(t, func(s *BinaryServer) { s.maxConns = 1 })
+
+	first := dialBinary(t, addr)
+	first.call(t, BinaryRequest{ID: 1, Method: binEventsForDay, UserID: 1, Date: time.Now()})
+
+	second := dialBinary(t, addr)
+	resp := second.read(t)
+	if resp.ID != 0 || resp.Status != http.StatusServiceUnavailable {
+		t.Fatalf("connection over the limit: %+v", resp)
+	}
+
+	// после закрытия первого соединения место освобождается
+	first.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c := dialBinary(t, addr)
+		if err := writeFrame(c, &BinaryRequest{ID: 1, Method: binEventsForDay, UserID: 1, Date: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if resp := c.read(t); resp.ID == 1 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the slot of the closed connection is not released")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestBinaryCloseInterruptsIdle(t *testing.T) {
+	s, addr := startBinary(t, nil)
+	c := dialBinary(t, addr)
+	c.call(t, BinaryRequest{ID: 1, Method: binEventsForDay, UserID: 1, Date: time.Now()})
+
+	done := make(chan struct{})
+	go func() {
+		s.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close waits for an idle connection")
+	}
+}
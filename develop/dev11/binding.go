@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldError - ошибка входных данных в конкретном поле запроса
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("invalid %v: %v", e.Field, e.Reason)
+}
+
+// ValidationError - все ошибки входных данных запроса, отдаётся с кодом 400
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for i := range e.Fields {
+		msgs = append(msgs, e.Fields[i].Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// add добавляет ошибку поля, по одной ошибке на поле
+func (e *ValidationError) add(field, reason string) {
+	for _, f := range e.Fields {
+		if f.Field == field {
+			return
+		}
+	}
+	e.Fields = append(e.Fields, FieldError{Field: field, Reason: reason})
+}
+
+// merge переносит ошибки из err, ошибка без поля относится ко всему телу запроса
+func (e *ValidationError) merge(err error) {
+	switch v := err.(type) {
+	case nil:
+	case *ValidationError:
+		for _, f := range v.Fields {
+			e.add(f.Field, f.Reason)
+		}
+	case *FieldError:
+		e.add(v.Field, v.Reason)
+	default:
+		e.add("body", err.Error())
+	}
+}
+
+// err возвращает nil, если ошибок нет
+func (e *ValidationError) err() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+// BusinessError - ошибка бизнес-логики (нет пользователя, события и т.п.), отдаётся с кодом 503
+type BusinessError struct {
+	Msg string
+}
+
+func (e *BusinessError) Error() string {
+	return e.Msg
+}
+
+func businessErrorf(format string, args ...interface{}) error {
+	return &BusinessError{Msg: fmt.Sprintf(format, args...)}
+}
+
+// binder разбирает значения формы или queryString, копя ошибки по всем полям
+type binder struct {
+	values url.Values
+	errs   ValidationError
+	// denied - ошибка доступа, важнее ошибок полей
+	denied error
+}
+
+// err возвращает ошибку доступа или ошибки полей
+func (b *binder) err() error {
+	if b.denied != nil {
+		return b.denied
+	}
+	return b.errs.err()
+}
+
+// userID - пользователь запроса: из пути /users/{id}/..., иначе из поля user_id.
+// Поле обязательно, если аутентификация выключена, иначе по умолчанию берётся пользователь из токена
+func (b *binder) userID(r *http.Request) int {
+	if id := pathParam(r, "id"); id != "" {
+		b.values.Set("user_id", id)
+	}
+	_, authenticated := actingUser(r.Context())
+	userID := b.int("user_id", !authenticated)
+	if err := authorize(r, &userID); err != nil {
+		b.denied = err
+	}
+	return userID
+}
+
+func (b *binder) str(field string) string {
+	return strings.TrimSpace(b.values.Get(field))
+}
+
+func (b *binder) int(field string, required bool) int {
+	v := b.str(field)
+	if v == "" {
+		if required {
+			b.errs.add(field, "is required")
+		}
+		return 0
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		b.errs.add(field, "must be an integer")
+	}
+	return n
+}
+
+func (b *binder) bool(field string) bool {
+	v := b.str(field)
+	if v == "" {
+		return false
+	}
+
+	res, err := strconv.ParseBool(v)
+	if err != nil {
+		b.errs.add(field, "must be a boolean")
+	}
+	return res
+}
+
+func (b *binder) list(field string) []string {
+	v := b.str(field)
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+// ints разбирает список целых через запятую
+func (b *binder) ints(field string) []int {
+	var res []int
+	for _, v := range b.list(field) {
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil {
+			b.errs.add(field, "must be a comma separated list of integers")
+			return nil
+		}
+		res = append(res, n)
+	}
+	return res
+}
+
+// duration разбирает длительность вида 1h30m
+func (b *binder) duration(field string, required bool) time.Duration {
+	v := b.str(field)
+	if v == "" {
+		if required {
+			b.errs.add(field, "is required")
+		}
+		return 0
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		b.errs.add(field, "must be a duration, e.g. 30m or 1h30m")
+	}
+	return d
+}
+
+// location разбирает часовой пояс IANA, по умолчанию UTC
+func (b *binder) location(field string) *time.Location {
+	v := b.str(field)
+	if v == "" {
+		return time.UTC
+	}
+
+	loc, err := time.LoadLocation(v)
+	if err != nil {
+		b.errs.add(field, "unknown time zone")
+		return time.UTC
+	}
+	return loc
+}
+
+// time разбирает дату в формате RFC 3339 или 2006-01-02
+func (b *binder) time(field string, loc *time.Location, required bool) time.Time {
+	v := b.str(field)
+	if v == "" {
+		if required {
+			b.errs.add(field, "is required")
+		}
+		return time.Time{}
+	}
+
+	t, err := parseDate(v, loc)
+	if err != nil {
+		b.errs.add(field, "must be a date (2006-01-02) or RFC 3339 time")
+	}
+	return t
+}
+
+func (b *binder) timePtr(field string, loc *time.Location) *time.Time {
+	if b.str(field) == "" {
+		return nil
+	}
+	t := b.time(field, loc, false)
+	return &t
+}
+
+// bindForm заполняет событие из www-url-form-encoded полей.
+// Правило повторения задаётся плоскими полями freq, interval, by_day, count, until.
+func (ev *Event) bindForm(form url.Values) error {
+	b := binder{values: form}
+
+	ev.UserID = b.int("user_id", false)
+	ev.EventID = b.int("event_id", false)
+	ev.Title = form.Get("title")
+	ev.Description = form.Get("description")
+	ev.TimeZone = b.str("time_zone")
+	ev.AllDay = b.bool("all_day")
+	ev.Reminders = b.ints("reminders")
+	ev.CalendarID = b.int("calendar_id", false)
+	ev.Tags = b.list("tags")
+	for _, userID := range b.ints("attendees") {
+		ev.Attendees = append(ev.Attendees, Attendee{UserID: userID})
+	}
+	ev.Version = b.int("version", false)
+
+	if b.str("freq") != "" {
+		ev.Recurrence = &Recurrence{
+			Freq:     strings.ToLower(b.str("freq")),
+			Interval: b.int("interval", false),
+			ByDay:    b.list("by_day"),
+			Count:    b.int("count", false),
+			Until:    b.timePtr("until", b.location("time_zone")),
+		}
+	}
+
+	b.errs.merge(ev.setTimes(b.str("date"), b.str("end")))
+	b.errs.merge(ev.setRecurrenceID(b.str("recurrence_id")))
+
+	return b.errs.err()
+}
+
+// formValues разбирает тело запроса с плоскими полями: форму или JSON объект со скалярными значениями
+func formValues(r *http.Request) (url.Values, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		if err := r.ParseForm(); err != nil {
+			return nil, bodyError(err)
+		}
+		return r.PostForm, nil
+	}
+
+	var fields map[string]interface{}
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&fields); err != nil {
+		return nil, bodyError(err)
+	}
+
+	values := make(url.Values, len(fields))
+	for k, v := range fields {
+		switch v.(type) {
+		case string, json.Number, bool:
+			values.Set(k, fmt.Sprint(v))
+		default:
+			return nil, &FieldError{Field: k, Reason: "must be a scalar"}
+		}
+	}
+	return values, nil
+}
+
+// peekedBody - тело запроса, из которого уже прочитано начало для определения формата
+type peekedBody struct {
+	*bufio.Reader
+	io.Closer
+}
+
+// bindEvent разбирает событие из тела запроса по Content-Type и проверяет его функцией check.
+// Без Content-Type тело, начинающееся с '{', считается JSON для совместимости со старыми клиентами.
+func bindEvent(r *http.Request, ev *Event, check func(*Event) error) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	if mediaType == "" {
+		body := bufio.NewReader(r.Body)
+		if first, err := body.Peek(1); err == nil && first[0] == '{' {
+			mediaType = "application/json"
+		} else {
+			mediaType = "application/x-www-form-urlencoded"
+		}
+		r.Body = peekedBody{Reader: body, Closer: r.Body}
+		r.Header.Set("Content-Type", mediaType)
+	}
+
+	var errs ValidationError
+	switch mediaType {
+	case "application/json":
+		// тело, которое не удалось разобрать, проверять дальше бессмысленно
+		if err := ev.decode(r.Body); err != nil {
+			return err
+		}
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		// ParseMultipartForm теряет ошибки чтения обычной формы, поэтому она разбирается отдельно
+		parse := r.ParseForm
+		if mediaType == "multipart/form-data" {
+			parse = func() error { return r.ParseMultipartForm(maxImportSize) }
+		}
+		if err := parse(); err != nil {
+			return bodyError(err)
+		}
+		errs.merge(ev.bindForm(r.PostForm))
+	default:
+		errs.add("content_type", fmt.Sprintf("unsupported %q", mediaType))
+		return &errs
+	}
+
+	if err := ev.applyPath(r); err != nil {
+		errs.merge(err)
+		return &errs
+	}
+	if err := authorize(r, &ev.UserID); err != nil {
+		return err
+	}
+	if check != nil {
+		errs.merge(check(ev))
+	}
+
+	return errs.err()
+}
+
+// validateRef проверяет ссылку на событие: достаточно user_id и event_id (удаление)
+func (ev *Event) validateRef() error {
+	var errs ValidationError
+	if ev.UserID <= 0 {
+		errs.add("user_id", "must be positive")
+	}
+	if ev.EventID <= 0 {
+		errs.add("event_id", "must be positive")
+	}
+	return errs.err()
+}
+
+// validateUpdate - как validate, но event_id обязателен
+func (ev *Event) validateUpdate() error {
+	var errs ValidationError
+	errs.merge(ev.validate())
+	if ev.EventID == 0 {
+		errs.add("event_id", "must be positive")
+	}
+	return errs.err()
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// errorFields - поля ошибки входных данных, nil - если ошибки нет
+func errorFields(err error) []string {
+	if err == nil {
+		return nil
+	}
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		return []string{err.Error()}
+	}
+	var fields []string
+	for _, f := range ve.Fields {
+		fields = append(fields, f.Field)
+	}
+	return fields
+}
+
+func TestBindRecurrenceID(t *testing.T) {
+	msk, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		timeZone string
+		value    string
+		want     time.Time
+		field    string
+	}{
+		{"rfc3339", "", "2024-01-10T10:00:00Z", time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC), ""},
+		{"date in utc", "", "2024-01-10", time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), ""},
+		{"date in the event zone", "Europe/Moscow", "2024-01-10", time.Date(2024, 1, 10, 0, 0, 0, 0, msk), ""},
+		{"offset wins over the zone", "Europe/Moscow", "2024-01-10T10:00:00Z", time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC), ""},
+		{"bad layout", "Europe/Moscow", "10.01.2024", time.Time{}, "recurrence_id"},
+		{"unknown zone", "Mars/Olympus", "2024-01-10", time.Time{}, "time_zone"},
+	}
+
+	check := func(t *testing.T, ev *Event, err error, want time.Time, field string) {
+		t.Helper()
+		if field != "" {
+			if fields := errorFields(err); len(fields) != 1 || fields[0] != field {
+				t.Errorf("errors %v, want %v", fields, field)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.RecurrenceID == nil || !ev.RecurrenceID.Equal(want) {
+			t.Errorf("recurrence_id %v, want %v", ev.RecurrenceID, want)
+		}
+	}
+
+	for _, tt := range tests {
+		t.Run("form/"+tt.name, func(t *testing.T) {
+			form := url.Values{"user_id": {"1"}, "event_id": {"1"}, "date": {"2024-01-10"}, "recurrence_id": {tt.value}}
+			if tt.timeZone != "" {
+				form.Set("time_zone", tt.timeZone)
+			}
+			var ev Event
+			check(t, &ev, ev.bindForm(form), tt.want, tt.field)
+		})
+
+		t.Run("json/"+tt.name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]interface{}{
+				"user_id": 1, "event_id": 1, "date": "2024-01-10", "time_zone": tt.timeZone, "recurrence_id": tt.value,
+			})
+			var ev Event
+			check(t, &ev, ev.decode(strings.NewReader(string(body))), tt.want, tt.field)
+		})
+	}
+}
+
+func TestBindRecurrenceIDMatchesOccurrence(t *testing.T) {
+	ts := newTestServer(t)
+	series := ts.create(t, url.Values{
+		"user_id": {"1"}, "title": {"Дежурство"}, "date": {"2024-01-08"}, "all_day": {"true"},
+		"time_zone": {"Europe/Moscow"}, "freq": {"daily"}, "count": {"5"},
+	})
+
+	// дата без времени - полночь в поясе серии, то есть начало вхождения
+	resp, res := ts.form(t, "/delete_event", url.Values{
+		"user_id": {"1"}, "event_id": {fmt.Sprint(series.EventID)}, "recurrence_id": {"2024-01-09"}, "time_zone": {"Europe/Moscow"},
+	})
+	wantStatus(t, resp, res, http.StatusOK)
+
+	resp, _, _ = ts.do(t, http.MethodPut, "/users/1/events/"+fmt.Sprint(series.EventID), "application/json",
+		`{"title":"Подмена","date":"2024-01-10","all_day":true,"time_zone":"Europe/Moscow","recurrence_id":"2024-01-10"}`)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("update occurrence: %v", resp.StatusCode)
+	}
+
+	resp, res = ts.get(t, "/events?user_id=1&from=2024-01-08T00:00:00%2B03:00&to=2024-01-13T00:00:00%2B03:00")
+	wantStatus(t, resp, res, http.StatusOK)
+	var titles []string
+	for _, ev := range res.Events {
+		titles = append(titles, ev.Date.Format("02")+" "+ev.Title)
+	}
+	if got := strings.Join(titles, ", "); got != "08 Дежурство, 10 Подмена, 11 Дежурство, 12 Дежурство" {
+		t.Errorf("occurrences: %v", got)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Ограничения календарей и меток
+const (
+	maxCalendarName = 100
+	maxTags         = 20
+	maxTagLength    = 50
+)
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// Calendar - именованный календарь пользователя (работа, личное, дежурства).
+// События без calendar_id лежат в основном календаре с идентификатором 0, его создавать не нужно
+type Calendar struct {
+	UserID     int    `json:"user_id"`
+	CalendarID int    `json:"calendar_id"`
+	Name       string `json:"name"`
+	// Color - цвет календаря в интерфейсе, #rrggbb
+	Color       string `json:"color,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// validate проверяет календарь, calendar_id 0 - идентификатор назначит сервер
+func (c *Calendar) validate() error {
+	var errs ValidationError
+	if c.UserID <= 0 {
+		errs.add("user_id", "must be positive")
+	}
+	if c.CalendarID < 0 {
+		errs.add("calendar_id", "must not be negative")
+	}
+	switch {
+	case c.Name == "":
+		errs.add("name", "is required")
+	case utf8.RuneCountInString(c.Name) > maxCalendarName:
+		errs.add("name", fmt.Sprintf("must be at most %v characters", maxCalendarName))
+	}
+	if c.Color != "" && !colorPattern.MatchString(c.Color) {
+		errs.add("color", "must be #rrggbb")
+	}
+	return errs.err()
+}
+
+// validateUpdate - как validate, но calendar_id обязателен
+func (c *Calendar) validateUpdate() error {
+	var errs ValidationError
+	errs.merge(c.validate())
+	if c.CalendarID == 0 {
+		errs.add("calendar_id", "is required")
+	}
+	return errs.err()
+}
+
+// validateRef проверяет ссылку на календарь (удаление)
+func (c *Calendar) validateRef() error {
+	var errs ValidationError
+	if c.UserID <= 0 {
+		errs.add("user_id", "must be positive")
+	}
+	if c.CalendarID <= 0 {
+		errs.add("calendar_id", "must be positive")
+	}
+	return errs.err()
+}
+
+// validateTags проверяет calendar_id и метки события, пробелы по краям меток отбрасываются.
+// Метки сравниваются без учёта регистра
+func (ev *Event) validateTags() error {
+	var errs ValidationError
+	if ev.CalendarID < 0 {
+		errs.add("calendar_id", "must not be negative")
+	}
+	if len(ev.Tags) > maxTags {
+		errs.add("tags", fmt.Sprintf("must have at most %v items", maxTags))
+	}
+
+	seen := make(map[string]bool, len(ev.Tags))
+	for i, tag := range ev.Tags {
+		tag = strings.TrimSpace(tag)
+		ev.Tags[i] = tag
+		switch {
+		case tag == "":
+			errs.add("tags", "must not be empty")
+		case utf8.RuneCountInString(tag) > maxTagLength:
+			errs.add("tags", fmt.Sprintf("must be at most %v characters", maxTagLength))
+		case strings.Contains(tag, ","):
+			errs.add("tags", "must not contain commas")
+		case seen[strings.ToLower(tag)]:
+			errs.add("tags", fmt.Sprintf("duplicate %q", tag))
+		}
+		seen[strings.ToLower(tag)] = true
+	}
+	return errs.err()
+}
+
+// hasTag проверяет метку события без учёта регистра
+func (ev *Event) hasTag(tag string) bool {
+	for _, t := range ev.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// EventFilter - отбор событий по календарям и меткам, пустой набор не ограничивает.
+// Календари - это календари пользователя UserID, поэтому с фильтром по календарям
+// события, куда он приглашён, не попадают в выборку
+type EventFilter struct {
+	UserID    int
+	Calendars []int
+	// Tags - событие подходит, если у него есть хотя бы одна из меток
+	Tags []string
+}
+
+// parseEventFilter разбирает calendars и tags - списки через запятую
+func parseEventFilter(b *binder, userID int) EventFilter {
+	f := EventFilter{UserID: userID, Calendars: b.ints("calendars")}
+	for _, tag := range b.list("tags") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			f.Tags = append(f.Tags, tag)
+		}
+	}
+	return f
+}
+
+func (f *EventFilter) matches(ev *Event) bool {
+	if len(f.Calendars) > 0 {
+		if ev.UserID != f.UserID {
+			return false
+		}
+		found := false
+		for _, id := range f.Calendars {
+			found = found || id == ev.CalendarID
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.Tags) == 0 {
+		return true
+	}
+	for _, tag := range f.Tags {
+		if ev.hasTag(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply оставляет подходящие события
+func (f *EventFilter) apply(events []Event) []Event {
+	if len(f.Calendars) == 0 && len(f.Tags) == 0 {
+		return events
+	}
+	var res []Event
+	for i := range events {
+		if f.matches(&events[i]) {
+			res = append(res, events[i])
+		}
+	}
+	return res
+}
+
+// bindCalendar разбирает календарь из формы или плоского JSON и проверяет его функцией check
+func bindCalendar(r *http.Request, c *Calendar, check func(*Calendar) error) error {
+	values, err := formValues(r)
+	if err != nil {
+		return err
+	}
+
+	b := binder{values: values}
+	c.UserID = b.userID(r)
+	c.CalendarID = b.int("calendar_id", false)
+	c.Name = b.str("name")
+	c.Color = b.str("color")
+	c.Description = values.Get("description")
+	if err := b.err(); err != nil {
+		return err
+	}
+	return check(c)
+}
+
+// calendarsResponse - успешный ответ с календарями
+func calendarsResponse(w http.ResponseWriter, result string, calendars []Calendar, status int) {
+	resp := struct {
+		Result    string     `json:"result"`
+		Calendars []Calendar `json:"calendars"`
+	}{Result: result, Calendars: calendars}
+
+	writeJSON(w, resp, status)
+}
+
+// CalendarsHandler /calendars handler: календари пользователя
+func (srv *Server) CalendarsHandler(w http.ResponseWriter, r *http.Request) {
+	b := binder{values: r.URL.Query()}
+	userID := b.userID(r)
+	if err := b.err(); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	calendars, err := srv.storage.getCalendars(userID)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	calendarsResponse(w, "Запрос успешно выполнен!", calendars, http.StatusOK)
+}
+
+// CreateCalendarHandler /create_calendar handler
+func (srv *Server) CreateCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	var c Calendar
+	if err := bindCalendar(r, &c, (*Calendar).validate); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	if err := srv.storage.CreateCalendar(&c); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	calendarsResponse(w, "Календарь создан!", []Calendar{c}, http.StatusCreated)
+}
+
+// UpdateCalendarHandler /update_calendar handler
+func (srv *Server) UpdateCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	var c Calendar
+	if err := bindCalendar(r, &c, (*Calendar).validateUpdate); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	if err := srv.storage.UpdateCalendar(&c); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	calendarsResponse(w, "Календарь обновлён!", []Calendar{c}, http.StatusOK)
+}
+
+// DeleteCalendarHandler /delete_calendar handler, удалить можно только календарь без событий
+func (srv *Server) DeleteCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	var c Calendar
+	if err := bindCalendar(r, &c, (*Calendar).validateRef); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	deleted, err := srv.storage.DeleteCalendar(c.UserID, c.CalendarID)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	calendarsResponse(w, "Календарь удалён!", []Calendar{*deleted}, http.StatusOK)
+}
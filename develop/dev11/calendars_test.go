@@ -0,0 +1,178 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalendarValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		cal    Calendar
+		check  func(*Calendar) error
+		fields string
+	}{
+		{"valid", Calendar{UserID: 1, Name: "Работа", Color: "#A0b1C2"}, (*Calendar).validate, ""},
+		{"no name", Calendar{UserID: 1}, (*Calendar).validate, "name"},
+		{"long name in runes", Calendar{UserID: 1, Name: strings.Repeat("ж", maxCalendarName+1)}, (*Calendar).validate, "name"},
+		{"longest name", Calendar{UserID: 1, Name: strings.Repeat("ж", maxCalendarName)}, (*Calendar).validate, ""},
+		{"short color", Calendar{UserID: 1, Name: "x", Color: "#fff"}, (*Calendar).validate, "color"},
+		{"named color", Calendar{UserID: 1, Name: "x", Color: "red"}, (*Calendar).validate, "color"},
+		{"all fields", Calendar{CalendarID: -1, Color: "#ggg000"}, (*Calendar).validate, "user_id,calendar_id,name,color"},
+		{"update without id", Calendar{UserID: 1, Name: "x"}, (*Calendar).validateUpdate, "calendar_id"},
+		{"delete by ref", Calendar{UserID: 1, CalendarID: 2}, (*Calendar).validateRef, ""},
+		{"delete default calendar", Calendar{UserID: 1}, (*Calendar).validateRef, "calendar_id"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strings.Join(errorFields(tt.check(&tt.cal)), ","); got != tt.fields {
+				t.Errorf("errors %q, want %q", got, tt.fields)
+			}
+		})
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	many := make([]string, maxTags+1)
+	for i := range many {
+		many[i] = fmt.Sprint("t", i)
+	}
+	tests := []struct {
+		name   string
+		tags   []string
+		want   []string
+		fields string
+	}{
+		{"trimmed", []string{" срочно ", "отчёт"}, []string{"срочно", "отчёт"}, ""},
+		{"duplicate ignoring case", []string{"Срочно", "срочно"}, nil, "tags"},
+		{"empty", []string{" "}, nil, "tags"},
+		{"comma", []string{"a,b"}, nil, "tags"},
+		{"long", []string{strings.Repeat("ж", maxTagLength+1)}, nil, "tags"},
+		{"too many", many, nil, "tags"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := Event{Tags: tt.tags}
+			if got := strings.Join(errorFields(ev.validateTags()), ","); got != tt.fields {
+				t.Errorf("errors %q, want %q", got, tt.fields)
+			}
+			if tt.want != nil && strings.Join(ev.Tags, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("tags %q", ev.Tags)
+			}
+		})
+	}
+}
+
+func TestEventFilter(t *testing.T) {
+	events := []Event{
+		{UserID: 1, EventID: 1, Title: "основной"},
+		{UserID: 1, EventID: 2, Title: "работа", CalendarID: 2, Tags: []string{"Срочно"}},
+		{UserID: 1, EventID: 3, Title: "дежурство", CalendarID: 3, Tags: []string{"ночь"}},
+		// приглашение от другого пользователя, его календарь 2 - не календарь 2 пользователя 1
+		{UserID: 2, EventID: 1, Title: "приглашение", CalendarID: 2, Tags: []string{"срочно"}},
+	}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"", "основной,работа,дежурство,приглашение"},
+		{"calendars=0", "основной"},
+		{"calendars=2,3", "работа,дежурство"},
+		{"tags=СРОЧНО", "работа,приглашение"},
+		{"tags=срочно,ночь", "работа,дежурство,приглашение"},
+		{"tags=+,+", "основной,работа,дежурство,приглашение"},
+		{"calendars=2&tags=ночь", ""},
+		{"calendars=3&tags=ночь", "дежурство"},
+	}
+	for _, tt := range tests {
+		values, _ := url.ParseQuery(tt.query)
+		b := binder{values: values}
+		f := parseEventFilter(&b, 1)
+		if err := b.err(); err != nil {
+			t.Fatalf("%v: %v", tt.query, err)
+		}
+		var titles []string
+		for _, ev := range f.apply(events) {
+			titles = append(titles, ev.Title)
+		}
+		if got := strings.Join(titles, ","); got != tt.want {
+			t.Errorf("%v: %q, want %q", tt.query, got, tt.want)
+		}
+	}
+
+	b := binder{values: url.Values{"calendars": {"1,x"}}}
+	parseEventFilter(&b, 1)
+	if got := errorFields(b.err()); len(got) != 1 || got[0] != "calendars" {
+		t.Errorf("bad calendars: %v", got)
+	}
+}
+
+func TestCalendarStorage(t *testing.T) {
+	s := newMemoryStorage(time.Now)
+	work := Calendar{UserID: 1, Name: "Работа"}
+	if err := s.CreateCalendar(&work); err != nil || work.CalendarID != 1 {
+		t.Fatalf("create: %v, %v", work.CalendarID, err)
+	}
+	empty := Calendar{UserID: 1, CalendarID: 5, Name: "Пустой"}
+	if err := s.CreateCalendar(&empty); err != nil {
+		t.Fatal(err)
+	}
+	ev := Event{UserID: 1, Title: "Отчёт", Date: time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC), CalendarID: work.CalendarID}
+	if _, err := s.Create(&ev, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name string
+		do   func() error
+		ok   bool
+	}{
+		{"next id after an explicit one", func() error {
+			c := Calendar{UserID: 1, Name: "Дежурства"}
+			if err := s.CreateCalendar(&c); err != nil || c.CalendarID != 6 {
+				return fmt.Errorf("id %v, %v", c.CalendarID, err)
+			}
+			return nil
+		}, true},
+		{"taken id", func() error { return s.CreateCalendar(&Calendar{UserID: 1, CalendarID: 5, Name: "Другой"}) }, false},
+		{"same name ignoring case", func() error { return s.CreateCalendar(&Calendar{UserID: 1, Name: "работа"}) }, false},
+		{"same name for another user", func() error { return s.CreateCalendar(&Calendar{UserID: 2, Name: "Работа"}) }, true},
+		{"rename to a taken name", func() error { return s.UpdateCalendar(&Calendar{UserID: 1, CalendarID: 5, Name: "РАБОТА"}) }, false},
+		{"recolor keeping the name", func() error {
+			return s.UpdateCalendar(&Calendar{UserID: 1, CalendarID: 1, Name: "Работа", Color: "#00ff00"})
+		}, true},
+		{"update unknown", func() error { return s.UpdateCalendar(&Calendar{UserID: 1, CalendarID: 9, Name: "x"}) }, false},
+		{"delete unknown", func() error { _, err := s.DeleteCalendar(1, 9); return err }, false},
+		{"delete with events", func() error { _, err := s.DeleteCalendar(1, 1); return err }, false},
+		{"delete with events in trash", func() error {
+			if _, err := s.Delete(&Event{UserID: 1, EventID: ev.EventID}); err != nil {
+				return err
+			}
+			_, err := s.DeleteCalendar(1, 1)
+			return err
+		}, false},
+		{"delete after purge", func() error {
+			if _, err := s.Purge(time.Now().Add(time.Minute)); err != nil {
+				return err
+			}
+			_, err := s.DeleteCalendar(1, 1)
+			return err
+		}, true},
+		{"delete empty", func() error { _, err := s.DeleteCalendar(1, 5); return err }, true},
+	}
+	for _, st := range steps {
+		err := st.do()
+		var be *BusinessError
+		if st.ok && err != nil || !st.ok && !errors.As(err, &be) {
+			t.Errorf("%v: %v", st.name, err)
+		}
+	}
+
+	if calendars, err := s.getCalendars(1); err != nil || len(calendars) != 1 || calendars[0].CalendarID != 6 {
+		t.Errorf("calendars %+v, %v", calendars, err)
+	}
+}
@@ -0,0 +1,183 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// maxFrame - предел размера кадра ответа
+const maxFrame = 64 << 20
+
+// binaryRequest - запрос бинарного протокола, поля совпадают с серверными по именам (gob)
+type binaryRequest struct {
+	ID        uint64
+	Method    string
+	Token     string
+	Event     Event
+	Reject    bool
+	UserID    int
+	Date      time.Time
+	From      time.Time
+	To        time.Time
+	Calendars []int
+	Tags      []string
+}
+
+// binaryResponse - ответ бинарного протокола
+type binaryResponse struct {
+	ID        uint64
+	Status    int
+	Events    []Event
+	Warnings  []Event
+	Error     string
+	Fields    []FieldError
+	Conflicts []Event
+}
+
+// BinaryClient - клиент бинарного протокола (binary_port): кадры из 4 байт длины и gob сообщения поверх TCP.
+// Запросы одного клиента выполняются по очереди, ошибки сервера - *APIError, как у Client
+type BinaryClient struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	r      *bufio.Reader
+	token  string
+	nextID uint64
+}
+
+// DialBinary подключается к addr, token нужен, если на сервере включена аутентификация
+func DialBinary(ctx context.Context, addr, token string) (*BinaryClient, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &BinaryClient{conn: conn, r: bufio.NewReader(conn), token: token}, nil
+}
+
+// Close закрывает соединение
+func (c *BinaryClient) Close() error {
+	return c.conn.Close()
+}
+
+// call отправляет запрос и ждёт ответа; дедлайн ctx действует на соединение
+func (c *BinaryClient) call(ctx context.Context, req binaryRequest) (*binaryResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deadline, _ := ctx.Deadline()
+	if err := c.conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	c.nextID++
+	req.ID = c.nextID
+	req.Token = c.token
+
+	var buf bytes.Buffer
+	buf.Write(make([]byte, 4))
+	if err := gob.NewEncoder(&buf).Encode(&req); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	binary.BigEndian.PutUint32(data, uint32(len(data)-4))
+	if _, err := c.conn.Write(data); err != nil {
+		return nil, err
+	}
+
+	var size uint32
+	if err := binary.Read(c.r, binary.BigEndian, &size); err != nil {
+		return nil, err
+	}
+	if size > maxFrame {
+		return nil, fmt.Errorf("dev11: response frame of %v bytes is too large", size)
+	}
+	data = make([]byte, size)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, err
+	}
+
+	var resp binaryResponse
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&resp); err != nil {
+		return nil, err
+	}
+	// ответ с ID 0 - отказ до чтения запроса, например сверх предела соединений сервера
+	if resp.ID == 0 && resp.Error != "" {
+		return nil, &APIError{StatusCode: resp.Status, Message: resp.Error}
+	}
+	if resp.ID != req.ID {
+		return nil, fmt.Errorf("dev11: response %v to request %v", resp.ID, req.ID)
+	}
+	if resp.Error != "" {
+		return nil, &APIError{StatusCode: resp.Status, Message: resp.Error, Fields: resp.Fields, Conflicts: resp.Conflicts}
+	}
+	return &resp, nil
+}
+
+func (c *BinaryClient) eventOp(ctx context.Context, method string, ev Event, reject bool) (*EventResult, error) {
+	resp, err := c.call(ctx, binaryRequest{Method: method, Event: ev, Reject: reject})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Events) == 0 {
+		return nil, fmt.Errorf("dev11: empty response")
+	}
+	return &EventResult{Event: resp.Events[0], Warnings: resp.Warnings}, nil
+}
+
+// CreateEvent создаёт событие, с reject пересечения с другими событиями - ошибка 409
+func (c *BinaryClient) CreateEvent(ctx context.Context, ev Event, reject bool) (*EventResult, error) {
+	return c.eventOp(ctx, "create", ev, reject)
+}
+
+// UpdateEvent заменяет событие, ev.Version - ожидаемая версия (0 - любая)
+func (c *BinaryClient) UpdateEvent(ctx context.Context, ev Event, reject bool) (*EventResult, error) {
+	return c.eventOp(ctx, "update", ev, reject)
+}
+
+// DeleteEvent переносит событие в корзину, version - ожидаемая версия (0 - любая)
+func (c *BinaryClient) DeleteEvent(ctx context.Context, userID, eventID, version int) (*Event, error) {
+	res, err := c.eventOp(ctx, "delete", Event{UserID: userID, EventID: eventID, Version: version}, false)
+	if err != nil {
+		return nil, err
+	}
+	return &res.Event, nil
+}
+
+func (c *BinaryClient) events(ctx context.Context, req binaryRequest, filters []Filter) ([]Event, error) {
+	for _, f := range filters {
+		req.Calendars, req.Tags = f.Calendars, f.Tags
+	}
+	resp, err := c.call(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Events, nil
+}
+
+// EventsForDay возвращает события за сутки date в поясе date
+func (c *BinaryClient) EventsForDay(ctx context.Context, userID int, date time.Time, filter ...Filter) ([]Event, error) {
+	return c.events(ctx, binaryRequest{Method: "events_for_day", UserID: userID, Date: date}, filter)
+}
+
+// EventsForWeek возвращает события за ISO неделю, в которую попадает date
+func (c *BinaryClient) EventsForWeek(ctx context.Context, userID int, date time.Time, filter ...Filter) ([]Event, error) {
+	return c.events(ctx, binaryRequest{Method: "events_for_week", UserID: userID, Date: date}, filter)
+}
+
+// EventsForMonth возвращает события за месяц, в который попадает date
+func (c *BinaryClient) EventsForMonth(ctx context.Context, userID int, date time.Time, filter ...Filter) ([]Event, error) {
+	return c.events(ctx, binaryRequest{Method: "events_for_month", UserID: userID, Date: date}, filter)
+}
+
+// EventsInRange возвращает вхождения событий в окне [from, to)
+func (c *BinaryClient) EventsInRange(ctx context.Context, userID int, from, to time.Time, filter ...Filter) ([]Event, error) {
+	return c.events(ctx, binaryRequest{Method: "events", UserID: userID, From: from, To: to}, filter)
+}
@@ -0,0 +1,622 @@
+// Package client - типизированный клиент HTTP API календаря dev11 (описание API - /openapi.json).
+//
+// Ответы сервера {"result": ...} разбираются в значения, {"error": ...} - в *APIError.
+// BinaryClient - то же для бинарного протокола (binary_port).
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const dateFormat = "2006-01-02"
+
+// Attendee - приглашённый пользователь и его ответ
+type Attendee struct {
+	UserID int    `json:"user_id"`
+	Status string `json:"status,omitempty"`
+}
+
+// Recurrence - правило повторения события
+type Recurrence struct {
+	Freq       string      `json:"freq"`
+	Interval   int         `json:"interval,omitempty"`
+	ByDay      []string    `json:"by_day,omitempty"`
+	Count      int         `json:"count,omitempty"`
+	Until      *time.Time  `json:"until,omitempty"`
+	Exceptions []time.Time `json:"exceptions,omitempty"`
+	Overrides  []Event     `json:"overrides,omitempty"`
+}
+
+// Event - событие календаря
+type Event struct {
+	UserID       int         `json:"user_id"`
+	EventID      int         `json:"event_id"`
+	Title        string      `json:"title"`
+	Description  string      `json:"description"`
+	Date         time.Time   `json:"date"`
+	End          *time.Time  `json:"end,omitempty"`
+	TimeZone     string      `json:"time_zone,omitempty"`
+	AllDay       bool        `json:"all_day,omitempty"`
+	Attendees    []Attendee  `json:"attendees,omitempty"`
+	Reminders    []int       `json:"reminders,omitempty"`
+	Recurrence   *Recurrence `json:"recurrence,omitempty"`
+	RecurrenceID *time.Time  `json:"recurrence_id,omitempty"`
+	CalendarID   int         `json:"calendar_id,omitempty"`
+	Tags         []string    `json:"tags,omitempty"`
+	Version      int         `json:"version,omitempty"`
+	UID          string      `json:"uid,omitempty"`
+}
+
+// Calendar - календарь пользователя, CalendarID 0 при создании назначит сервер
+type Calendar struct {
+	UserID      int    `json:"user_id"`
+	CalendarID  int    `json:"calendar_id"`
+	Name        string `json:"name"`
+	Color       string `json:"color,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Filter - отбор событий по календарям (0 - основной) и меткам, пустой набор не ограничивает
+type Filter struct {
+	Calendars []int
+	Tags      []string
+}
+
+// set добавляет параметры calendars и tags
+func (f *Filter) set(query url.Values) {
+	if len(f.Calendars) > 0 {
+		ids := make([]string, 0, len(f.Calendars))
+		for _, id := range f.Calendars {
+			ids = append(ids, strconv.Itoa(id))
+		}
+		query.Set("calendars", strings.Join(ids, ","))
+	}
+	if len(f.Tags) > 0 {
+		query.Set("tags", strings.Join(f.Tags, ","))
+	}
+}
+
+// TrashedEvent - событие в корзине
+type TrashedEvent struct {
+	Event
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// FieldChange - значение поля события до и после изменения в JSON
+type FieldChange struct {
+	Field string          `json:"field"`
+	Old   json.RawMessage `json:"old,omitempty"`
+	New   json.RawMessage `json:"new,omitempty"`
+}
+
+// Revision - изменение события: кто (UserID), когда и какие поля
+type Revision struct {
+	Version int           `json:"version"`
+	Op      string        `json:"op"`
+	Time    time.Time     `json:"time"`
+	UserID  int           `json:"user_id"`
+	Changes []FieldChange `json:"changes"`
+}
+
+// BatchOp - операция пакета: Op - create, update или delete
+type BatchOp struct {
+	Op    string `json:"op"`
+	Event Event  `json:"event"`
+}
+
+// BatchResult - результат операции пакета, Status - HTTP статус, как у одиночного запроса
+type BatchResult struct {
+	Op        string       `json:"op"`
+	Status    int          `json:"status"`
+	Event     *Event       `json:"event"`
+	Warnings  []Event      `json:"warnings"`
+	Error     string       `json:"error"`
+	Fields    []FieldError `json:"fields"`
+	Conflicts []Event      `json:"conflicts"`
+}
+
+// Interval - промежуток времени [Start, End)
+type Interval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// FieldError - ошибка в поле запроса
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// APIError - ответ сервера {"error": ...}
+type APIError struct {
+	StatusCode int
+	Message    string       `json:"error"`
+	Fields     []FieldError `json:"fields,omitempty"`
+	// Conflicts - пересекающиеся события при on_conflict=reject (409)
+	Conflicts []Event `json:"conflicts,omitempty"`
+	// Results - результаты операций сорвавшегося атомарного пакета
+	Results []BatchResult `json:"results,omitempty"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("dev11: %v %v", e.StatusCode, e.Message)
+}
+
+// IsStatus проверяет, что err - ответ сервера с кодом status
+func IsStatus(err error, status int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == status
+}
+
+// Client - клиент API. Нулевое значение не годится, используйте New
+type Client struct {
+	baseURL string
+	http    *http.Client
+	token   string
+}
+
+// Option - настройка клиента
+type Option func(*Client)
+
+// WithHTTPClient задаёт http.Client, по умолчанию с таймаутом 30 секунд
+func WithHTTPClient(c *http.Client) Option {
+	return func(cl *Client) {
+		cl.http = c
+	}
+}
+
+// WithToken задаёт bearer токен, если он уже есть
+func WithToken(token string) Option {
+	return func(cl *Client) {
+		cl.token = token
+	}
+}
+
+// New создаёт клиент сервера baseURL, например http://localhost:8080
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{baseURL: strings.TrimRight(baseURL, "/"), http: &http.Client{Timeout: 30 * time.Second}}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// EventResult - сохранённое событие и пересекающиеся с ним события
+type EventResult struct {
+	Event    Event
+	Warnings []Event
+}
+
+// eventsEnvelope - успешный ответ с событиями
+type eventsEnvelope struct {
+	Result     string  `json:"result"`
+	Events     []Event `json:"events"`
+	Warnings   []Event `json:"warnings"`
+	NextCursor string  `json:"next_cursor"`
+}
+
+// do выполняет запрос и разбирает ответ в out, ответ с кодом не 2xx - в *APIError
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, header http.Header, body interface{}, out interface{}) error {
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u, reader)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != nil && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		apiErr := &APIError{StatusCode: resp.StatusCode}
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return apiErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+func eventPath(userID, eventID int) string {
+	return fmt.Sprintf("/users/%d/events/%d", userID, eventID)
+}
+
+// ifMatch - заголовок If-Match по версии события, версия 0 - без проверки
+func ifMatch(version int) http.Header {
+	if version == 0 {
+		return nil
+	}
+	return http.Header{"If-Match": []string{strconv.Quote(strconv.Itoa(version))}}
+}
+
+func conflictQuery(reject bool) url.Values {
+	if !reject {
+		return nil
+	}
+	return url.Values{"on_conflict": []string{"reject"}}
+}
+
+func single(env *eventsEnvelope) (*EventResult, error) {
+	if len(env.Events) == 0 {
+		return nil, fmt.Errorf("dev11: empty response")
+	}
+	return &EventResult{Event: env.Events[0], Warnings: env.Warnings}, nil
+}
+
+// Login получает токен по логину и паролю и использует его в следующих запросах
+func (c *Client) Login(ctx context.Context, login, password string) (string, error) {
+	var resp struct {
+		Token string `json:"token"`
+	}
+	creds := map[string]string{"login": login, "password": password}
+	if err := c.do(ctx, http.MethodPost, "/login", nil, nil, creds, &resp); err != nil {
+		return "", err
+	}
+	c.token = resp.Token
+	return resp.Token, nil
+}
+
+// CreateEvent создаёт событие; с EventID == 0 идентификатор назначает сервер.
+// С reject пересечение с другими событиями - ошибка 409, иначе пересечения возвращаются в Warnings
+func (c *Client) CreateEvent(ctx context.Context, ev Event, reject bool) (*EventResult, error) {
+	path := fmt.Sprintf("/users/%d/events", ev.UserID)
+	if ev.EventID != 0 {
+		path = eventPath(ev.UserID, ev.EventID)
+	}
+
+	var env eventsEnvelope
+	if err := c.do(ctx, http.MethodPost, path, conflictQuery(reject), nil, ev, &env); err != nil {
+		return nil, err
+	}
+	return single(&env)
+}
+
+// UpdateEvent заменяет событие целиком. Если ev.Version задан, изменение применится,
+// только если событие не менялось с этой версии (иначе ошибка 412)
+func (c *Client) UpdateEvent(ctx context.Context, ev Event, reject bool) (*EventResult, error) {
+	var env eventsEnvelope
+	if err := c.do(ctx, http.MethodPut, eventPath(ev.UserID, ev.EventID), conflictQuery(reject), ifMatch(ev.Version), ev, &env); err != nil {
+		return nil, err
+	}
+	return single(&env)
+}
+
+// PatchEvent меняет только переданные поля события (JSON merge patch)
+func (c *Client) PatchEvent(ctx context.Context, userID, eventID, version int, fields map[string]interface{}) (*EventResult, error) {
+	header := ifMatch(version)
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Type", "application/merge-patch+json")
+
+	var env eventsEnvelope
+	if err := c.do(ctx, http.MethodPatch, eventPath(userID, eventID), nil, header, fields, &env); err != nil {
+		return nil, err
+	}
+	return single(&env)
+}
+
+// DeleteEvent переносит событие в корзину и возвращает его, version 0 - без проверки версии
+func (c *Client) DeleteEvent(ctx context.Context, userID, eventID, version int) (*Event, error) {
+	var env eventsEnvelope
+	if err := c.do(ctx, http.MethodDelete, eventPath(userID, eventID), nil, ifMatch(version), nil, &env); err != nil {
+		return nil, err
+	}
+	res, err := single(&env)
+	if err != nil {
+		return nil, err
+	}
+	return &res.Event, nil
+}
+
+// GetEvent возвращает событие по идентификатору
+func (c *Client) GetEvent(ctx context.Context, userID, eventID int) (*Event, error) {
+	var env eventsEnvelope
+	if err := c.do(ctx, http.MethodGet, eventPath(userID, eventID), nil, nil, nil, &env); err != nil {
+		return nil, err
+	}
+	res, err := single(&env)
+	if err != nil {
+		return nil, err
+	}
+	return &res.Event, nil
+}
+
+// RSVP отвечает на приглашение в событие организатора: accepted, declined или tentative
+func (c *Client) RSVP(ctx context.Context, userID, organizerID, eventID int, status string) (*Event, error) {
+	body := map[string]interface{}{"user_id": userID, "organizer_id": organizerID, "event_id": eventID, "status": status}
+
+	var env eventsEnvelope
+	if err := c.do(ctx, http.MethodPost, "/rsvp_event", nil, nil, body, &env); err != nil {
+		return nil, err
+	}
+	res, err := single(&env)
+	if err != nil {
+		return nil, err
+	}
+	return &res.Event, nil
+}
+
+func (c *Client) eventsFor(ctx context.Context, path string, userID int, date time.Time, filters []Filter) ([]Event, error) {
+	query := url.Values{
+		"user_id": []string{strconv.Itoa(userID)},
+		"date":    []string{date.Format(dateFormat)},
+		"tz":      []string{date.Location().String()},
+	}
+	for i := range filters {
+		filters[i].set(query)
+	}
+
+	var env eventsEnvelope
+	if err := c.do(ctx, http.MethodGet, path, query, nil, nil, &env); err != nil {
+		return nil, err
+	}
+	return env.Events, nil
+}
+
+// Trash возвращает события в корзине пользователя
+func (c *Client) Trash(ctx context.Context, userID int) ([]TrashedEvent, error) {
+	query := url.Values{"user_id": []string{strconv.Itoa(userID)}}
+
+	var resp struct {
+		Trash []TrashedEvent `json:"trash"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/trash", query, nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Trash, nil
+}
+
+// RestoreEvent возвращает событие из корзины, пересечения с другими событиями - в Warnings
+func (c *Client) RestoreEvent(ctx context.Context, userID, eventID int) (*EventResult, error) {
+	body := map[string]interface{}{"user_id": userID, "event_id": eventID}
+
+	var env eventsEnvelope
+	if err := c.do(ctx, http.MethodPost, "/restore_event", nil, nil, body, &env); err != nil {
+		return nil, err
+	}
+	return single(&env)
+}
+
+// EventHistory возвращает изменения события по возрастанию версии
+func (c *Client) EventHistory(ctx context.Context, userID, eventID int) ([]Revision, error) {
+	query := url.Values{
+		"user_id":  []string{strconv.Itoa(userID)},
+		"event_id": []string{strconv.Itoa(eventID)},
+	}
+
+	var resp struct {
+		History []Revision `json:"history"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/event_history", query, nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.History, nil
+}
+
+// Batch выполняет пакет операций. Без atomic результаты операций независимы; с atomic ошибка любой
+// операции откатывает пакет и возвращается *APIError, результаты операций - в его Results
+func (c *Client) Batch(ctx context.Context, ops []BatchOp, atomic, reject bool) ([]BatchResult, error) {
+	query := conflictQuery(reject)
+	if atomic {
+		if query == nil {
+			query = url.Values{}
+		}
+		query.Set("atomic", "true")
+	}
+	body := map[string]interface{}{"operations": ops}
+
+	var resp struct {
+		Results []BatchResult `json:"results"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/events/batch", query, nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Results, nil
+}
+
+// EventsForDay возвращает события за сутки date в поясе date, filter необязателен
+func (c *Client) EventsForDay(ctx context.Context, userID int, date time.Time, filter ...Filter) ([]Event, error) {
+	return c.eventsFor(ctx, "/events_for_day", userID, date, filter)
+}
+
+// EventsForWeek возвращает события за ISO неделю, в которую попадает date
+func (c *Client) EventsForWeek(ctx context.Context, userID int, date time.Time, filter ...Filter) ([]Event, error) {
+	return c.eventsFor(ctx, "/events_for_week", userID, date, filter)
+}
+
+// EventsForMonth возвращает события за месяц, в который попадает date
+func (c *Client) EventsForMonth(ctx context.Context, userID int, date time.Time, filter ...Filter) ([]Event, error) {
+	return c.eventsFor(ctx, "/events_for_month", userID, date, filter)
+}
+
+// Calendars возвращает календари пользователя кроме основного
+func (c *Client) Calendars(ctx context.Context, userID int) ([]Calendar, error) {
+	query := url.Values{"user_id": []string{strconv.Itoa(userID)}}
+
+	var resp struct {
+		Calendars []Calendar `json:"calendars"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/calendars", query, nil, nil, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Calendars, nil
+}
+
+// calendarOp выполняет операцию с календарём и возвращает календарь из ответа
+func (c *Client) calendarOp(ctx context.Context, path string, cal Calendar) (*Calendar, error) {
+	var resp struct {
+		Calendars []Calendar `json:"calendars"`
+	}
+	if err := c.do(ctx, http.MethodPost, path, nil, nil, cal, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Calendars) == 0 {
+		return nil, fmt.Errorf("dev11: empty response")
+	}
+	return &resp.Calendars[0], nil
+}
+
+// CreateCalendar создаёт календарь
+func (c *Client) CreateCalendar(ctx context.Context, cal Calendar) (*Calendar, error) {
+	return c.calendarOp(ctx, "/create_calendar", cal)
+}
+
+// UpdateCalendar меняет название, цвет и описание календаря
+func (c *Client) UpdateCalendar(ctx context.Context, cal Calendar) (*Calendar, error) {
+	return c.calendarOp(ctx, "/update_calendar", cal)
+}
+
+// DeleteCalendar удаляет календарь, в котором нет событий
+func (c *Client) DeleteCalendar(ctx context.Context, userID, calendarID int) (*Calendar, error) {
+	return c.calendarOp(ctx, "/delete_calendar", Calendar{UserID: userID, CalendarID: calendarID})
+}
+
+// Query - параметры выборки Events
+type Query struct {
+	UserID int
+	From   time.Time
+	To     time.Time
+	Text   string
+	Desc   bool
+	Limit  int
+	Cursor string
+	Filter Filter
+}
+
+// Page - страница выборки, NextCursor пуст на последней странице
+type Page struct {
+	Events     []Event
+	NextCursor string
+}
+
+// Events возвращает страницу событий за период
+func (c *Client) Events(ctx context.Context, q Query) (*Page, error) {
+	query := url.Values{
+		"user_id": []string{strconv.Itoa(q.UserID)},
+		"from":    []string{q.From.Format(time.RFC3339)},
+		"to":      []string{q.To.Format(time.RFC3339)},
+	}
+	if q.Text != "" {
+		query.Set("q", q.Text)
+	}
+	if q.Desc {
+		query.Set("sort", "desc")
+	}
+	if q.Limit > 0 {
+		query.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Cursor != "" {
+		query.Set("cursor", q.Cursor)
+	}
+	q.Filter.set(query)
+
+	var env eventsEnvelope
+	if err := c.do(ctx, http.MethodGet, "/events", query, nil, nil, &env); err != nil {
+		return nil, err
+	}
+	return &Page{Events: env.Events, NextCursor: env.NextCursor}, nil
+}
+
+// FreeBusy возвращает занятые и свободные промежутки за период
+func (c *Client) FreeBusy(ctx context.Context, userID int, from, to time.Time) (busy, free []Interval, err error) {
+	query := url.Values{
+		"user_id": []string{strconv.Itoa(userID)},
+		"from":    []string{from.Format(time.RFC3339)},
+		"to":      []string{to.Format(time.RFC3339)},
+	}
+
+	var resp struct {
+		Busy []Interval `json:"busy"`
+		Free []Interval `json:"free"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/free_busy", query, nil, nil, &resp); err != nil {
+		return nil, nil, err
+	}
+	return resp.Busy, resp.Free, nil
+}
+
+// SlotRequest - параметры SuggestSlot, Step и Limit по умолчанию 30m и 10
+type SlotRequest struct {
+	UserID    int
+	Attendees []int
+	From      time.Time
+	To        time.Time
+	Duration  time.Duration
+	Step      time.Duration
+	Limit     int
+}
+
+// SuggestSlot подбирает время, когда организатор и участники свободны в рабочее время и не в праздники
+func (c *Client) SuggestSlot(ctx context.Context, req SlotRequest) ([]Interval, error) {
+	body := map[string]interface{}{
+		"user_id":  req.UserID,
+		"from":     req.From.Format(time.RFC3339),
+		"to":       req.To.Format(time.RFC3339),
+		"tz":       req.From.Location().String(),
+		"duration": req.Duration.String(),
+	}
+	if len(req.Attendees) > 0 {
+		ids := make([]string, 0, len(req.Attendees))
+		for _, id := range req.Attendees {
+			ids = append(ids, strconv.Itoa(id))
+		}
+		body["attendees"] = strings.Join(ids, ",")
+	}
+	if req.Step > 0 {
+		body["step"] = req.Step.String()
+	}
+	if req.Limit > 0 {
+		body["limit"] = req.Limit
+	}
+
+	var resp struct {
+		Slots []Interval `json:"slots"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/suggest_slot", nil, nil, body, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Slots, nil
+}
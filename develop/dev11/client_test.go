@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"dev11/client"
+)
+
+func TestClientEventLifecycle(t *testing.T) {
+	ts := newTestServer(t)
+	c := client.New(ts.URL, client.WithHTTPClient(ts.Client()))
+	ctx := context.Background()
+	date := time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)
+
+	created, err := c.CreateEvent(ctx, client.Event{UserID: 1, Title: "Созвон", Date: date, Tags: []string{"работа"}}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ev := created.Event
+	if ev.EventID == 0 || ev.Version != 1 || ev.Title != "Созвон" {
+		t.Fatalf("created %+v", ev)
+	}
+
+	// пересечение: с reject - 409, без него - предупреждение
+	overlap := client.Event{UserID: 1, Title: "Поверх", Date: date}
+	if _, err := c.CreateEvent(ctx, overlap, true); !client.IsStatus(err, http.StatusConflict) {
+		t.Errorf("overlap with reject: %v", err)
+	}
+	if res, err := c.CreateEvent(ctx, overlap, false); err != nil || len(res.Warnings) != 1 {
+		t.Errorf("overlap: %+v, %v", res, err)
+	}
+
+	page, err := c.Events(ctx, client.Query{UserID: 1, From: date.Add(-time.Hour), To: date.Add(time.Hour), Filter: client.Filter{Tags: []string{"работа"}}})
+	if err != nil || len(page.Events) != 1 || page.Events[0].EventID != ev.EventID {
+		t.Fatalf("events %+v, %v", page, err)
+	}
+	if day, err := c.EventsForDay(ctx, 1, date); err != nil || len(day) != 2 {
+		t.Errorf("events for day %v, %v", len(day), err)
+	}
+
+	ev.Title = "Перенесён"
+	ev.Date = date.Add(2 * time.Hour)
+	updated, err := c.UpdateEvent(ctx, ev, false)
+	if err != nil || updated.Event.Version != 2 || updated.Event.Title != "Перенесён" {
+		t.Fatalf("update %+v, %v", updated, err)
+	}
+	// ev.Version всё ещё 1: изменение поверх устаревшей версии
+	if _, err := c.UpdateEvent(ctx, ev, false); !client.IsStatus(err, http.StatusPreconditionFailed) {
+		t.Errorf("stale update: %v", err)
+	}
+	patched, err := c.PatchEvent(ctx, 1, ev.EventID, 2, map[string]interface{}{"description": "ссылка"})
+	if err != nil || patched.Event.Description != "ссылка" || patched.Event.Title != "Перенесён" {
+		t.Fatalf("patch %+v, %v", patched, err)
+	}
+
+	deleted, err := c.DeleteEvent(ctx, 1, ev.EventID, patched.Event.Version)
+	if err != nil || deleted.EventID != ev.EventID {
+		t.Fatalf("delete %+v, %v", deleted, err)
+	}
+	_, err = c.GetEvent(ctx, 1, ev.EventID)
+	if apiErr, ok := err.(*client.APIError); !ok || apiErr.StatusCode != http.StatusServiceUnavailable || apiErr.Message == "" {
+		t.Errorf("get deleted: %v", err)
+	}
+	if trash, err := c.Trash(ctx, 1); err != nil || len(trash) != 1 {
+		t.Errorf("trash %+v, %v", trash, err)
+	}
+	if history, err := c.EventHistory(ctx, 1, ev.EventID); err != nil || len(history) != 4 {
+		t.Errorf("history %v revisions, %v", len(history), err)
+	}
+}
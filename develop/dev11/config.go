@@ -0,0 +1,235 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Duration - длительность, в конфиге задаётся строкой вида "10s" или числом секунд
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON принимает "1m30s" или число секунд
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case float64:
+		d.Duration = time.Duration(v * float64(time.Second))
+		return nil
+	case string:
+		return d.Set(v)
+	default:
+		return fmt.Errorf("invalid duration %s", data)
+	}
+}
+
+// Set и String реализуют flag.Value
+func (d *Duration) Set(value string) error {
+	v, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", value)
+	}
+	d.Duration = v
+	return nil
+}
+
+// stringValue - строковая настройка как flag.Value
+type stringValue string
+
+func (s *stringValue) Set(value string) error {
+	*s = stringValue(value)
+	return nil
+}
+
+func (s *stringValue) String() string {
+	return string(*s)
+}
+
+// floatValue - дробная настройка как flag.Value
+type floatValue float64
+
+func (f *floatValue) Set(value string) error {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil || v < 0 {
+		return fmt.Errorf("invalid number %q", value)
+	}
+	*f = floatValue(v)
+	return nil
+}
+
+func (f *floatValue) String() string {
+	return strconv.FormatFloat(float64(*f), 'g', -1, 64)
+}
+
+// intValue - целая настройка как flag.Value
+type intValue int64
+
+func (i *intValue) Set(value string) error {
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || v < 0 {
+		return fmt.Errorf("invalid integer %q", value)
+	}
+	*i = intValue(v)
+	return nil
+}
+
+func (i *intValue) String() string {
+	return strconv.FormatInt(int64(*i), 10)
+}
+
+// Config - настройки сервера. Источники по возрастанию приоритета:
+// значения по умолчанию, JSON файл (-config или CONFIG), переменные окружения, флаги
+type Config struct {
+	Port string `json:"port"`
+	// BinaryPort - адрес бинарного протокола (gob поверх TCP), пустой - выключен,
+	// BinaryMaxConns - предел его одновременных соединений
+	BinaryPort      string   `json:"binary_port"`
+	BinaryMaxConns  int64    `json:"binary_max_conns"`
+	ReadTimeout     Duration `json:"read_timeout"`
+	WriteTimeout    Duration `json:"write_timeout"`
+	IdleTimeout     Duration `json:"idle_timeout"`
+	ShutdownTimeout Duration `json:"shutdown_timeout"`
+	Storage         string   `json:"storage"`
+	StoragePath     string   `json:"storage_path"`
+	LogFormat       string   `json:"log_format"`
+	Notifier        string   `json:"notifier"`
+	NotifierURL     string   `json:"notifier_url"`
+	UsersPath       string   `json:"users_path"`
+	// WorkingHoursPath - рабочее время пользователей, HolidaysPath - праздники для /suggest_slot
+	WorkingHoursPath string `json:"working_hours_path"`
+	HolidaysPath     string `json:"holidays_path"`
+	// RateLimit - запросов в секунду на пользователя или IP, 0 - без ограничения
+	RateLimit float64 `json:"rate_limit"`
+	// RateBurst - сколько запросов подряд можно сделать сверх RateLimit
+	RateBurst int64 `json:"rate_burst"`
+	// MaxBodySize - предел размера тела запроса в байтах, кроме /import
+	MaxBodySize int64 `json:"max_body_size"`
+	// TrashRetention - сколько удалённые события лежат в корзине, 0 - пока их не восстановят
+	TrashRetention Duration `json:"trash_retention"`
+	// AuthSecret не задаётся флагом, чтобы не светиться в списке процессов
+	AuthSecret string `json:"auth_secret"`
+}
+
+func defaultConfig() Config {
+	return Config{
+		Port:            ":8080",
+		BinaryMaxConns:  defaultBinaryMaxConns,
+		ReadTimeout:     Duration{10 * time.Second},
+		WriteTimeout:    Duration{30 * time.Second},
+		IdleTimeout:     Duration{2 * time.Minute},
+		ShutdownTimeout: Duration{15 * time.Second},
+		RateLimit:       20,
+		RateBurst:       40,
+		MaxBodySize:     1 << 20,
+		TrashRetention:  Duration{30 * 24 * time.Hour},
+	}
+}
+
+// setting - одна настройка: переменная окружения, флаг (пустой - без флага) и поле конфига
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	value flag.Value
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{"PORT", "port", "listen address, e.g. :8080", (*stringValue)(&c.Port)},
+		{"BINARY_PORT", "binary-port", "binary gob-over-TCP listen address, empty disables", (*stringValue)(&c.BinaryPort)},
+		{"BINARY_MAX_CONNS", "binary-max-conns", "binary protocol connection limit, 0 disables", (*intValue)(&c.BinaryMaxConns)},
+		{"READ_TIMEOUT", "read-timeout", "request read timeout", &c.ReadTimeout},
+		{"WRITE_TIMEOUT", "write-timeout", "response write timeout", &c.WriteTimeout},
+		{"IDLE_TIMEOUT", "idle-timeout", "keep-alive idle timeout", &c.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT", "shutdown-timeout", "how long to drain requests on shutdown", &c.ShutdownTimeout},
+		{"STORAGE", "storage", "storage kind: memory or file", (*stringValue)(&c.Storage)},
+		{"STORAGE_PATH", "storage-path", "data file of file storage", (*stringValue)(&c.StoragePath)},
+		{"LOG_FORMAT", "log-format", "access log format: json or text", (*stringValue)(&c.LogFormat)},
+		{"NOTIFIER", "notifier", "reminder notifier: log or webhook", (*stringValue)(&c.Notifier)},
+		{"NOTIFIER_URL", "notifier-url", "webhook notifier URL", (*stringValue)(&c.NotifierURL)},
+		{"USERS_PATH", "users-path", "users file, enables authentication", (*stringValue)(&c.UsersPath)},
+		{"WORKING_HOURS_PATH", "working-hours-path", "per-user working hours file", (*stringValue)(&c.WorkingHoursPath)},
+		{"HOLIDAYS_PATH", "holidays-path", "holiday list file, one 2006-01-02 date per line", (*stringValue)(&c.HolidaysPath)},
+		{"RATE_LIMIT", "rate-limit", "requests per second per user or IP, 0 disables", (*floatValue)(&c.RateLimit)},
+		{"RATE_BURST", "rate-burst", "requests allowed in a burst", (*intValue)(&c.RateBurst)},
+		{"MAX_BODY_SIZE", "max-body-size", "request body limit in bytes", (*intValue)(&c.MaxBodySize)},
+		{"TRASH_RETENTION", "trash-retention", "how long deleted events stay in trash, 0 keeps them", &c.TrashRetention},
+		{"AUTH_SECRET", "", "", (*stringValue)(&c.AuthSecret)},
+	}
+}
+
+// flagRecorder запоминает значение флага, оно применяется после файла и окружения
+type flagRecorder struct {
+	value *string
+}
+
+func (f flagRecorder) Set(value string) error {
+	*f.value = value
+	return nil
+}
+
+func (f flagRecorder) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return *f.value
+}
+
+// loadConfig собирает конфиг из файла, окружения и флагов командной строки args
+func loadConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("dev11", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("CONFIG"), "JSON config file")
+	flags := make(map[string]*string)
+	for _, s := range settings {
+		if s.flag != "" {
+			flags[s.flag] = new(string)
+			fs.Var(flagRecorder{flags[s.flag]}, s.flag, s.usage)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	if *path != "" {
+		data, err := os.ReadFile(*path)
+		if err != nil {
+			return cfg, err
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("config %v: %v", *path, err)
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok && v != "" {
+			if err := s.value.Set(v); err != nil {
+				return cfg, fmt.Errorf("%v: %v", s.env, err)
+			}
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flag == f.Name && err == nil {
+				if e := s.value.Set(*flags[s.flag]); e != nil {
+					err = fmt.Errorf("-%v: %v", s.flag, e)
+				}
+			}
+		}
+	})
+
+	return cfg, err
+}
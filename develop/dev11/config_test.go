@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDurationUnmarshal(t *testing.T) {
+	tests := []struct {
+		json string
+		want time.Duration
+		ok   bool
+	}{
+		{`"1m30s"`, 90 * time.Second, true},
+		{`45`, 45 * time.Second, true},
+		{`0.5`, 500 * time.Millisecond, true},
+		{`"soon"`, 0, false},
+		{`true`, 0, false},
+	}
+	for _, tt := range tests {
+		var d Duration
+		err := d.UnmarshalJSON([]byte(tt.json))
+		if (err == nil) != tt.ok || tt.ok && d.Duration != tt.want {
+			t.Errorf("%v: %v, %v", tt.json, d.Duration, err)
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	file := writeFile(t, "config.json", `{"port": ":7000", "storage": "file", "rate_limit": 5, "trash_retention": 3600}`)
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want func(Config) bool
+		err  string
+	}{
+		{"defaults", nil, nil, func(c Config) bool {
+			return c.Port == ":8080" && c.RateLimit == 20 && c.TrashRetention.Duration == 30*24*time.Hour
+		}, ""},
+		{"file over defaults", nil, []string{"-config", file}, func(c Config) bool {
+			return c.Port == ":7000" && c.Storage == "file" && c.RateLimit == 5 && c.TrashRetention.Duration == time.Hour && c.RateBurst == 40
+		}, ""},
+		{"file from env", map[string]string{"CONFIG": file}, nil, func(c Config) bool {
+			return c.Port == ":7000"
+		}, ""},
+		{"env over file", map[string]string{"PORT": ":7001", "RATE_LIMIT": "0"}, []string{"-config", file}, func(c Config) bool {
+			return c.Port == ":7001" && c.RateLimit == 0 && c.Storage == "file"
+		}, ""},
+		{"flag over env", map[string]string{"PORT": ":7001"}, []string{"-config", file, "-port", ":7002"}, func(c Config) bool {
+			return c.Port == ":7002"
+		}, ""},
+		{"empty flag clears", map[string]string{"BINARY_PORT": ":9000"}, []string{"-binary-port", ""}, func(c Config) bool {
+			return c.BinaryPort == ""
+		}, ""},
+		{"empty env is ignored", map[string]string{"PORT": ""}, nil, func(c Config) bool {
+			return c.Port == ":8080"
+		}, ""},
+		{"secret only from env", map[string]string{"AUTH_SECRET": "s3cret"}, nil, func(c Config) bool {
+			return c.AuthSecret == "s3cret"
+		}, ""},
+		{"secret has no flag", nil, []string{"-auth-secret", "x"}, nil, "auth-secret"},
+		{"bad env duration", map[string]string{"READ_TIMEOUT": "fast"}, nil, nil, "READ_TIMEOUT"},
+		{"negative env number", map[string]string{"RATE_BURST": "-1"}, nil, nil, "RATE_BURST"},
+		{"bad flag number", nil, []string{"-rate-limit", "many"}, nil, "-rate-limit"},
+		{"missing file", nil, []string{"-config", file + ".missing"}, nil, "no such file"},
+		{"broken file", nil, []string{"-config", writeFile(t, "broken.json", `{"port": 80}`)}, nil, "broken.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// окружение процесса не должно влиять на тест
+			t.Setenv("CONFIG", "")
+			for _, s := range (&Config{}).settings() {
+				t.Setenv(s.env, "")
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			cfg, err := loadConfig(tt.args)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tt.want(cfg) {
+				t.Errorf("config %+v", cfg)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ConflictPolicy - что делать, если событие пересекается с уже существующими
+type ConflictPolicy int
+
+const (
+	// conflictWarn - сохранить событие и вернуть пересечения как предупреждения
+	conflictWarn ConflictPolicy = iota
+	// conflictReject - отказать в сохранении
+	conflictReject
+)
+
+// conflictHorizon - насколько вперёд проверяются пересечения бесконечной серии
+const conflictHorizon = 365 * 24 * time.Hour
+
+// parseConflictPolicy разбирает параметр on_conflict=warn|reject, по умолчанию warn
+func parseConflictPolicy(value string) (ConflictPolicy, error) {
+	switch value {
+	case "", "warn":
+		return conflictWarn, nil
+	case "reject":
+		return conflictReject, nil
+	default:
+		return conflictWarn, &FieldError{Field: "on_conflict", Reason: "must be warn or reject"}
+	}
+}
+
+// ConflictError - ошибка бизнес-логики: событие пересекается с существующими
+type ConflictError struct {
+	Conflicts []Event
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("event overlaps with %v existing events", len(e.Conflicts))
+}
+
+// busyOverlap проверяет, что вхождения занимают общее время; одновременные мгновенные события тоже пересекаются
+func busyOverlap(a, b *Event) bool {
+	if a.Date.Equal(b.Date) {
+		return true
+	}
+	return a.Date.Before(b.end()) && b.Date.Before(a.end())
+}
+
+// findConflicts ищет среди events вхождения, пересекающиеся с ev.
+// События на весь день время не занимают и в проверке не участвуют.
+func findConflicts(events []Event, ev *Event) []Event {
+	if ev.AllDay {
+		return nil
+	}
+
+	candidates := []Event{*ev}
+	if ev.Recurrence != nil {
+		candidates = ev.occurrences(ev.Date, ev.Date.Add(conflictHorizon))
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	from, to := candidates[0].Date, candidates[0].end()
+	for i := range candidates {
+		if candidates[i].Date.Before(from) {
+			from = candidates[i].Date
+		}
+		if candidates[i].end().After(to) {
+			to = candidates[i].end()
+		}
+	}
+	// окно должно включать и мгновенные события в его конце
+	to = to.Add(time.Nanosecond)
+
+	var res []Event
+	for i := range events {
+		if events[i].EventID == ev.EventID || events[i].AllDay {
+			continue
+		}
+		for _, occ := range events[i].occurrences(from, to) {
+			for j := range candidates {
+				if busyOverlap(&occ, &candidates[j]) {
+					res = append(res, occ)
+					break
+				}
+			}
+		}
+	}
+
+	return res
+}
+
+// Interval - промежуток времени [Start, End)
+type Interval struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// freeBusy строит занятые промежутки в окне [from, to) по вхождениям событий и свободные промежутки между ними
+func freeBusy(events []Event, from, to time.Time) ([]Interval, []Interval) {
+	var busy []Interval
+	for i := range events {
+		if events[i].AllDay {
+			continue
+		}
+		start, end := events[i].Date, events[i].end()
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			busy = append(busy, Interval{Start: start, End: end})
+		}
+	}
+
+	sort.Slice(busy, func(i, j int) bool {
+		return busy[i].Start.Before(busy[j].Start)
+	})
+
+	// сливаем пересекающиеся и смежные промежутки
+	var merged []Interval
+	for _, b := range busy {
+		if n := len(merged); n > 0 && !b.Start.After(merged[n-1].End) {
+			if b.End.After(merged[n-1].End) {
+				merged[n-1].End = b.End
+			}
+			continue
+		}
+		merged = append(merged, b)
+	}
+
+	var free []Interval
+	cur := from
+	for _, b := range merged {
+		if b.Start.After(cur) {
+			free = append(free, Interval{Start: cur, End: b.Start})
+		}
+		cur = b.End
+	}
+	if to.After(cur) {
+		free = append(free, Interval{Start: cur, End: to})
+	}
+
+	return merged, free
+}
+
+// FreeBusyHandler /free_busy handler
+func (srv *Server) FreeBusyHandler(w http.ResponseWriter, r *http.Request) {
+	b := binder{values: r.URL.Query()}
+	userID := b.userID(r)
+	loc := b.location("tz")
+	from := b.time("from", loc, true)
+	to := b.time("to", loc, true)
+	switch {
+	case from.IsZero() || to.IsZero():
+	case !to.After(from):
+		b.errs.add("to", "must be after from")
+	case to.Sub(from) > maxQueryWindow:
+		// серии разворачиваются на всё окно, как и в /events его длина ограничена
+		b.errs.add("to", fmt.Sprintf("window must be at most %v days", int(maxQueryWindow.Hours()/24)))
+	}
+	if err := b.err(); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	events, err := srv.storage.getEventsInRange(userID, from, to)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	busy, free := freeBusy(events, from, to)
+	resp := struct {
+		Result string     `json:"result"`
+		Busy   []Interval `json:"busy"`
+		Free   []Interval `json:"free"`
+	}{Result: "Запрос успешно выполнен!", Busy: busy, Free: free}
+
+	writeJSON(w, resp, http.StatusOK)
+}
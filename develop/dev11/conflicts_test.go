@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestFreeBusyWindow(t *testing.T) {
+	ts := newTestServer(t)
+	ts.create(t, url.Values{"user_id": {"1"}, "title": {"Стендап"}, "date": {"2024-01-01T10:00:00Z"}, "end": {"2024-01-01T10:15:00Z"}, "freq": {"daily"}})
+
+	tests := []struct {
+		query  string
+		status int
+	}{
+		// 2024 - високосный, 366 дней - ровно до 2025-01-01
+		{"from=2024-01-01&to=2025-01-01", http.StatusOK},
+		{"from=2024-01-01&to=2025-01-02", http.StatusBadRequest},
+		{"from=2024-01-01&to=3000-01-01", http.StatusBadRequest},
+		{"from=2024-01-02&to=2024-01-01", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, res := ts.get(t, "/free_busy?user_id=1&"+tt.query)
+		wantStatus(t, resp, res, tt.status)
+		if tt.status == http.StatusBadRequest && (len(res.Fields) != 1 || res.Fields[0].Field != "to") {
+			t.Errorf("%v: fields %+v", tt.query, res.Fields)
+		}
+	}
+
+	resp, res := ts.get(t, "/free_busy?user_id=1&from=2024-01-01&to=2024-01-03")
+	wantStatus(t, resp, res, http.StatusOK)
+	if len(res.Busy) != 2 || !res.Busy[1].Start.Equal(time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("busy %+v", res.Busy)
+	}
+}
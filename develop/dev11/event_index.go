@@ -0,0 +1,147 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// dateKey - ключ индекса событий пользователя
+type dateKey struct {
+	date time.Time
+	id   int
+}
+
+func (k dateKey) less(other dateKey) bool {
+	return k.date.Before(other.date) || (k.date.Equal(other.date) && k.id < other.id)
+}
+
+// indexNode - узел декартова дерева: порядок по ключу, куча по prio.
+// maxEnd - самый поздний конец события в поддереве, по нему отсекаются поддеревья до окна
+type indexNode struct {
+	key         dateKey
+	end, maxEnd time.Time
+	prio        uint32
+	left, right *indexNode
+}
+
+// update пересчитывает maxEnd после изменения детей
+func (n *indexNode) update() {
+	n.maxEnd = n.end
+	for _, child := range [...]*indexNode{n.left, n.right} {
+		if child != nil && child.maxEnd.After(n.maxEnd) {
+			n.maxEnd = child.maxEnd
+		}
+	}
+}
+
+// eventIndex - интервальный индекс обычных событий по началу: вставка и удаление за O(log n),
+// выборка пересечений с окном за O(log n + k) независимо от длины самого долгого события
+type eventIndex struct {
+	root *indexNode
+	size int
+}
+
+// split делит дерево на ключи меньше key и не меньше key
+func split(n *indexNode, key dateKey) (*indexNode, *indexNode) {
+	if n == nil {
+		return nil, nil
+	}
+	if n.key.less(key) {
+		l, r := split(n.right, key)
+		n.right = l
+		n.update()
+		return n, r
+	}
+	l, r := split(n.left, key)
+	n.left = r
+	n.update()
+	return l, n
+}
+
+// merge склеивает деревья, все ключи l меньше ключей r
+func merge(l, r *indexNode) *indexNode {
+	switch {
+	case l == nil:
+		return r
+	case r == nil:
+		return l
+	case l.prio > r.prio:
+		l.right = merge(l.right, r)
+		l.update()
+		return l
+	default:
+		r.left = merge(l, r.left)
+		r.update()
+		return r
+	}
+}
+
+func insertNode(n, node *indexNode) *indexNode {
+	if n == nil {
+		return node
+	}
+	if node.prio > n.prio {
+		node.left, node.right = split(n, node.key)
+		node.update()
+		return node
+	}
+	if node.key.less(n.key) {
+		n.left = insertNode(n.left, node)
+	} else {
+		n.right = insertNode(n.right, node)
+	}
+	n.update()
+	return n
+}
+
+func removeNode(n *indexNode, key dateKey) (*indexNode, bool) {
+	if n == nil {
+		return nil, false
+	}
+	var ok bool
+	switch {
+	case key.less(n.key):
+		n.left, ok = removeNode(n.left, key)
+	case n.key.less(key):
+		n.right, ok = removeNode(n.right, key)
+	default:
+		return merge(n.left, n.right), true
+	}
+	n.update()
+	return n, ok
+}
+
+// insert добавляет событие id, которое длится с date до end
+func (idx *eventIndex) insert(date, end time.Time, id int) {
+	node := &indexNode{key: dateKey{date: date, id: id}, end: end, maxEnd: end, prio: rand.Uint32()}
+	idx.root = insertNode(idx.root, node)
+	idx.size++
+}
+
+// remove убирает событие id, начавшееся в date
+func (idx *eventIndex) remove(date time.Time, id int) {
+	var ok bool
+	if idx.root, ok = removeNode(idx.root, dateKey{date: date, id: id}); ok {
+		idx.size--
+	}
+}
+
+// overlapping вызывает fn для событий, которые начались раньше to и закончились не раньше from,
+// по возрастанию начала
+func (idx *eventIndex) overlapping(from, to time.Time, fn func(id int)) {
+	var walk func(n *indexNode)
+	walk = func(n *indexNode) {
+		if n == nil || n.maxEnd.Before(from) {
+			return
+		}
+		walk(n.left)
+		if !n.key.date.Before(to) {
+			return
+		}
+		if !n.end.Before(from) {
+			fn(n.key.id)
+		}
+		walk(n.right)
+	}
+	walk(idx.root)
+}
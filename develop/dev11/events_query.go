@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Ограничения /events: размер страницы и окна
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+	maxQueryWindow   = 366 * 24 * time.Hour
+	// queryChunk - первый кусок окна, который разворачивается; следующие вдвое длиннее предыдущих
+	queryChunk = 7 * 24 * time.Hour
+)
+
+// eventKey - позиция вхождения в выдаче: начало, идентификатор и исходная дата вхождения серии
+type eventKey struct {
+	date         int64
+	eventID      int
+	recurrenceID int64
+}
+
+func keyOf(ev *Event) eventKey {
+	k := eventKey{date: ev.Date.UnixNano(), eventID: ev.EventID}
+	if ev.RecurrenceID != nil {
+		k.recurrenceID = ev.RecurrenceID.UnixNano()
+	}
+	return k
+}
+
+func (k eventKey) less(o eventKey) bool {
+	if k.date != o.date {
+		return k.date < o.date
+	}
+	if k.eventID != o.eventID {
+		return k.eventID < o.eventID
+	}
+	return k.recurrenceID < o.recurrenceID
+}
+
+// encodeCursor - курсор непрозрачен для клиента, это ключ последнего отданного вхождения
+func encodeCursor(k eventKey) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%d.%d", k.date, k.eventID, k.recurrenceID)))
+}
+
+func decodeCursor(cursor string) (eventKey, error) {
+	var k eventKey
+
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return k, &FieldError{Field: "cursor", Reason: "malformed cursor"}
+	}
+	if _, err := fmt.Sscanf(string(data), "%d.%d.%d", &k.date, &k.eventID, &k.recurrenceID); err != nil {
+		return k, &FieldError{Field: "cursor", Reason: "malformed cursor"}
+	}
+
+	return k, nil
+}
+
+// EventQuery - параметры выборки событий пользователя за произвольный период
+type EventQuery struct {
+	UserID int
+	From   time.Time
+	To     time.Time
+	// Text - подстрока для поиска в названии и описании без учёта регистра
+	Text  string
+	Desc  bool
+	Limit int
+	// After - курсор: вернуть вхождения, идущие после него в порядке сортировки
+	After *eventKey
+	// Filter - отбор по календарям и меткам
+	Filter EventFilter
+}
+
+// matches проверяет вхождение на текстовый фильтр и фильтр по календарям и меткам
+func (q *EventQuery) matches(ev *Event) bool {
+	if !q.Filter.matches(ev) {
+		return false
+	}
+	if q.Text == "" {
+		return true
+	}
+	text := strings.ToLower(q.Text)
+	return strings.Contains(strings.ToLower(ev.Title), text) || strings.Contains(strings.ToLower(ev.Description), text)
+}
+
+// afterCursor проверяет, что вхождение идёт после курсора в порядке сортировки
+func (q *EventQuery) afterCursor(ev *Event) bool {
+	if q.After == nil {
+		return true
+	}
+	if q.Desc {
+		return keyOf(ev).less(*q.After)
+	}
+	return q.After.less(keyOf(ev))
+}
+
+// queryEvents возвращает страницу вхождений и курсор следующей страницы (пустой, если страница последняя).
+// Окно разворачивается кусками в порядке сортировки, начиная с курсора, пока не наберётся Limit+1 вхождение:
+// кусок отдаёт вхождения, начавшиеся в нём, самый ранний кусок окна - ещё и начавшиеся до окна
+func queryEvents(s Storage, q EventQuery) ([]Event, string, error) {
+	from, to := q.From, q.To
+	if q.After != nil {
+		c := time.Unix(0, q.After.date)
+		if q.Desc && c.Before(to) {
+			to = c.Add(time.Nanosecond)
+		}
+		if !q.Desc && c.After(from) {
+			from = c
+		}
+	}
+
+	var res []Event
+	for step := queryChunk; from.Before(to) && len(res) <= q.Limit; step *= 2 {
+		lo, hi := from, to
+		if hi.Sub(lo) > step {
+			if q.Desc {
+				lo = hi.Add(-step)
+			} else {
+				hi = lo.Add(step)
+			}
+		}
+
+		events, err := s.getEventsInRange(q.UserID, lo, hi)
+		if err != nil {
+			return nil, "", err
+		}
+
+		var chunk []Event
+		for i := range events {
+			ev := &events[i]
+			if ev.Date.Before(lo) && lo.After(q.From) {
+				continue
+			}
+			if q.afterCursor(ev) && q.matches(ev) {
+				chunk = append(chunk, *ev)
+			}
+		}
+		sort.Slice(chunk, func(i, j int) bool {
+			if q.Desc {
+				return keyOf(&chunk[j]).less(keyOf(&chunk[i]))
+			}
+			return keyOf(&chunk[i]).less(keyOf(&chunk[j]))
+		})
+		res = append(res, chunk...)
+
+		if q.Desc {
+			to = lo
+		} else {
+			from = hi
+		}
+	}
+
+	next := ""
+	if len(res) > q.Limit {
+		res = res[:q.Limit]
+		next = encodeCursor(keyOf(&res[len(res)-1]))
+	}
+
+	return res, next, nil
+}
+
+// parseEventQuery разбирает параметры /events из queryString
+func parseEventQuery(r *http.Request) (EventQuery, error) {
+	b := binder{values: r.URL.Query()}
+	q := EventQuery{Text: b.str("q"), Limit: defaultPageLimit}
+
+	q.UserID = b.userID(r)
+	q.Filter = parseEventFilter(&b, q.UserID)
+	loc := b.location("tz")
+	q.From = b.time("from", loc, true)
+	q.To = b.time("to", loc, true)
+	switch {
+	case q.From.IsZero() || q.To.IsZero():
+	case !q.To.After(q.From):
+		b.errs.add("to", "must be after from")
+	case q.To.Sub(q.From) > maxQueryWindow:
+		b.errs.add("to", fmt.Sprintf("window must be at most %v days", int(maxQueryWindow.Hours()/24)))
+	}
+
+	switch b.str("sort") {
+	case "", "asc":
+	case "desc":
+		q.Desc = true
+	default:
+		b.errs.add("sort", "must be asc or desc")
+	}
+
+	if b.str("limit") != "" {
+		if q.Limit = b.int("limit", false); q.Limit <= 0 || q.Limit > maxPageLimit {
+			b.errs.add("limit", fmt.Sprintf("must be between 1 and %v", maxPageLimit))
+		}
+	}
+
+	if cursor := b.str("cursor"); cursor != "" {
+		k, err := decodeCursor(cursor)
+		b.errs.merge(err)
+		q.After = &k
+	}
+
+	return q, b.err()
+}
+
+// EventsHandler /events handler
+func (srv *Server) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	q, err := parseEventQuery(r)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	events, next, err := queryEvents(srv.storage, q)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	resp := struct {
+		Result     string  `json:"result"`
+		Events     []Event `json:"events"`
+		NextCursor string  `json:"next_cursor,omitempty"`
+	}{Result: "Запрос успешно выполнен!", Events: events, NextCursor: next}
+
+	writeJSON(w, resp, http.StatusOK)
+}
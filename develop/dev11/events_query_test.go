@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// rangeRecorder запоминает окна, которые разворачивались
+type rangeRecorder struct {
+	Storage
+	windows []time.Duration
+}
+
+func (s *rangeRecorder) getEventsInRange(userID int, from, to time.Time) ([]Event, error) {
+	s.windows = append(s.windows, to.Sub(from))
+	return s.Storage.getEventsInRange(userID, from, to)
+}
+
+func (s *rangeRecorder) expanded() time.Duration {
+	var sum time.Duration
+	for _, w := range s.windows {
+		sum += w
+	}
+	return sum
+}
+
+func queryFixture(t *testing.T) Storage {
+	t.Helper()
+	s := newMemoryStorage(time.Now)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	create := func(ev Event) {
+		t.Helper()
+		ev.UserID = 1
+		if _, err := s.Create(&ev, conflictWarn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// началось до окна и заходит в него
+	end := base.AddDate(0, 1, 0)
+	create(Event{Title: "долгое", Date: base.AddDate(0, 0, -10), End: &end})
+	for i := 0; i < 60; i++ {
+		create(Event{Title: fmt.Sprint("событие ", i), Date: base.Add(time.Duration(i*37) * time.Hour)})
+	}
+	// одинаковое начало, порядок по event_id
+	create(Event{Title: "двойник", Date: base.Add(37 * time.Hour)})
+	create(Event{Title: "серия", Date: base.Add(9 * time.Hour), Recurrence: &Recurrence{Freq: freqWeekly, Count: 20}})
+	return s
+}
+
+func TestQueryEventsPages(t *testing.T) {
+	s := queryFixture(t)
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 3, 0)
+
+	for _, desc := range []bool{false, true} {
+		all, next, err := queryEvents(s, EventQuery{UserID: 1, From: from, To: to, Desc: desc, Limit: maxPageLimit})
+		if err != nil || next != "" {
+			t.Fatalf("whole window: %v, next %q", err, next)
+		}
+		if len(all) != 1+60+1+13 {
+			t.Fatalf("desc %v: %v events in the window", desc, len(all))
+		}
+
+		for _, limit := range []int{1, 7, 50} {
+			var got []Event
+			q := EventQuery{UserID: 1, From: from, To: to, Desc: desc, Limit: limit}
+			for {
+				page, next, err := queryEvents(s, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, page...)
+				if next == "" {
+					break
+				}
+				k, err := decodeCursor(next)
+				if err != nil {
+					t.Fatal(err)
+				}
+				q.After = &k
+			}
+
+			if len(got) != len(all) {
+				t.Fatalf("desc %v, limit %v: %v events, want %v", desc, limit, len(got), len(all))
+			}
+			for i := range got {
+				if keyOf(&got[i]) != keyOf(&all[i]) {
+					t.Fatalf("desc %v, limit %v: event %v is %q, want %q", desc, limit, i, got[i].Title, all[i].Title)
+				}
+			}
+		}
+	}
+}
+
+func TestQueryEventsStopsAtPage(t *testing.T) {
+	s := &rangeRecorder{Storage: queryFixture(t)}
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(maxQueryWindow)
+
+	page, next, err := queryEvents(s, EventQuery{UserID: 1, From: from, To: to, Limit: 3})
+	if err != nil || len(page) != 3 || next == "" {
+		t.Fatalf("page %v, next %q, %v", len(page), next, err)
+	}
+	if s.expanded() > 2*queryChunk {
+		t.Errorf("expanded %v of the window for 3 events", s.expanded())
+	}
+
+	// следующая страница разворачивается от курсора, а не от начала окна
+	k, _ := decodeCursor(next)
+	s.windows = nil
+	late := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	k.date = late.UnixNano()
+	if _, _, err := queryEvents(s, EventQuery{UserID: 1, From: from, To: to, Limit: 3, After: &k}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.expanded(); got > to.Sub(late) {
+		t.Errorf("expanded %v, the rest of the window after the cursor is %v", got, to.Sub(late))
+	}
+}
+
+func TestEventsWindowLimit(t *testing.T) {
+	ts := newTestServer(t)
+	ts.create(t, map[string][]string{"user_id": {"1"}, "title": {"x"}, "date": {"2024-01-10"}})
+
+	resp, res := ts.get(t, "/events?user_id=1&from=2024-01-01&to=2025-01-01")
+	wantStatus(t, resp, res, http.StatusOK)
+
+	resp, res = ts.get(t, "/events?user_id=1&from=2024-01-01&to=2025-01-03")
+	wantStatus(t, resp, res, http.StatusBadRequest)
+	if len(res.Fields) != 1 || res.Fields[0].Field != "to" {
+		t.Errorf("fields %+v", res.Fields)
+	}
+}
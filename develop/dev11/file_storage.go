@@ -0,0 +1,632 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// defaultStoragePath - файл снапшота по умолчанию, журнал лежит рядом с суффиксом .log
+const defaultStoragePath = "calendar.db"
+
+// maxBatchRecordOps - сколько операций пакета пишется в одну запись журнала, больший пакет делится на части
+const maxBatchRecordOps = 1000
+
+// Операции, которые пишутся в журнал
+const (
+	opCreate = "create"
+	opUpdate = "update"
+	opDelete = "delete"
+	// opRespond - ответ участника, в событии записи только организатор, идентификатор и этот участник
+	opRespond = "respond"
+	// opRestore - возврат из корзины, в событии записи только пользователь и идентификатор
+	opRestore = "restore"
+	// opPurge - очистка корзины от событий, удалённых раньше времени записи
+	opPurge = "purge"
+	// opBatch - пакет операций одной или несколькими записями: при падении во время записи он теряется целиком
+	opBatch = "batch"
+	// операции с календарями, календарь записи в поле calendar
+	opCreateCalendar = "create_calendar"
+	opUpdateCalendar = "update_calendar"
+	opDeleteCalendar = "delete_calendar"
+)
+
+// logRecord - одна строка журнала (append-only JSON log), Time - время операции для истории и корзины
+type logRecord struct {
+	Seq   uint64      `json:"seq"`
+	Op    string      `json:"op"`
+	Time  time.Time   `json:"time"`
+	Event Event       `json:"event"`
+	Batch []logRecord `json:"batch,omitempty"`
+	// Part - номер части пакета, More - за ней следуют ещё части; пакет применяется после последней
+	Part int  `json:"part,omitempty"`
+	More bool `json:"more,omitempty"`
+	// Calendar - календарь операций create_calendar, update_calendar и delete_calendar
+	Calendar *Calendar `json:"calendar,omitempty"`
+}
+
+// snapshot - сжатое состояние хранилища на момент записи Seq
+type snapshot struct {
+	Seq     uint64         `json:"seq"`
+	Events  []Event        `json:"events"`
+	Trash   []TrashedEvent `json:"trash,omitempty"`
+	History []eventHistory `json:"history,omitempty"`
+	// Calendars - календари пользователей кроме основных
+	Calendars []Calendar `json:"calendars,omitempty"`
+}
+
+// eventHistory - история одного события в снапшоте
+type eventHistory struct {
+	UserID    int        `json:"user_id"`
+	EventID   int        `json:"event_id"`
+	Revisions []Revision `json:"revisions"`
+}
+
+// FileStorage хранилище с записью на диск: данные в памяти + журнал операций.
+// При старте читается снапшот, поверх него проигрывается журнал, после чего
+// состояние снова сжимается в снапшот, а журнал обнуляется.
+type FileStorage struct {
+	mu   sync.Mutex
+	mem  *MemoryStorage
+	path string
+	seq  uint64
+	log  *os.File
+}
+
+// Конструктор файлового хранилища, восстанавливает состояние с диска
+func newFileStorage(path string, now func() time.Time) (*FileStorage, error) {
+	s := &FileStorage{mem: newMemoryStorage(now), path: path}
+
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replayLog(); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+
+	// всё из журнала уже в снапшоте, начинаем его заново
+	f, err := os.OpenFile(s.logPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.log = f
+
+	return s, nil
+}
+
+func (s *FileStorage) logPath() string {
+	return s.path + ".log"
+}
+
+// loadSnapshot читает снапшот, если он уже есть
+func (s *FileStorage) loadSnapshot() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("broken snapshot %v: %v", s.path, err)
+	}
+
+	for i := range snap.Calendars {
+		c := snap.Calendars[i]
+		s.mem.setCalendar(c.UserID, c.CalendarID, &c)
+	}
+	for _, ev := range snap.Events {
+		s.mem.put(ev)
+	}
+	for _, t := range snap.Trash {
+		s.mem.putTrash(t)
+	}
+	for _, h := range snap.History {
+		s.mem.putHistory(h)
+	}
+	s.seq = snap.Seq
+
+	return nil
+}
+
+// replayLog проигрывает журнал поверх снапшота. Записи, уже попавшие в снапшот, пропускаются,
+// недописанная последняя строка (падение во время записи) отбрасывается, как и пакет без последней части.
+func (s *FileStorage) replayLog() error {
+	f, err := os.Open(s.logPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// части пакета, который ещё не дописан
+	var pending []logRecord
+	parts := 0
+	drop := func(reason string) {
+		if parts > 0 {
+			log.Printf("%v: skip batch of %v operations: %v", s.logPath(), len(pending), reason)
+		}
+		pending, parts = nil, 0
+	}
+
+	err = readLog(f, s.logPath(), func(rec logRecord) {
+		if rec.Seq <= s.seq {
+			return
+		}
+
+		if rec.Op == opBatch && (rec.Part > 0 || rec.More) {
+			if rec.Part != parts {
+				drop("the next part is missing")
+				if rec.Part != 0 {
+					log.Printf("%v: skip log record %v: part %v of an unknown batch", s.logPath(), rec.Seq, rec.Part)
+					s.seq = rec.Seq
+					return
+				}
+			}
+			pending = append(pending, rec.Batch...)
+			parts++
+			s.seq = rec.Seq
+			if rec.More {
+				return
+			}
+			rec.Batch = pending
+			pending, parts = nil, 0
+		} else {
+			drop("the last part is missing")
+		}
+
+		if err := s.apply(rec); err != nil {
+			log.Printf("%v: skip log record %v: %v", s.logPath(), rec.Seq, err)
+		}
+		s.seq = rec.Seq
+	})
+	drop("the last part is missing")
+
+	return err
+}
+
+// readLog читает записи журнала потоком, без предела на длину строки.
+// Сломанная строка пропускается до перевода строки, чтение продолжается со следующей
+func readLog(r io.Reader, name string, fn func(logRecord)) error {
+	br := bufio.NewReader(r)
+	for {
+		dec := json.NewDecoder(br)
+		for {
+			var rec logRecord
+			err := dec.Decode(&rec)
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				if !errors.Is(err, io.ErrUnexpectedEOF) {
+					log.Printf("%v: skip broken log record: %v", name, err)
+				}
+				break
+			}
+			fn(rec)
+		}
+
+		// декодер мог прочитать больше, чем разобрал: продолжаем с его буфера
+		br = bufio.NewReader(io.MultiReader(dec.Buffered(), br))
+		if _, err := br.ReadBytes('\n'); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// apply применяет запись журнала к данным в памяти. У записей, сделанных до появления корзины,
+// нет времени: удалённые ими события окончательно удаляются при первой очистке
+func (s *FileStorage) apply(rec logRecord) error {
+	switch rec.Op {
+	case opCreate:
+		_, err := s.mem.create(&rec.Event, conflictWarn, rec.Time)
+		return err
+	case opUpdate:
+		// в журнале серия записана целиком: старые вхождения переносить в неё не нужно
+		if r := rec.Event.Recurrence; r != nil && rec.Event.RecurrenceID == nil {
+			if r.Exceptions == nil {
+				r.Exceptions = []time.Time{}
+			}
+			if r.Overrides == nil {
+				r.Overrides = []Event{}
+			}
+		}
+		_, err := s.mem.update(&rec.Event, conflictWarn, rec.Time)
+		return err
+	case opDelete:
+		_, err := s.mem.delete(&rec.Event, rec.Time)
+		return err
+	case opRespond:
+		if len(rec.Event.Attendees) != 1 {
+			return fmt.Errorf("respond record must have one attendee")
+		}
+		a := rec.Event.Attendees[0]
+		_, err := s.mem.respond(rec.Event.UserID, rec.Event.EventID, a.UserID, a.Status, rec.Time)
+		return err
+	case opRestore:
+		_, err := s.mem.restore(&rec.Event, rec.Time)
+		return err
+	case opPurge:
+		s.mem.purge(rec.Time)
+		return nil
+	case opCreateCalendar, opUpdateCalendar, opDeleteCalendar:
+		if rec.Calendar == nil {
+			return fmt.Errorf("%v record must have calendar", rec.Op)
+		}
+		c := *rec.Calendar
+		switch rec.Op {
+		case opCreateCalendar:
+			return s.mem.CreateCalendar(&c)
+		case opUpdateCalendar:
+			return s.mem.UpdateCalendar(&c)
+		default:
+			_, err := s.mem.DeleteCalendar(c.UserID, c.CalendarID)
+			return err
+		}
+	case opBatch:
+		for _, op := range rec.Batch {
+			if err := s.apply(op); err != nil {
+				log.Printf("%v: skip operation %q of log record %v: %v", s.logPath(), op.Op, rec.Seq, err)
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown op %q", rec.Op)
+	}
+}
+
+// compact записывает снапшот через временный файл и rename
+func (s *FileStorage) compact() error {
+	snap := s.mem.dump()
+	snap.Seq = s.seq
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+// deleteRef - событие в записи об удалении: только ссылка, остальное при проигрывании уже известно
+func deleteRef(ev *Event) Event {
+	return Event{UserID: ev.UserID, EventID: ev.EventID, RecurrenceID: ev.RecurrenceID}
+}
+
+// newLogRecord - запись журнала об операции над ev
+func newLogRecord(op string, ev Event, at time.Time) logRecord {
+	// версии при проигрывании журнала назначаются заново теми же шагами
+	ev.Version = 0
+	return logRecord{Op: op, Time: at, Event: ev}
+}
+
+// appendRecord дописывает операцию в журнал и сбрасывает её на диск, вызывать под мьютексом
+func (s *FileStorage) appendRecord(op string, ev Event, at time.Time) error {
+	return s.append(newLogRecord(op, ev, at))
+}
+
+// append дописывает запись в журнал под следующим номером, вызывать под мьютексом
+func (s *FileStorage) append(rec logRecord) error {
+	rec.Seq = s.seq + 1
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if _, err := s.log.Write(data); err != nil {
+		return err
+	}
+	if err := s.log.Sync(); err != nil {
+		return err
+	}
+	s.seq++
+
+	return nil
+}
+
+// Create создание события с записью в журнал
+func (s *FileStorage) Create(ev *Event, policy ConflictPolicy) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at := s.mem.now()
+	prev := s.mem.state(ev.UserID, ev.EventID)
+	conflicts, err := s.mem.create(ev, policy, at)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.appendRecord(opCreate, *ev, at); err != nil {
+		// идентификатор мог назначить create, откатываем именно его
+		prev.eventID = ev.EventID
+		s.mem.setState(prev)
+		return nil, err
+	}
+
+	return conflicts, nil
+}
+
+// Update обновление события с записью в журнал
+func (s *FileStorage) Update(ev *Event, policy ConflictPolicy) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at := s.mem.now()
+	prev := s.mem.state(ev.UserID, ev.EventID)
+	conflicts, err := s.mem.update(ev, policy, at)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.appendRecord(opUpdate, *ev, at); err != nil {
+		s.mem.setState(prev)
+		return nil, err
+	}
+
+	return conflicts, nil
+}
+
+// Delete перенос события в корзину с записью в журнал
+func (s *FileStorage) Delete(ev *Event) (*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at := s.mem.now()
+	prev := s.mem.state(ev.UserID, ev.EventID)
+	deleted, err := s.mem.delete(ev, at)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.appendRecord(opDelete, deleteRef(ev), at); err != nil {
+		s.mem.setState(prev)
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// Respond ответ участника с записью в журнал
+func (s *FileStorage) Respond(organizerID, eventID, userID int, status string) (*Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at := s.mem.now()
+	prev := s.mem.state(organizerID, eventID)
+	ev, err := s.mem.respond(organizerID, eventID, userID, status, at)
+	if err != nil {
+		return nil, err
+	}
+	rec := Event{UserID: organizerID, EventID: eventID, Attendees: []Attendee{{UserID: userID, Status: status}}}
+	if err := s.appendRecord(opRespond, rec, at); err != nil {
+		s.mem.setState(prev)
+		return nil, err
+	}
+
+	return ev, nil
+}
+
+// Restore возврат события из корзины с записью в журнал
+func (s *FileStorage) Restore(ev *Event) ([]Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at := s.mem.now()
+	prev := s.mem.state(ev.UserID, ev.EventID)
+	conflicts, err := s.mem.restore(ev, at)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.appendRecord(opRestore, Event{UserID: ev.UserID, EventID: ev.EventID}, at); err != nil {
+		s.mem.setState(prev)
+		return nil, err
+	}
+
+	return conflicts, nil
+}
+
+// Batch пакет операций, все успешные операции пишутся в журнал одним пакетом.
+// Пакет больше maxBatchRecordOps делится на части, при проигрывании он применяется только целиком
+func (s *FileStorage) Batch(ops []BatchOp, policy ConflictPolicy, atomic bool) ([]BatchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at := s.mem.now()
+	results, undo := s.mem.batch(ops, policy, atomic, at)
+	if len(undo) == 0 {
+		return results, nil
+	}
+
+	var applied []logRecord
+	for i, res := range results {
+		switch {
+		case res.Err != nil:
+		case ops[i].Op == opDelete:
+			applied = append(applied, newLogRecord(opDelete, deleteRef(&ops[i].Event), at))
+		default:
+			applied = append(applied, newLogRecord(ops[i].Op, *res.Event, at))
+		}
+	}
+	for part := 0; len(applied) > 0; part++ {
+		n := min(len(applied), maxBatchRecordOps)
+		rec := logRecord{Op: opBatch, Time: at, Batch: applied[:n], Part: part, More: n < len(applied)}
+		if err := s.append(rec); err != nil {
+			// уже записанные части без последней при проигрывании отбрасываются
+			for i := len(undo) - 1; i >= 0; i-- {
+				s.mem.setState(undo[i])
+			}
+			return nil, err
+		}
+		applied = applied[n:]
+	}
+
+	return results, nil
+}
+
+// CreateCalendar создание календаря с записью в журнал
+func (s *FileStorage) CreateCalendar(c *Calendar) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.CreateCalendar(c); err != nil {
+		return err
+	}
+	if err := s.appendCalendar(opCreateCalendar, *c); err != nil {
+		s.mem.setCalendar(c.UserID, c.CalendarID, nil)
+		return err
+	}
+
+	return nil
+}
+
+// UpdateCalendar изменение календаря с записью в журнал
+func (s *FileStorage) UpdateCalendar(c *Calendar) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, _ := s.mem.calendar(c.UserID, c.CalendarID)
+	if err := s.mem.UpdateCalendar(c); err != nil {
+		return err
+	}
+	if err := s.appendCalendar(opUpdateCalendar, *c); err != nil {
+		s.mem.setCalendar(c.UserID, c.CalendarID, &prev)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteCalendar удаление календаря с записью в журнал
+func (s *FileStorage) DeleteCalendar(userID, calendarID int) (*Calendar, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted, err := s.mem.DeleteCalendar(userID, calendarID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.appendCalendar(opDeleteCalendar, Calendar{UserID: userID, CalendarID: calendarID}); err != nil {
+		s.mem.setCalendar(userID, calendarID, deleted)
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// appendCalendar дописывает операцию с календарём в журнал, вызывать под мьютексом
+func (s *FileStorage) appendCalendar(op string, c Calendar) error {
+	return s.append(logRecord{Op: op, Time: s.mem.now(), Calendar: &c})
+}
+
+// Purge очистка корзины с записью в журнал
+func (s *FileStorage) Purge(before time.Time) ([]TrashedEvent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := s.mem.purge(before)
+	if len(purged) == 0 {
+		return nil, nil
+	}
+	if err := s.appendRecord(opPurge, Event{}, before); err != nil {
+		for _, st := range purged {
+			s.mem.setState(st)
+		}
+		return nil, err
+	}
+
+	res := make([]TrashedEvent, 0, len(purged))
+	for _, st := range purged {
+		res = append(res, *st.trashed)
+	}
+	return res, nil
+}
+
+func (s *FileStorage) getEventsForDay(userID int, date time.Time) ([]Event, error) {
+	return s.mem.getEventsForDay(userID, date)
+}
+
+func (s *FileStorage) getEventsForWeek(userID int, date time.Time) ([]Event, error) {
+	return s.mem.getEventsForWeek(userID, date)
+}
+
+func (s *FileStorage) getEventsForMonth(userID int, date time.Time) ([]Event, error) {
+	return s.mem.getEventsForMonth(userID, date)
+}
+
+func (s *FileStorage) getEventsInRange(userID int, from, to time.Time) ([]Event, error) {
+	return s.mem.getEventsInRange(userID, from, to)
+}
+
+func (s *FileStorage) getOwnEventsInRange(userID int, from, to time.Time) ([]Event, error) {
+	return s.mem.getOwnEventsInRange(userID, from, to)
+}
+
+func (s *FileStorage) getUserEvents(userID int) ([]Event, error) {
+	return s.mem.getUserEvents(userID)
+}
+
+func (s *FileStorage) getEvent(userID, eventID int) (Event, error) {
+	return s.mem.getEvent(userID, eventID)
+}
+
+func (s *FileStorage) getUserIDs() []int {
+	return s.mem.getUserIDs()
+}
+
+func (s *FileStorage) eventCounts() map[int]int {
+	return s.mem.eventCounts()
+}
+
+func (s *FileStorage) getTrash(userID int) ([]TrashedEvent, error) {
+	return s.mem.getTrash(userID)
+}
+
+func (s *FileStorage) getCalendars(userID int) ([]Calendar, error) {
+	return s.mem.getCalendars(userID)
+}
+
+func (s *FileStorage) getHistory(userID, eventID int) ([]Revision, error) {
+	return s.mem.getHistory(userID, eventID)
+}
+
+// Close сбрасывает журнал на диск и закрывает файл
+func (s *FileStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.log.Sync(); err != nil {
+		s.log.Close()
+		return err
+	}
+
+	return s.log.Close()
+}
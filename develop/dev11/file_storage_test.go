@@ -0,0 +1,191 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openFileStorage открывает файловое хранилище, закрывает его тест
+func openFileStorage(t *testing.T, path string) *FileStorage {
+	t.Helper()
+	s, err := newFileStorage(path, time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// fileFixture - три события пользователя 1: изменённое, удалённое и нетронутое
+func fileFixture(t *testing.T, s *FileStorage) (updated, deleted, kept Event) {
+	t.Helper()
+	date := time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)
+	for i, ev := range []*Event{&updated, &deleted, &kept} {
+		*ev = Event{UserID: 1, Title: "событие", Date: date.Add(time.Duration(i) * time.Hour)}
+		if _, err := s.Create(ev, conflictWarn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	updated.Title = "изменено"
+	if _, err := s.Update(&updated, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Delete(&Event{UserID: 1, EventID: deleted.EventID}); err != nil {
+		t.Fatal(err)
+	}
+	return updated, deleted, kept
+}
+
+// checkFixture проверяет состояние после fileFixture
+func checkFixture(t *testing.T, s *FileStorage, updated, deleted, kept Event) {
+	t.Helper()
+	if ev, err := s.getEvent(1, updated.EventID); err != nil || ev.Title != "изменено" || ev.Version != updated.Version {
+		t.Errorf("updated event: %+v, %v", ev, err)
+	}
+	if _, err := s.getEvent(1, deleted.EventID); err == nil {
+		t.Error("deleted event is live")
+	}
+	if trash, err := s.getTrash(1); err != nil || len(trash) != 1 || trash[0].EventID != deleted.EventID {
+		t.Errorf("trash %+v, %v", trash, err)
+	}
+	if ev, err := s.getEvent(1, kept.EventID); err != nil || ev.Title != "событие" {
+		t.Errorf("kept event: %+v, %v", ev, err)
+	}
+	if history, err := s.getHistory(1, updated.EventID); err != nil || len(history) != 2 {
+		t.Errorf("history %v revisions, %v", len(history), err)
+	}
+}
+
+func TestFileStorageReopen(t *testing.T) {
+	path := t.TempDir() + "/calendar.db"
+	s := openFileStorage(t, path)
+	updated, deleted, kept := fileFixture(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// первое открытие проигрывает журнал, второе читает уже снапшот
+	last := kept.EventID
+	for i := 0; i < 2; i++ {
+		s = openFileStorage(t, path)
+		checkFixture(t, s, updated, deleted, kept)
+
+		// новые идентификаторы не пересекаются со старыми, в том числе удалёнными
+		ev := Event{UserID: 1, Title: "новое", Date: kept.Date.Add(time.Duration(i+1) * time.Hour)}
+		if _, err := s.Create(&ev, conflictWarn); err != nil || ev.EventID <= last {
+			t.Errorf("create after reopen: id %v, %v", ev.EventID, err)
+		}
+		last = ev.EventID
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFileStorageTornTail(t *testing.T) {
+	tests := []struct {
+		name string
+		tail string
+	}{
+		{"half a record", `{"seq":100,"op":"create","event":{"user_id":1,"title":"обор`},
+		{"unclosed record", `{"seq":100,"op":"create","event":{"user_id":1,"event_id":50,"title":"x","date":"2024-01-10T10:00:00Z"}`},
+		{"garbage", "\x00\x00\x00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := t.TempDir() + "/calendar.db"
+			s := openFileStorage(t, path)
+			updated, deleted, kept := fileFixture(t, s)
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			f, err := os.OpenFile(path+".log", os.O_APPEND|os.O_WRONLY, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.WriteString(tt.tail)
+			f.Close()
+
+			s = openFileStorage(t, path)
+			defer s.Close()
+			checkFixture(t, s, updated, deleted, kept)
+			if events, _ := s.getUserEvents(1); len(events) != 2 {
+				t.Errorf("%v events after a torn tail", len(events))
+			}
+		})
+	}
+}
+
+func TestFileStorageSkipsBrokenRecord(t *testing.T) {
+	path := t.TempDir() + "/calendar.db"
+	s := openFileStorage(t, path)
+	updated, deleted, kept := fileFixture(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// испорченная строка в середине журнала не мешает следующим
+	data, err := os.ReadFile(path + ".log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	broken := strings.Join(lines[:1], "") + "{\"seq\": broken\n" + strings.Join(lines[1:], "")
+	if err := os.WriteFile(path+".log", []byte(broken), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openFileStorage(t, path)
+	defer s.Close()
+	checkFixture(t, s, updated, deleted, kept)
+}
+
+func TestFileStorageCompaction(t *testing.T) {
+	path := t.TempDir() + "/calendar.db"
+	s := openFileStorage(t, path)
+	updated, deleted, kept := fileFixture(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	log, err := os.ReadFile(path + ".log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// при открытии журнал сжимается в снапшот и обнуляется
+	s = openFileStorage(t, path)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path + ".log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Fatalf("log after compaction: %v bytes", info.Size())
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+
+	// падение между записью снапшота и обнулением журнала: записи из снапшота не применяются второй раз
+	if err := os.WriteFile(path+".log", log, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s = openFileStorage(t, path)
+	defer s.Close()
+	checkFixture(t, s, updated, deleted, kept)
+	if events, _ := s.getUserEvents(1); len(events) != 2 {
+		t.Errorf("%v events, records from the snapshot are replayed again", len(events))
+	}
+
+	// сломанный снапшот - ошибка, а не пустое хранилище
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newFileStorage(path, time.Now); err == nil {
+		t.Error("broken snapshot is accepted")
+	}
+}
@@ -0,0 +1,3 @@
+module dev11
+
+go 1.21
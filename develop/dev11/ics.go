@@ -0,0 +1,555 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Форматы дат iCalendar (RFC 5545)
+const (
+	icsDateTimeFormat = "20060102T150405Z"
+	icsLocalFormat    = "20060102T150405"
+	icsDateFormat     = "20060102"
+)
+
+// icsUIDSuffix - домен в UID экспортированных событий, по нему при импорте в тот же календарь восстанавливается EventID
+const icsUIDSuffix = "@dev11"
+
+// maxImportSize - ограничение на размер загружаемого .ics файла
+const maxImportSize = 10 << 20
+
+// icsProperty - одно свойство компонента: NAME;PARAM=VALUE:value
+type icsProperty struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// icsComponent - VEVENT со свойствами в порядке появления
+type icsComponent struct {
+	Props []icsProperty
+}
+
+// get возвращает первое свойство с именем name
+func (c *icsComponent) get(name string) (icsProperty, bool) {
+	for _, p := range c.Props {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return icsProperty{}, false
+}
+
+// ImportEntry - результат импорта одного VEVENT
+type ImportEntry struct {
+	UID     string `json:"uid"`
+	EventID int    `json:"event_id,omitempty"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Статусы импорта записи
+const (
+	importOK       = "imported"
+	importConflict = "conflict"
+	importInvalid  = "invalid"
+)
+
+// icsEscape экранирует текстовое значение
+func icsEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+// icsUnescape снимает экранирование текстового значения
+func icsUnescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n', 'N':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// icsFold пишет строку контента, перенося её так, чтобы строки были не длиннее 75 октетов, как требует RFC 5545.
+// Пробел в начале продолжения входит в эти 75 октетов
+func icsFold(w *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		// не режем многобайтовый символ UTF-8
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		w.WriteString(line[:cut])
+		w.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	w.WriteString(line)
+	w.WriteString("\r\n")
+}
+
+func icsTime(t time.Time) string {
+	return t.UTC().Format(icsDateTimeFormat)
+}
+
+// icsTimeProp сериализует дату события: на весь день - DATE, в поясе - локальное время с TZID, иначе UTC
+func icsTimeProp(name string, ev *Event, t time.Time) string {
+	if loc, err := ev.location(); err == nil {
+		t = t.In(loc)
+	}
+
+	switch {
+	case ev.AllDay:
+		return name + ";VALUE=DATE:" + t.Format(icsDateFormat)
+	case ev.TimeZone != "" && ev.TimeZone != "UTC":
+		return name + ";TZID=" + ev.TimeZone + ":" + t.Format(icsLocalFormat)
+	default:
+		return name + ":" + icsTime(t)
+	}
+}
+
+// encodeRRule сериализует правило повторения в RRULE
+func encodeRRule(r *Recurrence) string {
+	parts := []string{"FREQ=" + strings.ToUpper(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		parts = append(parts, "BYDAY="+strings.Join(r.ByDay, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+icsTime(*r.Until))
+	}
+	return strings.Join(parts, ";")
+}
+
+// icsUID возвращает UID события: импортированное сохраняет исходный
+func icsUID(ev *Event) string {
+	if ev.UID != "" {
+		return ev.UID
+	}
+	return fmt.Sprintf("%d-%d%s", ev.UserID, ev.EventID, icsUIDSuffix)
+}
+
+// encodeICS сериализует события в VCALENDAR
+func encodeICS(events []Event, now time.Time) string {
+	var b strings.Builder
+
+	icsFold(&b, "BEGIN:VCALENDAR")
+	icsFold(&b, "VERSION:2.0")
+	icsFold(&b, "PRODID:-//WB L2//dev11 calendar//RU")
+
+	writeEvent := func(ev *Event, uid string) {
+		icsFold(&b, "BEGIN:VEVENT")
+		icsFold(&b, "UID:"+uid)
+		icsFold(&b, "DTSTAMP:"+icsTime(now))
+		if ev.RecurrenceID != nil {
+			icsFold(&b, icsTimeProp("RECURRENCE-ID", ev, *ev.RecurrenceID))
+		}
+		icsFold(&b, icsTimeProp("DTSTART", ev, ev.Date))
+		if ev.End != nil || ev.AllDay {
+			icsFold(&b, icsTimeProp("DTEND", ev, ev.end()))
+		}
+		icsFold(&b, "SUMMARY:"+icsEscape(ev.Title))
+		if ev.Description != "" {
+			icsFold(&b, "DESCRIPTION:"+icsEscape(ev.Description))
+		}
+		if ev.Recurrence != nil {
+			icsFold(&b, "RRULE:"+encodeRRule(ev.Recurrence))
+			for _, ex := range ev.Recurrence.Exceptions {
+				icsFold(&b, icsTimeProp("EXDATE", ev, ex))
+			}
+		}
+		icsFold(&b, "END:VEVENT")
+	}
+
+	for i := range events {
+		uid := icsUID(&events[i])
+		writeEvent(&events[i], uid)
+		if events[i].Recurrence != nil {
+			for j := range events[i].Recurrence.Overrides {
+				writeEvent(&events[i].Recurrence.Overrides[j], uid)
+			}
+		}
+	}
+
+	icsFold(&b, "END:VCALENDAR")
+
+	return b.String()
+}
+
+// unfoldICS читает строки контента, склеивая перенесённые
+func unfoldICS(r io.Reader) ([]string, error) {
+	var lines []string
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), maxImportSize)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, sc.Err()
+}
+
+// parseICSLine разбирает строку контента на имя, параметры и значение
+func parseICSLine(line string) (icsProperty, error) {
+	quoted := false
+	colon := -1
+	for i, c := range line {
+		if c == '"' {
+			quoted = !quoted
+		}
+		if c == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon == -1 {
+		return icsProperty{}, fmt.Errorf("invalid content line %q", line)
+	}
+
+	head := strings.Split(line[:colon], ";")
+	p := icsProperty{Name: strings.ToUpper(head[0]), Params: map[string]string{}, Value: line[colon+1:]}
+	for _, param := range head[1:] {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 {
+			p.Params[strings.ToUpper(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+
+	return p, nil
+}
+
+// decodeICS разбирает VCALENDAR на список VEVENT
+func decodeICS(r io.Reader) ([]icsComponent, error) {
+	lines, err := unfoldICS(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return nil, fmt.Errorf("not an iCalendar file")
+	}
+
+	var (
+		res     []icsComponent
+		current *icsComponent
+		depth   int
+	)
+	for _, line := range lines {
+		p, err := parseICSLine(line)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case p.Name == "BEGIN" && strings.EqualFold(p.Value, "VEVENT") && current == nil:
+			current = &icsComponent{}
+		case p.Name == "END" && strings.EqualFold(p.Value, "VEVENT") && current != nil && depth == 0:
+			res = append(res, *current)
+			current = nil
+		case current != nil && p.Name == "BEGIN":
+			// вложенные компоненты (VALARM) пропускаем
+			depth++
+		case current != nil && p.Name == "END":
+			depth--
+		case current != nil && depth == 0:
+			current.Props = append(current.Props, p)
+		}
+	}
+	if current != nil {
+		return nil, fmt.Errorf("unterminated VEVENT")
+	}
+
+	return res, nil
+}
+
+// parseICSTime разбирает DATE или DATE-TIME с учётом параметра TZID
+func parseICSTime(p icsProperty) (time.Time, error) {
+	return parseICSValue(p.Value, p.Params)
+}
+
+func parseICSValue(value string, params map[string]string) (time.Time, error) {
+	if params["VALUE"] == "DATE" || len(value) == len(icsDateFormat) {
+		return time.Parse(icsDateFormat, value)
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse(icsDateTimeFormat, value)
+	}
+
+	// локальное время: в зоне TZID, а без неё - плавающее, считаем его UTC
+	loc := time.UTC
+	if tzid := params["TZID"]; tzid != "" {
+		var err error
+		if loc, err = time.LoadLocation(tzid); err != nil {
+			return time.Time{}, fmt.Errorf("unknown TZID %q", tzid)
+		}
+	}
+	return time.ParseInLocation(icsLocalFormat, value, loc)
+}
+
+// parseICSTimes разбирает список дат через запятую (EXDATE)
+func parseICSTimes(p icsProperty) ([]time.Time, error) {
+	var res []time.Time
+	for _, v := range strings.Split(p.Value, ",") {
+		t, err := parseICSValue(v, p.Params)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, t)
+	}
+	return res, nil
+}
+
+// parseRRule разбирает RRULE в правило повторения
+func parseRRule(value string) (*Recurrence, error) {
+	r := &Recurrence{}
+	for _, part := range strings.Split(value, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid RRULE part %q", part)
+		}
+
+		var err error
+		switch strings.ToUpper(kv[0]) {
+		case "FREQ":
+			r.Freq = strings.ToLower(kv[1])
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(kv[1])
+		case "COUNT":
+			r.Count, err = strconv.Atoi(kv[1])
+		case "BYDAY":
+			r.ByDay = strings.Split(strings.ToUpper(kv[1]), ",")
+		case "UNTIL":
+			var until time.Time
+			until, err = parseICSValue(kv[1], nil)
+			r.Until = &until
+		case "WKST":
+		default:
+			return nil, fmt.Errorf("unsupported RRULE part %q", kv[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE %v: %v", kv[0], err)
+		}
+	}
+
+	return r, r.validate()
+}
+
+// icsEventID восстанавливает EventID из UID, который экспорт выдал тому же пользователю, иначе 0.
+// Чужим UID хранилище назначает идентификатор само и запоминает соответствие (Event.UID)
+func icsEventID(uid string, userID int) int {
+	parts := strings.SplitN(strings.TrimSuffix(uid, icsUIDSuffix), "-", 2)
+	if !strings.HasSuffix(uid, icsUIDSuffix) || len(parts) != 2 || parts[0] != strconv.Itoa(userID) {
+		return 0
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil || id <= 0 {
+		return 0
+	}
+	return id
+}
+
+// toEvent переносит поля VEVENT в событие пользователя
+func (c *icsComponent) toEvent(userID int) (Event, error) {
+	ev := Event{UserID: userID}
+
+	uid, ok := c.get("UID")
+	if !ok {
+		return ev, fmt.Errorf("missing UID")
+	}
+	ev.EventID = icsEventID(uid.Value, userID)
+
+	start, ok := c.get("DTSTART")
+	if !ok {
+		return ev, fmt.Errorf("missing DTSTART")
+	}
+	ev.AllDay = start.Params["VALUE"] == "DATE"
+	ev.TimeZone = start.Params["TZID"]
+
+	var err error
+	if ev.Date, err = parseICSTime(start); err != nil {
+		return ev, fmt.Errorf("invalid DTSTART: %v", err)
+	}
+
+	if p, ok := c.get("DTEND"); ok {
+		end, err := parseICSTime(p)
+		if err != nil {
+			return ev, fmt.Errorf("invalid DTEND: %v", err)
+		}
+		ev.End = &end
+	}
+
+	if p, ok := c.get("SUMMARY"); ok {
+		ev.Title = icsUnescape(p.Value)
+	}
+	if p, ok := c.get("DESCRIPTION"); ok {
+		ev.Description = icsUnescape(p.Value)
+	}
+
+	if p, ok := c.get("RECURRENCE-ID"); ok {
+		t, err := parseICSTime(p)
+		if err != nil {
+			return ev, fmt.Errorf("invalid RECURRENCE-ID: %v", err)
+		}
+		ev.RecurrenceID = &t
+	}
+
+	if p, ok := c.get("RRULE"); ok {
+		if ev.Recurrence, err = parseRRule(p.Value); err != nil {
+			return ev, err
+		}
+		for _, p := range c.Props {
+			if p.Name != "EXDATE" {
+				continue
+			}
+			ex, err := parseICSTimes(p)
+			if err != nil {
+				return ev, fmt.Errorf("invalid EXDATE: %v", err)
+			}
+			ev.Recurrence.Exceptions = append(ev.Recurrence.Exceptions, ex...)
+		}
+	}
+
+	return ev, ev.validate()
+}
+
+// importICS создаёт события из VEVENT. Ошибка одной записи не прерывает импорт остальных,
+// изменённые вхождения (RECURRENCE-ID) присоединяются к своей серии до сохранения.
+func importICS(s Storage, userID int, components []icsComponent) []ImportEntry {
+	var (
+		res       []ImportEntry
+		masters   []Event
+		uids      []string
+		overrides = map[string][]Event{}
+	)
+
+	for i := range components {
+		uid, _ := components[i].get("UID")
+		ev, err := components[i].toEvent(userID)
+		if err != nil {
+			res = append(res, ImportEntry{UID: uid.Value, Status: importInvalid, Error: err.Error()})
+			continue
+		}
+		if ev.RecurrenceID != nil {
+			overrides[uid.Value] = append(overrides[uid.Value], ev)
+			continue
+		}
+		if ev.EventID == 0 {
+			ev.UID = uid.Value
+		}
+		masters = append(masters, ev)
+		uids = append(uids, uid.Value)
+	}
+
+	for i, ev := range masters {
+		entry := ImportEntry{UID: uids[i], Status: importOK}
+
+		for _, o := range overrides[uids[i]] {
+			var err error
+			if ev, err = ev.withOverride(o); err != nil {
+				entry.Status, entry.Error = importInvalid, err.Error()
+				break
+			}
+		}
+		delete(overrides, uids[i])
+
+		if entry.Status == importOK {
+			if _, err := s.Create(&ev, conflictWarn); err != nil {
+				entry.Status, entry.Error = importConflict, err.Error()
+			}
+			entry.EventID = ev.EventID
+		}
+		res = append(res, entry)
+	}
+
+	for uid := range overrides {
+		res = append(res, ImportEntry{UID: uid, Status: importInvalid, Error: "occurrence without recurring event"})
+	}
+
+	return res
+}
+
+// ExportHandler /export.ics handler
+func (srv *Server) ExportHandler(w http.ResponseWriter, r *http.Request) {
+	b := binder{values: r.URL.Query()}
+	userID := b.userID(r)
+	if err := b.err(); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	events, err := srv.storage.getUserEvents(userID)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="calendar.ics"`)
+	io.WriteString(w, encodeICS(events, srv.now()))
+}
+
+// ImportHandler /import handler, файл передаётся телом запроса или полем file формы multipart
+func (srv *Server) ImportHandler(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+	// при передаче сырым телом user_id берётся из queryString, тело не должно разбираться как форма
+	var body io.Reader = r.Body
+	b := binder{values: r.URL.Query()}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		f, _, err := r.FormFile("file")
+		if err != nil {
+			getErrorResponse(w, &FieldError{Field: "file", Reason: err.Error()})
+			return
+		}
+		defer f.Close()
+		body = f
+		b.values = r.Form
+	}
+
+	userID := b.userID(r)
+	if err := b.err(); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	components, err := decodeICS(body)
+	if err != nil {
+		getErrorResponse(w, &FieldError{Field: "file", Reason: err.Error()})
+		return
+	}
+
+	resp := struct {
+		Result  string        `json:"result"`
+		Entries []ImportEntry `json:"entries"`
+	}{Result: "Импорт выполнен!", Entries: importICS(srv.storage, userID, components)}
+
+	writeJSON(w, resp, http.StatusOK)
+}
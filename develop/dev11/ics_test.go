@@ -0,0 +1,205 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestICSFold(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"short", "SUMMARY:Созвон"},
+		{"exactly 75", "DESCRIPTION:" + strings.Repeat("a", 63)},
+		{"ascii", "DESCRIPTION:" + strings.Repeat("a", 300)},
+		{"cyrillic", "DESCRIPTION:" + strings.Repeat("ж", 200)},
+		{"mixed", "DESCRIPTION:" + strings.Repeat("aж€", 80)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			icsFold(&b, tt.line)
+			lines := strings.Split(strings.TrimSuffix(b.String(), "\r\n"), "\r\n")
+			for i, l := range lines {
+				if len(l) > 75 {
+					t.Errorf("line %v is %v octets", i, len(l))
+				}
+				if i > 0 && l[0] != ' ' {
+					t.Errorf("continuation %v does not start with a space", i)
+				}
+				if !utf8.ValidString(strings.TrimPrefix(l, " ")) {
+					t.Errorf("line %v splits a character", i)
+				}
+			}
+
+			got, err := unfoldICS(strings.NewReader(b.String()))
+			if err != nil || len(got) != 1 || got[0] != tt.line {
+				t.Errorf("unfolded %q, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestICSRoundTrip(t *testing.T) {
+	msk, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newMemoryStorage(time.Now)
+
+	start := time.Date(2024, 1, 8, 10, 0, 0, 0, msk)
+	end := start.Add(time.Hour)
+	series := Event{
+		UserID: 1, Title: "Планёрка; обсуждение, итоги", Description: strings.Repeat("Длинное описание встречи. ", 10),
+		Date: start, End: &end, TimeZone: "Europe/Moscow",
+		Recurrence: &Recurrence{Freq: freqWeekly, ByDay: []string{"MO", "WE"}, Count: 10},
+	}
+	if _, err := s.Create(&series, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+	// удалённое вхождение - EXDATE, перенесённое - VEVENT с RECURRENCE-ID
+	skipped := start.AddDate(0, 0, 2)
+	if _, err := s.Delete(&Event{UserID: 1, EventID: series.EventID, RecurrenceID: &skipped}); err != nil {
+		t.Fatal(err)
+	}
+	movedFrom := start.AddDate(0, 0, 7)
+	movedEnd := movedFrom.Add(3 * time.Hour)
+	moved := Event{
+		UserID: 1, EventID: series.EventID, Title: "Планёрка позже", Date: movedFrom.Add(2 * time.Hour), End: &movedEnd,
+		TimeZone: "Europe/Moscow", RecurrenceID: &movedFrom,
+	}
+	if _, err := s.Update(&moved, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+	allDay := Event{UserID: 1, Title: "Отпуск", Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), AllDay: true}
+	if _, err := s.Create(&allDay, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+
+	exported, err := s.getUserEvents(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := encodeICS(exported, time.Now())
+	for _, line := range strings.Split(data, "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("%v octets in %q", len(line), line)
+		}
+	}
+
+	components, err := decodeICS(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range importICS(s, 2, components) {
+		if e.Status != importOK {
+			t.Fatalf("import %+v", e)
+		}
+	}
+	imported, err := s.getUserEvents(2)
+	if err != nil || len(imported) != len(exported) {
+		t.Fatalf("imported %v of %v events, %v", len(imported), len(exported), err)
+	}
+
+	for i, want := range exported {
+		got := imported[i]
+		if got.Title != want.Title || got.Description != want.Description || !got.Date.Equal(want.Date) ||
+			got.TimeZone != want.TimeZone || got.AllDay != want.AllDay || !got.end().Equal(want.end()) {
+			t.Errorf("event %v: got %+v, want %+v", i, got, want)
+		}
+		if want.Recurrence == nil {
+			continue
+		}
+		gr, wr := got.Recurrence, want.Recurrence
+		if gr == nil || gr.Freq != wr.Freq || strings.Join(gr.ByDay, ",") != strings.Join(wr.ByDay, ",") || gr.Count != wr.Count {
+			t.Fatalf("recurrence %+v, want %+v", gr, wr)
+		}
+		if len(gr.Exceptions) != 1 || !gr.Exceptions[0].Equal(wr.Exceptions[0]) {
+			t.Errorf("exceptions %v, want %v", gr.Exceptions, wr.Exceptions)
+		}
+		if len(gr.Overrides) != 1 {
+			t.Fatalf("overrides %+v", gr.Overrides)
+		}
+		o := gr.Overrides[0]
+		if !o.RecurrenceID.Equal(movedFrom) || !o.Date.Equal(moved.Date) || !o.end().Equal(movedEnd) || o.Title != moved.Title {
+			t.Errorf("override %+v, want %+v", o, moved)
+		}
+	}
+
+	// те же вхождения в выборке, в том числе перенесённое и без удалённого
+	from, to := start.AddDate(0, 0, -1), start.AddDate(0, 0, 14)
+	want, _ := s.getEventsInRange(1, from, to)
+	got, _ := s.getEventsInRange(2, from, to)
+	if len(got) != len(want) {
+		t.Fatalf("%v occurrences, want %v", len(got), len(want))
+	}
+	for i := range want {
+		if !got[i].Date.Equal(want[i].Date) || got[i].Title != want[i].Title {
+			t.Errorf("occurrence %v: %v %q, want %v %q", i, got[i].Date, got[i].Title, want[i].Date, want[i].Title)
+		}
+	}
+}
+
+func TestICSImportForeignUIDs(t *testing.T) {
+	path := t.TempDir() + "/calendar.db"
+	s := openFileStorage(t, path)
+	own := Event{UserID: 1, Title: "своё", Date: time.Date(2024, 1, 9, 10, 0, 0, 0, time.UTC)}
+	if _, err := s.Create(&own, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+
+	vevent := func(uid, date string) string {
+		return "BEGIN:VEVENT\r\nUID:" + uid + "\r\nDTSTART:" + date + "\r\nSUMMARY:" + uid + "\r\nEND:VEVENT\r\n"
+	}
+	calendar := func(events ...string) []icsComponent {
+		components, err := decodeICS(strings.NewReader("BEGIN:VCALENDAR\r\n" + strings.Join(events, "") + "END:VCALENDAR\r\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return components
+	}
+
+	// чужие UID получают следующие по порядку идентификаторы, UID другого пользователя dev11 - тоже чужой
+	entries := importICS(s, 1, calendar(
+		vevent("a@example.com", "20240110T100000Z"),
+		vevent("b@example.com", "20240111T100000Z"),
+		vevent("2-1@dev11", "20240112T100000Z"),
+	))
+	for i, e := range entries {
+		if e.Status != importOK || e.EventID != own.EventID+1+i {
+			t.Errorf("entry %v: %+v", i, e)
+		}
+	}
+
+	ev := Event{UserID: 1, Title: "после импорта", Date: time.Date(2024, 1, 20, 10, 0, 0, 0, time.UTC)}
+	if _, err := s.Create(&ev, conflictWarn); err != nil || ev.EventID != own.EventID+4 {
+		t.Errorf("create after import: id %v, %v", ev.EventID, err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// соответствие UID переживает перезапуск: повторный импорт попадает в те же события
+	s = openFileStorage(t, path)
+	defer s.Close()
+	entries = importICS(s, 1, calendar(vevent("b@example.com", "20240111T100000Z"), vevent("c@example.com", "20240113T100000Z")))
+	if entries[0].Status != importConflict || entries[0].EventID != own.EventID+2 {
+		t.Errorf("reimport %+v", entries[0])
+	}
+	if entries[1].Status != importOK || entries[1].EventID != own.EventID+5 {
+		t.Errorf("new uid %+v", entries[1])
+	}
+
+	// изменение через API UID не теряет, экспорт отдаёт исходный
+	update := Event{UserID: 1, EventID: own.EventID + 1, Title: "изменено", Date: time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)}
+	if _, err := s.Update(&update, conflictWarn); err != nil || update.UID != "a@example.com" {
+		t.Fatalf("update: uid %q, %v", update.UID, err)
+	}
+	events, _ := s.getUserEvents(1)
+	if data := encodeICS(events, time.Now()); !strings.Contains(data, "UID:a@example.com\r\n") || !strings.Contains(data, "UID:1-1@dev11\r\n") {
+		t.Errorf("export:\n%v", data)
+	}
+}
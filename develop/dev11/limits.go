@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bucketIdle - через сколько после полного восполнения корзина забывается
+const bucketIdle = time.Minute
+
+// bucket - корзина токенов одного клиента
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter - token bucket на клиента: rate токенов в секунду, не больше burst в запасе.
+// Стоит перед аутентификацией, поэтому клиент - IP адрес: так ограничиваются и подбор пароля, и неверные токены
+type RateLimiter struct {
+	handler http.Handler
+	rate    float64
+	burst   float64
+	now     func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// Конструктор ограничителя частоты запросов
+func newRateLimiter(handler http.Handler, rate float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		handler: handler,
+		rate:    rate,
+		burst:   float64(burst),
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// take забирает токен клиента key; если токенов нет, возвращает, через сколько появится следующий
+func (l *RateLimiter) take(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep раз в bucketIdle удаляет корзины, которые уже успели наполниться, вызывать под мьютексом
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < bucketIdle {
+		return
+	}
+	l.lastSweep = now
+
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full+bucketIdle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// clientKey - IP адрес клиента
+func clientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+func (l *RateLimiter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ok, wait := l.take(clientKey(r))
+	if !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		getErrResponse(w, "too many requests", http.StatusTooManyRequests)
+		return
+	}
+	l.handler.ServeHTTP(w, r)
+}
+
+// BodyLimit ограничивает размер тела запроса до разбора; для путей из overrides - свой предел
+type BodyLimit struct {
+	handler   http.Handler
+	max       int64
+	overrides map[string]int64
+}
+
+// Конструктор ограничения размера тела
+func newBodyLimit(handler http.Handler, max int64, overrides map[string]int64) *BodyLimit {
+	return &BodyLimit{handler: handler, max: max, overrides: overrides}
+}
+
+func (l *BodyLimit) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	max, ok := l.overrides[r.URL.Path]
+	if !ok {
+		max = l.max
+	}
+	if r.ContentLength > max {
+		getErrorResponse(w, &http.MaxBytesError{Limit: max})
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, max)
+	l.handler.ServeHTTP(w, r)
+}
+
+// bodyError - слишком большое тело остаётся собой (413), остальные ошибки чтения - ошибка поля body
+func bodyError(err error) error {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		return maxErr
+	}
+	return &FieldError{Field: "body", Reason: err.Error()}
+}
+
+// tooLargeMessage - текст ошибки для слишком большого тела
+func tooLargeMessage(err *http.MaxBytesError) string {
+	return fmt.Sprintf("request body is larger than %v bytes", err.Limit)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// limitedRequest выполняет запрос через обработчик и возвращает ответ
+func limitedRequest(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestRateLimiter(t *testing.T) {
+	clock := newTestClock()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	l := newRateLimiter(ok, 0.5, 2)
+	l.now = clock.now
+
+	request := func(addr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/events_for_day", nil)
+		r.RemoteAddr = addr
+		return limitedRequest(l, r)
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := request("10.0.0.1:5000"); w.Code != http.StatusOK {
+			t.Fatalf("request %v within burst: %v", i, w.Code)
+		}
+	}
+	w := request("10.0.0.1:5001")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "2" {
+		t.Fatalf("over the burst: %v, Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+
+	// другой адрес считается отдельно
+	if w := request("10.0.0.2:5000"); w.Code != http.StatusOK {
+		t.Errorf("another address: %v", w.Code)
+	}
+
+	// токен восполняется со скоростью rate
+	clock.advance(time.Second)
+	if w := request("10.0.0.1:5000"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("half a token: %v", w.Code)
+	}
+	clock.advance(time.Second)
+	if w := request("10.0.0.1:5000"); w.Code != http.StatusOK {
+		t.Errorf("after refill: %v", w.Code)
+	}
+
+	// наполнившиеся корзины забываются
+	clock.advance(bucketIdle + 5*time.Second)
+	request("10.0.0.3:5000")
+	if len(l.buckets) != 1 {
+		t.Errorf("%v buckets after sweep", len(l.buckets))
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	h := newBodyLimit(newServer(newMemoryStorage(time.Now), time.Now), 64, map[string]int64{"/events/batch": 1 << 10})
+	body := url.Values{"user_id": {"1"}, "title": {strings.Repeat("x", 100)}, "date": {"2024-01-10"}}.Encode()
+
+	post := func(path, contentType, body string, length int64) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		r.ContentLength = length
+		return limitedRequest(h, r)
+	}
+
+	// Content-Length больше предела - отказ до чтения тела
+	if w := post("/create_event", "application/x-www-form-urlencoded", body, int64(len(body))); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("declared length: %v %s", w.Code, w.Body)
+	}
+	// без Content-Length тело обрывается при чтении
+	if w := post("/create_event", "application/x-www-form-urlencoded", body, -1); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunked body: %v %s", w.Code, w.Body)
+	}
+	if w := post("/create_event", "application/x-www-form-urlencoded", "user_id=1&title=x&date=2024-01-10", -1); w.Code != http.StatusCreated {
+		t.Errorf("small body: %v %s", w.Code, w.Body)
+	}
+
+	// у пути из overrides свой предел
+	batch := `{"operations":[{"op":"create","event":{"user_id":1,"title":"` + strings.Repeat("x", 100) + `","date":"2024-01-11T10:00:00Z"}}]}`
+	if w := post("/events/batch", "application/json", batch, int64(len(batch))); w.Code != http.StatusOK {
+		t.Errorf("batch under its own limit: %v %s", w.Code, w.Body)
+	}
+}
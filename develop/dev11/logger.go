@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// requestIDHeader - заголовок с идентификатором запроса, принимается от клиента или назначается сервером
+const requestIDHeader = "X-Request-ID"
+
+// Форматы журнала запросов
+const (
+	logFormatJSON = "json"
+	logFormatText = "text"
+)
+
+// Logger - для логирования запросов: одна строка на запрос со статусом, размером ответа и X-Request-ID
+type Logger struct {
+	handler http.Handler
+	format  string
+	// out пишет строки без префикса, чтобы JSON разбирался агрегатором как есть
+	out *log.Logger
+}
+
+// Конструктор логгера, format - json (по умолчанию) или text
+func newLogger(handler http.Handler, format string) (*Logger, error) {
+	switch format {
+	case "":
+		format = logFormatJSON
+	case logFormatJSON, logFormatText:
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return &Logger{handler: handler, format: format, out: log.New(os.Stderr, "", 0)}, nil
+}
+
+// statusRecorder запоминает статус и число записанных байт ответа
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(data)
+	rec.bytes += n
+	return n, err
+}
+
+// Flush нужен потоковым ответам
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap даёт http.ResponseController доступ к исходному ResponseWriter
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+type requestIDKey struct{}
+
+// requestID возвращает идентификатор запроса из контекста
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID - идентификатор клиента принимается, если он короткий и из печатных ASCII символов
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// accessRecord - строка журнала запросов
+type accessRecord struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	Method     string    `json:"method"`
+	URL        string    `json:"url"`
+	Status     int       `json:"status"`
+	Bytes      int       `json:"bytes"`
+	DurationMS float64   `json:"duration_ms"`
+	RemoteAddr string    `json:"remote_addr"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+}
+
+// ServeHTTP логика хэндлера, опишем этот метод, чтобы удовлетворить интерфейсу
+func (l *Logger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+
+	id := r.Header.Get(requestIDHeader)
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	w.Header().Set(requestIDHeader, id)
+
+	rec := &statusRecorder{ResponseWriter: w}
+	l.handler.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	if rec.status == 0 {
+		// хэндлер ничего не записал, net/http ответит 200 с пустым телом
+		rec.status = http.StatusOK
+	}
+
+	l.write(accessRecord{
+		Time:       start,
+		RequestID:  id,
+		Method:     r.Method,
+		URL:        r.URL.String(),
+		Status:     rec.status,
+		Bytes:      rec.bytes,
+		DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+		RemoteAddr: r.RemoteAddr,
+		UserAgent:  r.UserAgent(),
+	})
+}
+
+func (l *Logger) write(rec accessRecord) {
+	if l.format == logFormatText {
+		l.out.Printf("%s %s %s %s %d %dB %.3fms", rec.Time.Format(time.RFC3339), rec.RequestID, rec.Method, rec.URL, rec.Status, rec.Bytes, rec.DurationMS)
+		return
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		log.Printf("can't encode access log record: %v", err)
+		return
+	}
+	l.out.Println(string(data))
+}
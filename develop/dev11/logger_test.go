@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestNewLoggerFormat(t *testing.T) {
+	for format, want := range map[string]string{"": logFormatJSON, "json": logFormatJSON, "text": logFormatText, "xml": ""} {
+		l, err := newLogger(http.NotFoundHandler(), format)
+		switch {
+		case want == "" && err == nil:
+			t.Errorf("%q: no error", format)
+		case want != "" && (err != nil || l.format != want):
+			t.Errorf("%q: %v", format, err)
+		}
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		id    string
+		valid bool
+	}{
+		{"", false},
+		{"abc-123", true},
+		{"with space", false},
+		{"tab\t", false},
+		{"кириллица", false},
+		{strings.Repeat("a", 128), true},
+		{strings.Repeat("a", 129), false},
+	}
+	for _, tt := range tests {
+		if got := validRequestID(tt.id); got != tt.valid {
+			t.Errorf("%q: %v", tt.id, got)
+		}
+	}
+}
+
+func TestLoggerRecord(t *testing.T) {
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		requestID string
+		status    int
+		bytes     int
+	}{
+		{"implicit 200", func(w http.ResponseWriter, r *http.Request) {}, "", http.StatusOK, 0},
+		{"write without header", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("hello")) }, "", http.StatusOK, 5},
+		{"first status wins", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("ab"))
+			w.Write([]byte("cd"))
+		}, "client-id", http.StatusTeapot, 4},
+		{"invalid client id is replaced", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "nope", http.StatusBadRequest)
+		}, "bad id", http.StatusBadRequest, 5},
+	}
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var seen string
+			l, _ := newLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				seen = requestID(r.Context())
+				tt.handler(w, r)
+			}), logFormatJSON)
+			var out bytes.Buffer
+			l.out = log.New(&out, "", 0)
+
+			r := httptest.NewRequest(http.MethodGet, "/events?user_id=1", nil)
+			if tt.requestID != "" {
+				r.Header.Set(requestIDHeader, tt.requestID)
+			}
+			w := httptest.NewRecorder()
+			l.ServeHTTP(w, r)
+
+			var rec accessRecord
+			if err := json.Unmarshal(out.Bytes(), &rec); err != nil {
+				t.Fatalf("%v: %q", err, out.String())
+			}
+			if rec.Status != tt.status || rec.Bytes != tt.bytes || rec.Method != http.MethodGet || rec.URL != "/events?user_id=1" {
+				t.Errorf("record %+v", rec)
+			}
+
+			id := w.Header().Get(requestIDHeader)
+			if validRequestID(tt.requestID) {
+				if id != tt.requestID {
+					t.Errorf("id %q, want %q", id, tt.requestID)
+				}
+			} else if !generated.MatchString(id) {
+				t.Errorf("generated id %q", id)
+			}
+			if rec.RequestID != id || seen != id {
+				t.Errorf("id in record %q, in context %q, in header %q", rec.RequestID, seen, id)
+			}
+		})
+	}
+}
+
+func TestLoggerText(t *testing.T) {
+	l, _ := newLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("{}"))
+	}), logFormatText)
+	var out bytes.Buffer
+	l.out = log.New(&out, "", 0)
+
+	r := httptest.NewRequest(http.MethodPost, "/create_event", nil)
+	r.Header.Set(requestIDHeader, "req-1")
+	l.ServeHTTP(httptest.NewRecorder(), r)
+
+	line := regexp.MustCompile(`^\S+ req-1 POST /create_event 201 2B \d+\.\d{3}ms\n$`)
+	if !line.MatchString(out.String()) {
+		t.Errorf("line %q", out.String())
+	}
+}
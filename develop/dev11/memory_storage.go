@@ -0,0 +1,1007 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// storageShards - число шардов хранилища, пользователи распределяются по ним по user_id
+const storageShards = 32
+
+// userEvents - события одного пользователя с индексами:
+// byID - по идентификатору, byDate - интервальный индекс обычных событий,
+// recurring - серии, они разворачиваются при каждом запросе.
+type userEvents struct {
+	byID      map[int]Event
+	byDate    eventIndex
+	recurring map[int]struct{}
+	// nextID - следующий свободный EventID для событий, созданных без идентификатора
+	nextID int
+	// trash - удалённые события, которые ещё можно восстановить
+	trash map[int]TrashedEvent
+	// history - изменения событий, в том числе лежащих в корзине
+	history map[int][]Revision
+	// calendars - календари пользователя кроме основного, nextCalendarID - следующий свободный идентификатор
+	calendars      map[int]Calendar
+	nextCalendarID int
+	// uids - EventID событий по UID из iCalendar, в том числе лежащих в корзине
+	uids map[string]int
+}
+
+func newUserEvents() *userEvents {
+	return &userEvents{
+		byID:      make(map[int]Event),
+		recurring: make(map[int]struct{}),
+		nextID:    1,
+		trash:     make(map[int]TrashedEvent),
+		history:   make(map[int][]Revision),
+		calendars: make(map[int]Calendar),
+		uids:      make(map[string]int),
+
+		nextCalendarID: 1,
+	}
+}
+
+// checkCalendar проверяет, что календарь события существует
+func (u *userEvents) checkCalendar(ev *Event) error {
+	if ev.CalendarID == 0 {
+		return nil
+	}
+	if _, ok := u.calendars[ev.CalendarID]; !ok {
+		return businessErrorf("calendar %v for %v user doesn't exist", ev.CalendarID, ev.UserID)
+	}
+	return nil
+}
+
+// checkCalendarName проверяет, что имя календаря не занято другим календарём пользователя
+func (u *userEvents) checkCalendarName(c *Calendar) error {
+	for _, other := range u.calendars {
+		if other.CalendarID != c.CalendarID && strings.EqualFold(other.Name, c.Name) {
+			return businessErrorf("calendar %q for %v user already exists", c.Name, c.UserID)
+		}
+	}
+	return nil
+}
+
+// calendarUsed проверяет, есть ли в календаре события, в том числе в корзине
+func (u *userEvents) calendarUsed(calendarID int) bool {
+	for _, ev := range u.byID {
+		if ev.CalendarID == calendarID {
+			return true
+		}
+	}
+	for _, t := range u.trash {
+		if t.CalendarID == calendarID {
+			return true
+		}
+	}
+	return false
+}
+
+// insert добавляет событие во все индексы
+func (u *userEvents) insert(ev Event) {
+	u.byID[ev.EventID] = ev
+	if ev.EventID >= u.nextID {
+		u.nextID = ev.EventID + 1
+	}
+	if ev.UID != "" {
+		u.uids[ev.UID] = ev.EventID
+	}
+
+	if ev.Recurrence != nil {
+		u.recurring[ev.EventID] = struct{}{}
+		return
+	}
+
+	u.byDate.insert(ev.Date, ev.end(), ev.EventID)
+}
+
+// empty - у пользователя не осталось ни событий, ни корзины, ни календарей
+func (u *userEvents) empty() bool {
+	return len(u.byID) == 0 && len(u.trash) == 0 && len(u.history) == 0 && len(u.calendars) == 0
+}
+
+// remove убирает событие из всех индексов
+
+func (u *userEvents) remove(id int) (Event, bool) {
+	ev, ok := u.byID[id]
+	if !ok {
+		return Event{}, false
+	}
+	delete(u.byID, id)
+
+	if ev.Recurrence != nil {
+		delete(u.recurring, id)
+		return ev, true
+	}
+
+	u.byDate.remove(ev.Date, id)
+
+	return ev, true
+}
+
+// record добавляет ревизию в историю события: op выполнил пользователь actor в момент at,
+// old и cur - событие до и после, cur == nil - событие ушло в корзину, поля не менялись
+func (u *userEvents) record(op string, at time.Time, actor int, old, cur *Event) {
+	ev := cur
+	if ev == nil {
+		ev = old
+	}
+
+	rev := Revision{Version: ev.Version, Op: op, Time: at, UserID: actor}
+	if cur != nil {
+		rev.Changes = diffEvents(old, cur)
+	}
+	u.history[ev.EventID] = append(u.history[ev.EventID], rev)
+}
+
+// candidates возвращает события, которые могут пересекаться с окном [from, to): все серии
+// и обычные события, которые начались до конца окна и закончились не раньше его начала
+func (u *userEvents) candidates(from, to time.Time) []Event {
+	var res []Event
+	for id := range u.recurring {
+		res = append(res, u.byID[id])
+	}
+
+	u.byDate.overlapping(from, to, func(id int) {
+		res = append(res, u.byID[id])
+	})
+
+	return res
+}
+
+// storageShard - часть пользователей со своей блокировкой, чтения не блокируют друг друга
+type storageShard struct {
+	mu    sync.RWMutex
+	users map[int]*userEvents
+}
+
+// MemoryStorage хранилище эвентов в памяти, все данные теряются при перезапуске
+type MemoryStorage struct {
+	shards  [storageShards]*storageShard
+	invites *inviteIndex
+	// now - время изменений для истории и корзины
+	now func() time.Time
+}
+
+// Конструктор хранилища в памяти, now - часы для времени изменений (time.Now)
+func newMemoryStorage(now func() time.Time) *MemoryStorage {
+	s := &MemoryStorage{invites: newInviteIndex(), now: now}
+	for i := range s.shards {
+		s.shards[i] = &storageShard{users: make(map[int]*userEvents)}
+	}
+	return s
+}
+
+func (s *MemoryStorage) shard(userID int) *storageShard {
+	return s.shards[uint(userID)%storageShards]
+}
+
+// conflictsFor ищет пересечения ev с событиями пользователя, вызывать под блокировкой шарда
+func conflictsFor(u *userEvents, ev *Event) []Event {
+	if u == nil {
+		return nil
+	}
+
+	to := ev.end()
+	if ev.Recurrence != nil {
+		to = to.Add(conflictHorizon)
+	}
+
+	return findConflicts(u.candidates(ev.Date, to.Add(time.Nanosecond)), ev)
+}
+
+// Create создание события в календаре
+func (s *MemoryStorage) Create(ev *Event, policy ConflictPolicy) ([]Event, error) {
+	return s.create(ev, policy, s.now())
+}
+
+// create - Create с заданным временем изменения, файловое хранилище передаёт время из журнала
+func (s *MemoryStorage) create(ev *Event, policy ConflictPolicy, at time.Time) ([]Event, error) {
+	sh := s.shard(ev.UserID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	return s.createLocked(sh, ev, policy, at)
+}
+
+// createLocked - create под уже взятой блокировкой шарда, так же устроены updateLocked и deleteLocked
+func (s *MemoryStorage) createLocked(sh *storageShard, ev *Event, policy ConflictPolicy, at time.Time) ([]Event, error) {
+	u := sh.users[ev.UserID]
+	if u == nil {
+		u = newUserEvents()
+	}
+	// событие с уже известным UID - то же событие, что импортировано раньше
+	if id, ok := u.uids[ev.UID]; ok && ev.UID != "" {
+		if ev.EventID != 0 && ev.EventID != id {
+			return nil, businessErrorf("uid %q is already used by %v event for %v user", ev.UID, id, ev.UserID)
+		}
+		ev.EventID = id
+	}
+	if _, ok := u.byID[ev.EventID]; ok {
+		return nil, businessErrorf("%v event for %v user already exists", ev.EventID, ev.UserID)
+	}
+	if _, ok := u.trash[ev.EventID]; ok {
+		return nil, businessErrorf("%v event for %v user is in trash", ev.EventID, ev.UserID)
+	}
+	if err := u.checkCalendar(ev); err != nil {
+		return nil, err
+	}
+
+	conflicts := conflictsFor(u, ev)
+	if policy == conflictReject && len(conflicts) > 0 {
+		return nil, &ConflictError{Conflicts: conflicts}
+	}
+
+	if ev.EventID == 0 {
+		ev.EventID = u.nextID
+	}
+	ev.Version = 1
+	ev.Attendees = mergeAttendees(ev.Attendees, nil)
+	u.insert(*ev)
+	u.record(opCreate, at, ev.UserID, nil, ev)
+	sh.users[ev.UserID] = u
+	s.invites.set(eventRef{ev.UserID, ev.EventID}, nil, ev.Attendees)
+
+	return conflicts, nil
+}
+
+// Update обновление информации о событии в календаре.
+// Если задан RecurrenceID, меняется только одно вхождение серии, иначе вся серия целиком
+func (s *MemoryStorage) Update(ev *Event, policy ConflictPolicy) ([]Event, error) {
+	return s.update(ev, policy, s.now())
+}
+
+func (s *MemoryStorage) update(ev *Event, policy ConflictPolicy, at time.Time) ([]Event, error) {
+	sh := s.shard(ev.UserID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	return s.updateLocked(sh, ev, policy, at)
+}
+
+func (s *MemoryStorage) updateLocked(sh *storageShard, ev *Event, policy ConflictPolicy, at time.Time) ([]Event, error) {
+	u, old, err := sh.find(ev.UserID, ev.EventID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(ev, old); err != nil {
+		return nil, err
+	}
+	// у вхождения серии календарь серии
+	if ev.RecurrenceID == nil {
+		if err := u.checkCalendar(ev); err != nil {
+			return nil, err
+		}
+	}
+
+	series := *ev
+	series.UID = old.UID
+	series.Attendees = mergeAttendees(ev.Attendees, old.Attendees)
+	if ev.RecurrenceID != nil {
+		if series, err = old.withOverride(*ev); err != nil {
+			return nil, err
+		}
+	} else {
+		// удалённые и изменённые вхождения переживают изменение серии, если запрос их не заменил
+		series.carryOver(&old)
+		ev.Recurrence = series.Recurrence
+	}
+	series.Version = old.Version + 1
+	ev.Version = series.Version
+	ev.Attendees = series.Attendees
+	ev.UID = series.UID
+
+	// для одного вхождения проверяется только оно само
+	conflicts := conflictsFor(u, ev)
+	if policy == conflictReject && len(conflicts) > 0 {
+		return nil, &ConflictError{Conflicts: conflicts}
+	}
+
+	u.remove(ev.EventID)
+	u.insert(series)
+	u.record(opUpdate, at, ev.UserID, &old, &series)
+	s.invites.set(eventRef{ev.UserID, ev.EventID}, old.Attendees, series.Attendees)
+
+	return conflicts, nil
+}
+
+// Delete переносит событие в корзину, возвращает удалённое событие.
+// Если задан RecurrenceID, удаляется только одно вхождение серии, в корзину оно не попадает
+func (s *MemoryStorage) Delete(ev *Event) (*Event, error) {
+	return s.delete(ev, s.now())
+}
+
+func (s *MemoryStorage) delete(ev *Event, at time.Time) (*Event, error) {
+	sh := s.shard(ev.UserID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	return s.deleteLocked(sh, ev, at)
+}
+
+func (s *MemoryStorage) deleteLocked(sh *storageShard, ev *Event, at time.Time) (*Event, error) {
+	u, old, err := sh.find(ev.UserID, ev.EventID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(ev, old); err != nil {
+		return nil, err
+	}
+
+	if ev.RecurrenceID != nil {
+		series, deleted, err := old.withException(*ev.RecurrenceID)
+		if err != nil {
+			return nil, err
+		}
+		series.Version = old.Version + 1
+		deleted.Version = series.Version
+		u.remove(ev.EventID)
+		u.insert(series)
+		u.record(opDelete, at, ev.UserID, &old, &series)
+		return &deleted, nil
+	}
+
+	u.remove(ev.EventID)
+	u.trash[ev.EventID] = TrashedEvent{Event: old, DeletedAt: at}
+	u.record(opDelete, at, ev.UserID, &old, nil)
+	s.invites.set(eventRef{ev.UserID, ev.EventID}, old.Attendees, nil)
+
+	return &old, nil
+}
+
+// Restore возвращает событие из корзины с новой версией, пересечения с другими событиями - предупреждения
+func (s *MemoryStorage) Restore(ev *Event) ([]Event, error) {
+	return s.restore(ev, s.now())
+}
+
+func (s *MemoryStorage) restore(ev *Event, at time.Time) ([]Event, error) {
+	sh := s.shard(ev.UserID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	u, ok := sh.users[ev.UserID]
+	if !ok {
+		return nil, businessErrorf("user %v doesn't exist", ev.UserID)
+	}
+	t, ok := u.trash[ev.EventID]
+	if !ok {
+		return nil, businessErrorf("can't find event with %v id in trash of %v user id", ev.EventID, ev.UserID)
+	}
+
+	*ev = t.Event
+	ev.Version++
+	conflicts := conflictsFor(u, ev)
+
+	delete(u.trash, ev.EventID)
+	u.insert(*ev)
+	u.record(opRestore, at, ev.UserID, &t.Event, ev)
+	s.invites.set(eventRef{ev.UserID, ev.EventID}, nil, ev.Attendees)
+
+	return conflicts, nil
+}
+
+// Purge окончательно удаляет события, попавшие в корзину раньше before, вместе с их историей
+func (s *MemoryStorage) Purge(before time.Time) ([]TrashedEvent, error) {
+	var res []TrashedEvent
+	for _, st := range s.purge(before) {
+		res = append(res, *st.trashed)
+	}
+	return res, nil
+}
+
+// purge возвращает состояния удалённых событий, чтобы их можно было вернуть при откате
+func (s *MemoryStorage) purge(before time.Time) []eventState {
+	var res []eventState
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		for userID, u := range sh.users {
+			for id, t := range u.trash {
+				if !t.DeletedAt.Before(before) {
+					continue
+				}
+				t := t
+				res = append(res, eventState{userID: userID, eventID: id, trashed: &t, history: u.history[id]})
+				delete(u.trash, id)
+				delete(u.history, id)
+				delete(u.uids, t.UID)
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return res
+}
+
+// Batch применяет операции пакета по порядку, см. batch
+func (s *MemoryStorage) Batch(ops []BatchOp, policy ConflictPolicy, atomic bool) ([]BatchResult, error) {
+	results, _ := s.batch(ops, policy, atomic, s.now())
+	return results, nil
+}
+
+// batch применяет операции под блокировками всех затронутых шардов, так что чтения видят пакет целиком.
+// С atomic первая ошибка откатывает выполненные операции, остальные не выполняются.
+// Возвращает результаты и состояния событий до каждой применённой операции - для отката всего пакета
+func (s *MemoryStorage) batch(ops []BatchOp, policy ConflictPolicy, atomic bool, at time.Time) ([]BatchResult, []eventState) {
+	// шарды блокируются по возрастанию номера, чтобы параллельные пакеты не блокировали друг друга навечно
+	var locked [storageShards]bool
+	for _, op := range ops {
+		locked[uint(op.Event.UserID)%storageShards] = true
+	}
+	for i, ok := range locked {
+		if ok {
+			s.shards[i].mu.Lock()
+			defer s.shards[i].mu.Unlock()
+		}
+	}
+
+	results := make([]BatchResult, len(ops))
+	var undo []eventState
+	for i, op := range ops {
+		ev := op.Event
+		sh := s.shard(ev.UserID)
+		st := sh.state(ev.UserID, ev.EventID)
+
+		var err error
+		switch op.Op {
+		case opCreate:
+			results[i].Warnings, err = s.createLocked(sh, &ev, policy, at)
+			// идентификатор мог назначить create
+			st.eventID = ev.EventID
+		case opUpdate:
+			results[i].Warnings, err = s.updateLocked(sh, &ev, policy, at)
+		case opDelete:
+			var deleted *Event
+			if deleted, err = s.deleteLocked(sh, &ev, at); err == nil {
+				ev = *deleted
+			}
+		default:
+			err = businessErrorf("unknown operation %q", op.Op)
+		}
+
+		if err != nil {
+			results[i] = BatchResult{Err: err}
+			if !atomic {
+				continue
+			}
+			for j := len(undo) - 1; j >= 0; j-- {
+				s.setStateLocked(s.shard(undo[j].userID), undo[j])
+			}
+			for j := range results {
+				if j != i {
+					results[j] = BatchResult{Err: errNotApplied}
+				}
+			}
+			return results, nil
+		}
+
+		results[i].Event = &ev
+		undo = append(undo, st)
+	}
+
+	return results, undo
+}
+
+// Respond сохраняет ответ участника, ответ относится ко всей серии и меняет версию события
+func (s *MemoryStorage) Respond(organizerID, eventID, userID int, status string) (*Event, error) {
+	return s.respond(organizerID, eventID, userID, status, s.now())
+}
+
+func (s *MemoryStorage) respond(organizerID, eventID, userID int, status string, at time.Time) (*Event, error) {
+	sh := s.shard(organizerID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	u, old, err := sh.find(organizerID, eventID)
+	if err != nil {
+		return nil, err
+	}
+
+	ev, err := old.withResponse(userID, status)
+	if err != nil {
+		return nil, err
+	}
+	ev.Version = old.Version + 1
+
+	u.remove(eventID)
+	u.insert(ev)
+	u.record(opRespond, at, userID, &old, &ev)
+
+	return &ev, nil
+}
+
+// CreateCalendar создаёт календарь пользователя, с CalendarID == 0 идентификатор назначается
+func (s *MemoryStorage) CreateCalendar(c *Calendar) error {
+	sh := s.shard(c.UserID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	u := sh.users[c.UserID]
+	if u == nil {
+		u = newUserEvents()
+	}
+	if _, ok := u.calendars[c.CalendarID]; ok {
+		return businessErrorf("calendar %v for %v user already exists", c.CalendarID, c.UserID)
+	}
+	if c.CalendarID == 0 {
+		c.CalendarID = u.nextCalendarID
+	}
+	if err := u.checkCalendarName(c); err != nil {
+		return err
+	}
+
+	u.putCalendar(*c)
+	sh.users[c.UserID] = u
+	return nil
+}
+
+// UpdateCalendar меняет название, цвет и описание календаря
+func (s *MemoryStorage) UpdateCalendar(c *Calendar) error {
+	sh := s.shard(c.UserID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	u, ok := sh.users[c.UserID]
+	if !ok {
+		return businessErrorf("user %v doesn't exist", c.UserID)
+	}
+	if _, ok := u.calendars[c.CalendarID]; !ok {
+		return businessErrorf("calendar %v for %v user doesn't exist", c.CalendarID, c.UserID)
+	}
+	if err := u.checkCalendarName(c); err != nil {
+		return err
+	}
+
+	u.putCalendar(*c)
+	return nil
+}
+
+// DeleteCalendar удаляет пустой календарь и возвращает его
+func (s *MemoryStorage) DeleteCalendar(userID, calendarID int) (*Calendar, error) {
+	sh := s.shard(userID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	u, ok := sh.users[userID]
+	if !ok {
+		return nil, businessErrorf("user %v doesn't exist", userID)
+	}
+	c, ok := u.calendars[calendarID]
+	if !ok {
+		return nil, businessErrorf("calendar %v for %v user doesn't exist", calendarID, userID)
+	}
+	if u.calendarUsed(calendarID) {
+		return nil, businessErrorf("calendar %v for %v user has events", calendarID, userID)
+	}
+
+	delete(u.calendars, calendarID)
+	return &c, nil
+}
+
+// putCalendar сохраняет календарь как есть, вызывать под блокировкой шарда
+func (u *userEvents) putCalendar(c Calendar) {
+	u.calendars[c.CalendarID] = c
+	if c.CalendarID >= u.nextCalendarID {
+		u.nextCalendarID = c.CalendarID + 1
+	}
+}
+
+// calendar возвращает календарь и признак его наличия
+func (s *MemoryStorage) calendar(userID, calendarID int) (Calendar, bool) {
+	sh := s.shard(userID)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	u, ok := sh.users[userID]
+	if !ok {
+		return Calendar{}, false
+	}
+	c, ok := u.calendars[calendarID]
+	return c, ok
+}
+
+// setCalendar возвращает календарь в сохранённое состояние, nil - календаря не было:
+// восстановление из снапшота и откат
+func (s *MemoryStorage) setCalendar(userID, calendarID int, c *Calendar) {
+	sh := s.shard(userID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	u := sh.users[userID]
+	if u == nil {
+		u = newUserEvents()
+		sh.users[userID] = u
+	}
+	delete(u.calendars, calendarID)
+	if c != nil {
+		u.putCalendar(*c)
+	}
+}
+
+// getCalendars возвращает календари пользователя по возрастанию идентификатора
+func (s *MemoryStorage) getCalendars(userID int) ([]Calendar, error) {
+	sh := s.shard(userID)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	u, ok := sh.users[userID]
+	if !ok {
+		return nil, businessErrorf("user %v doesn't exist", userID)
+	}
+
+	res := make([]Calendar, 0, len(u.calendars))
+	for _, c := range u.calendars {
+		res = append(res, c)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].CalendarID < res[j].CalendarID })
+
+	return res, nil
+}
+
+// Close у хранилища в памяти освобождать нечего
+func (s *MemoryStorage) Close() error {
+	return nil
+}
+
+// find ищет событие пользователя, вызывать под блокировкой шарда
+func (sh *storageShard) find(userID, eventID int) (*userEvents, Event, error) {
+	u, ok := sh.users[userID]
+	if !ok {
+		return nil, Event{}, businessErrorf("user %v doesn't exist", userID)
+	}
+
+	ev, ok := u.byID[eventID]
+	if !ok {
+		return nil, Event{}, businessErrorf("can't find event with %v id for %v user id", eventID, userID)
+	}
+
+	return u, ev, nil
+}
+
+// put сохраняет событие как есть, без проверок и смены версии: восстановление из снапшота и откат
+func (s *MemoryStorage) put(ev Event) {
+	sh := s.shard(ev.UserID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	u := sh.users[ev.UserID]
+	if u == nil {
+		u = newUserEvents()
+		sh.users[ev.UserID] = u
+	}
+	old, _ := u.remove(ev.EventID)
+	u.insert(ev)
+	s.invites.set(eventRef{ev.UserID, ev.EventID}, old.Attendees, ev.Attendees)
+}
+
+// putTrash кладёт событие в корзину как есть: восстановление из снапшота
+func (s *MemoryStorage) putTrash(t TrashedEvent) {
+	sh := s.shard(t.UserID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	u := sh.users[t.UserID]
+	if u == nil {
+		u = newUserEvents()
+		sh.users[t.UserID] = u
+	}
+	if t.EventID >= u.nextID {
+		u.nextID = t.EventID + 1
+	}
+	if t.UID != "" {
+		u.uids[t.UID] = t.EventID
+	}
+	u.trash[t.EventID] = t
+}
+
+// putHistory сохраняет историю события как есть: восстановление из снапшота
+func (s *MemoryStorage) putHistory(h eventHistory) {
+	sh := s.shard(h.UserID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	u := sh.users[h.UserID]
+	if u == nil {
+		u = newUserEvents()
+		sh.users[h.UserID] = u
+	}
+	u.history[h.EventID] = h.Revisions
+}
+
+// eventState - всё, что хранилище знает об одном событии, для отката неудачной записи в журнал
+type eventState struct {
+	userID, eventID int
+	live            *Event
+	trashed         *TrashedEvent
+	history         []Revision
+	// newUser - записи пользователя ещё не было, откат удаляет её вместе с событием
+	newUser bool
+}
+
+// state возвращает текущее состояние события
+func (s *MemoryStorage) state(userID, eventID int) eventState {
+	sh := s.shard(userID)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	return sh.state(userID, eventID)
+}
+
+// state - состояние события, вызывать под блокировкой шарда
+func (sh *storageShard) state(userID, eventID int) eventState {
+	st := eventState{userID: userID, eventID: eventID}
+	u, ok := sh.users[userID]
+	if !ok {
+		st.newUser = true
+		return st
+	}
+	if ev, ok := u.byID[eventID]; ok {
+		st.live = &ev
+	}
+	if t, ok := u.trash[eventID]; ok {
+		st.trashed = &t
+	}
+	// append дописывает ревизии за пределами длины, сохранённый срез они не затрагивают
+	st.history = u.history[eventID]
+	return st
+}
+
+// setState возвращает событие в сохранённое состояние
+func (s *MemoryStorage) setState(st eventState) {
+	sh := s.shard(st.userID)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	s.setStateLocked(sh, st)
+}
+
+// setStateLocked - setState под уже взятой блокировкой шарда
+func (s *MemoryStorage) setStateLocked(sh *storageShard, st eventState) {
+	u := sh.users[st.userID]
+	if u == nil {
+		u = newUserEvents()
+		sh.users[st.userID] = u
+	}
+
+	old, _ := u.remove(st.eventID)
+	delete(u.uids, old.UID)
+	delete(u.uids, u.trash[st.eventID].UID)
+
+	var attendees []Attendee
+	if st.live != nil {
+		u.insert(*st.live)
+		attendees = st.live.Attendees
+	}
+	s.invites.set(eventRef{st.userID, st.eventID}, old.Attendees, attendees)
+
+	delete(u.trash, st.eventID)
+	if st.trashed != nil {
+		u.trash[st.eventID] = *st.trashed
+		if st.trashed.UID != "" {
+			u.uids[st.trashed.UID] = st.eventID
+		}
+	}
+
+	delete(u.history, st.eventID)
+	if st.history != nil {
+		u.history[st.eventID] = st.history
+	}
+
+	if st.newUser && u.empty() {
+		delete(sh.users, st.userID)
+	}
+}
+
+// get возвращает копию события по идентификаторам
+func (s *MemoryStorage) get(userID, eventID int) (Event, bool) {
+	sh := s.shard(userID)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	_, ev, err := sh.find(userID, eventID)
+	return ev, err == nil
+}
+
+// getEvent возвращает событие пользователя по идентификатору
+func (s *MemoryStorage) getEvent(userID, eventID int) (Event, error) {
+	sh := s.shard(userID)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	_, ev, err := sh.find(userID, eventID)
+	return ev, err
+}
+
+// dump возвращает копию всех данных хранилища, используется для снапшота
+func (s *MemoryStorage) dump() snapshot {
+	var res snapshot
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for userID, u := range sh.users {
+			for _, ev := range u.byID {
+				res.Events = append(res.Events, ev)
+			}
+			for _, t := range u.trash {
+				res.Trash = append(res.Trash, t)
+			}
+			for id, revs := range u.history {
+				res.History = append(res.History, eventHistory{UserID: userID, EventID: id, Revisions: revs})
+			}
+			for _, c := range u.calendars {
+				res.Calendars = append(res.Calendars, c)
+			}
+		}
+		sh.mu.RUnlock()
+	}
+
+	return res
+}
+
+// eventCounts возвращает число событий каждого пользователя хранилища
+func (s *MemoryStorage) eventCounts() map[int]int {
+	res := make(map[int]int)
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for userID, u := range sh.users {
+			res[userID] = len(u.byID)
+		}
+		sh.mu.RUnlock()
+	}
+
+	return res
+}
+
+// getUserIDs возвращает идентификаторы всех пользователей хранилища
+func (s *MemoryStorage) getUserIDs() []int {
+	var res []int
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		for userID := range sh.users {
+			res = append(res, userID)
+		}
+		sh.mu.RUnlock()
+	}
+	sort.Ints(res)
+
+	return res
+}
+
+// sortEvents упорядочивает вхождения по началу, при равенстве - по идентификатору
+func sortEvents(events []Event) {
+	sort.SliceStable(events, func(i, j int) bool {
+		if !events[i].Date.Equal(events[j].Date) {
+			return events[i].Date.Before(events[j].Date)
+		}
+		return events[i].EventID < events[j].EventID
+	})
+}
+
+// getEventsInRange возвращает вхождения событий пользователя и событий, куда он приглашён, в окне [from, to).
+// Повторяющиеся события разворачиваются. Шард приглашающего блокируется отдельно, после снятия своей блокировки
+func (s *MemoryStorage) getEventsInRange(userID int, from, to time.Time) ([]Event, error) {
+	res, ok := s.ownOccurrences(userID, from, to)
+
+	refs := s.invites.refs(userID)
+	if !ok && len(refs) == 0 {
+		return nil, businessErrorf("user %v doesn't exist", userID)
+	}
+	for _, ref := range refs {
+		if ev, ok := s.get(ref.userID, ref.eventID); ok {
+			res = append(res, ev.occurrences(from, to)...)
+		}
+	}
+	sortEvents(res)
+
+	return res, nil
+}
+
+// getOwnEventsInRange возвращает вхождения только собственных событий пользователя в окне [from, to)
+func (s *MemoryStorage) getOwnEventsInRange(userID int, from, to time.Time) ([]Event, error) {
+	res, ok := s.ownOccurrences(userID, from, to)
+	if !ok {
+		return nil, businessErrorf("user %v doesn't exist", userID)
+	}
+	sortEvents(res)
+	return res, nil
+}
+
+// ownOccurrences разворачивает собственные события пользователя в окне [from, to), ok - пользователь есть
+func (s *MemoryStorage) ownOccurrences(userID int, from, to time.Time) ([]Event, bool) {
+	sh := s.shard(userID)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	u, ok := sh.users[userID]
+	if !ok {
+		return nil, false
+	}
+	var res []Event
+	for _, ev := range u.candidates(from, to) {
+		res = append(res, ev.occurrences(from, to)...)
+	}
+	return res, true
+}
+
+func (s *MemoryStorage) getEventsForDay(userID int, date time.Time) ([]Event, error) {
+	from, to := dayRange(date)
+	return s.getEventsInRange(userID, from, to)
+}
+
+func (s *MemoryStorage) getEventsForWeek(userID int, date time.Time) ([]Event, error) {
+	from, to := weekRange(date)
+	return s.getEventsInRange(userID, from, to)
+}
+
+func (s *MemoryStorage) getEventsForMonth(userID int, date time.Time) ([]Event, error) {
+	from, to := monthRange(date)
+	return s.getEventsInRange(userID, from, to)
+}
+
+// getUserEvents возвращает все события пользователя без разворачивания серий
+func (s *MemoryStorage) getUserEvents(userID int) ([]Event, error) {
+	sh := s.shard(userID)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	u, ok := sh.users[userID]
+	if !ok {
+		return nil, businessErrorf("user %v doesn't exist", userID)
+	}
+
+	res := make([]Event, 0, len(u.byID))
+	for _, ev := range u.byID {
+		res = append(res, ev)
+	}
+	sortEvents(res)
+
+	return res, nil
+}
+
+// getTrash возвращает корзину пользователя, раньше удалённые - первыми
+func (s *MemoryStorage) getTrash(userID int) ([]TrashedEvent, error) {
+	sh := s.shard(userID)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	u, ok := sh.users[userID]
+	if !ok {
+		return nil, businessErrorf("user %v doesn't exist", userID)
+	}
+
+	res := make([]TrashedEvent, 0, len(u.trash))
+	for _, t := range u.trash {
+		res = append(res, t)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].DeletedAt.Equal(res[j].DeletedAt) {
+			return res[i].DeletedAt.Before(res[j].DeletedAt)
+		}
+		return res[i].EventID < res[j].EventID
+	})
+
+	return res, nil
+}
+
+// getHistory возвращает историю события, в том числе лежащего в корзине
+func (s *MemoryStorage) getHistory(userID, eventID int) ([]Revision, error) {
+	sh := s.shard(userID)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	u, ok := sh.users[userID]
+	if !ok {
+		return nil, businessErrorf("user %v doesn't exist", userID)
+	}
+
+	revs, ok := u.history[eventID]
+	if !ok {
+		_, live := u.byID[eventID]
+		_, trashed := u.trash[eventID]
+		if !live && !trashed {
+			return nil, businessErrorf("can't find event with %v id for %v user id", eventID, userID)
+		}
+	}
+
+	return append([]Revision(nil), revs...), nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestEventIndexOverlapping(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	type span struct {
+		start, end time.Time
+	}
+	var idx eventIndex
+	events := make(map[int]span)
+	for id := 1; id <= 2000; id++ {
+		start := base.Add(time.Duration(rnd.Intn(365*24)) * time.Hour)
+		end := start.Add(time.Duration(rnd.Intn(72)) * time.Hour)
+		if id%500 == 0 {
+			end = start.AddDate(1, 0, 0)
+		}
+		events[id] = span{start, end}
+		idx.insert(start, end, id)
+	}
+	// удаление в том числе самых длинных событий должно сужать выборки
+	for id := range events {
+		if id%3 == 0 || id%500 == 0 {
+			idx.remove(events[id].start, id)
+			delete(events, id)
+		}
+	}
+	if idx.size != len(events) {
+		t.Fatalf("size %v, want %v", idx.size, len(events))
+	}
+
+	for i := 0; i < 200; i++ {
+		from := base.Add(time.Duration(rnd.Intn(400*24)) * time.Hour)
+		to := from.Add(time.Duration(rnd.Intn(96)+1) * time.Hour)
+
+		var want []int
+		for id, s := range events {
+			if s.start.Before(to) && !s.end.Before(from) {
+				want = append(want, id)
+			}
+		}
+		sort.Ints(want)
+
+		var got []int
+		var prev time.Time
+		idx.overlapping(from, to, func(id int) {
+			if events[id].start.Before(prev) {
+				t.Errorf("event %v is out of order", id)
+			}
+			prev = events[id].start
+			got = append(got, id)
+		})
+		sort.Ints(got)
+
+		if len(got) != len(want) {
+			t.Fatalf("[%v, %v): got %v events, want %v", from, to, len(got), len(want))
+		}
+		for j := range got {
+			if got[j] != want[j] {
+				t.Fatalf("[%v, %v): got %v, want %v", from, to, got, want)
+			}
+		}
+	}
+}
+
+func TestMemoryStorageLongEventRemoved(t *testing.T) {
+	s := newMemoryStorage(time.Now)
+	long := Event{UserID: 1, Title: "Отпуск", Date: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	end := long.Date.AddDate(0, 0, 400)
+	long.End = &end
+	if _, err := s.Create(&long, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+	short := Event{UserID: 1, Title: "Встреча", Date: time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)}
+	if _, err := s.Create(&short, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	events, err := s.getEventsForDay(1, day)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("before delete: %v events, %v", len(events), err)
+	}
+
+	if _, err := s.Delete(&Event{UserID: 1, EventID: long.EventID}); err != nil {
+		t.Fatal(err)
+	}
+	events, err = s.getEventsForDay(1, day)
+	if err != nil || len(events) != 1 || events[0].EventID != short.EventID {
+		t.Fatalf("after delete: %+v, %v", events, err)
+	}
+}
+
+const benchEvents = 100000
+
+// benchStorage заполняет хранилище событиями одного пользователя в случайном порядке дат
+// и одним событием длиной 400 дней
+func benchStorage(b *testing.B) *MemoryStorage {
+	b.Helper()
+	s := newMemoryStorage(time.Now)
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rnd := rand.New(rand.NewSource(1))
+
+	long := Event{UserID: 1, Title: "long", Date: base}
+	end := base.AddDate(0, 0, 400)
+	long.End = &end
+	if _, err := s.Create(&long, conflictWarn); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < benchEvents; i++ {
+		ev := Event{UserID: 1, Title: "event", Date: base.Add(time.Duration(rnd.Intn(5*365*24)) * time.Hour)}
+		end := ev.Date.Add(time.Hour)
+		ev.End = &end
+		if _, err := s.Create(&ev, conflictWarn); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return s
+}
+
+func BenchmarkCreate(b *testing.B) {
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	rnd := rand.New(rand.NewSource(1))
+	for n := 0; n < b.N; n++ {
+		s := newMemoryStorage(time.Now)
+		for i := 0; i < benchEvents; i++ {
+			ev := Event{UserID: 1, Title: "event", Date: base.Add(time.Duration(rnd.Intn(5*365*24)) * time.Hour)}
+			end := ev.Date.Add(time.Hour)
+			ev.End = &end
+			if _, err := s.Create(&ev, conflictWarn); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkRange(b *testing.B) {
+	s := benchStorage(b)
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		day := base.AddDate(0, 0, n%1000)
+		if _, err := s.getEventsForDay(1, day); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
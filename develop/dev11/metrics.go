@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets - верхние границы корзин гистограммы задержек в секундах, как в клиентах Prometheus по умолчанию
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// routeStatus - метки счётчиков запросов
+type routeStatus struct {
+	route  string
+	status int
+}
+
+// histogram - накопительная гистограмма: counts[i] - число наблюдений не больше latencyBuckets[i]
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func (h *histogram) observe(v float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(latencyBuckets))
+	}
+	for i, le := range latencyBuckets {
+		if v <= le {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// routeMatcher находит шаблон маршрута запроса (http.ServeMux, Router)
+type routeMatcher interface {
+	Handler(r *http.Request) (http.Handler, string)
+}
+
+// Metrics - middleware, считающее запросы, задержки и ошибки по маршрутам.
+// Маршрут берётся из шаблона mux, чтобы произвольные пути не раздували число рядов
+type Metrics struct {
+	handler http.Handler
+	mux     routeMatcher
+
+	mu        sync.Mutex
+	latencies map[routeStatus]*histogram
+	errors    map[string]uint64
+}
+
+// Конструктор middleware метрик
+func newMetrics(handler http.Handler, mux routeMatcher) *Metrics {
+	return &Metrics{
+		handler:   handler,
+		mux:       mux,
+		latencies: make(map[routeStatus]*histogram),
+		errors:    make(map[string]uint64),
+	}
+}
+
+// errorClass относит статус к классу ошибок спецификации: входные данные, бизнес-логика, остальное.
+// Конфликты (409) и устаревшие версии (412) - не ошибки клиента в разборе запроса, у них свои классы
+func errorClass(status int) string {
+	switch {
+	case status < 400:
+		return ""
+	case status == http.StatusConflict:
+		return "409"
+	case status == http.StatusPreconditionFailed:
+		return "412"
+	case status < 500:
+		return "400"
+	case status == http.StatusServiceUnavailable:
+		return "503"
+	default:
+		return "500"
+	}
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	route := "other"
+	if _, pattern := m.mux.Handler(r); pattern != "" {
+		route = pattern
+	}
+
+	rec := &statusRecorder{ResponseWriter: w}
+	m.handler.ServeHTTP(rec, r)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := routeStatus{route: route, status: rec.status}
+	h := m.latencies[key]
+	if h == nil {
+		h = &histogram{}
+		m.latencies[key] = h
+	}
+	h.observe(time.Since(start).Seconds())
+	if class := errorClass(rec.status); class != "" {
+		m.errors[class]++
+	}
+}
+
+// labelValue экранирует значение метки по формату Prometheus
+func labelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// writeTo пишет метрики HTTP в текстовом формате Prometheus
+func (m *Metrics) writeTo(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]routeStatus, 0, len(m.latencies))
+	for k := range m.latencies {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].status < keys[j].status
+	})
+
+	fmt.Fprintln(w, "# HELP dev11_http_requests_total Processed HTTP requests by route and status.")
+	fmt.Fprintln(w, "# TYPE dev11_http_requests_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "dev11_http_requests_total{route=\"%s\",status=\"%d\"} %d\n", labelValue(k.route), k.status, m.latencies[k].count)
+	}
+
+	fmt.Fprintln(w, "# HELP dev11_http_request_duration_seconds HTTP request latency by route and status.")
+	fmt.Fprintln(w, "# TYPE dev11_http_request_duration_seconds histogram")
+	for _, k := range keys {
+		h := m.latencies[k]
+		labels := fmt.Sprintf("route=\"%s\",status=\"%d\"", labelValue(k.route), k.status)
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(w, "dev11_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", labels, formatFloat(le), h.counts[i])
+		}
+		fmt.Fprintf(w, "dev11_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.count)
+		fmt.Fprintf(w, "dev11_http_request_duration_seconds_sum{%s} %s\n", labels, formatFloat(h.sum))
+		fmt.Fprintf(w, "dev11_http_request_duration_seconds_count{%s} %d\n", labels, h.count)
+	}
+
+	fmt.Fprintln(w, "# HELP dev11_http_errors_total Error responses by class: 400 - client errors (4xx), 409 - conflicts, 412 - version mismatches, 503 - business logic, 500 - other server errors.")
+	fmt.Fprintln(w, "# TYPE dev11_http_errors_total counter")
+	for _, class := range []string{"400", "409", "412", "503", "500"} {
+		fmt.Fprintf(w, "dev11_http_errors_total{class=\"%s\"} %d\n", class, m.errors[class])
+	}
+}
+
+// writeStorageMetrics пишет число событий (серия считается одним событием) каждого пользователя.
+// Счётчики берутся из хранилища, события при этом не копируются
+func writeStorageMetrics(w io.Writer, s Storage) {
+	counts := s.eventCounts()
+	userIDs := make([]int, 0, len(counts))
+	for userID := range counts {
+		userIDs = append(userIDs, userID)
+	}
+	sort.Ints(userIDs)
+
+	fmt.Fprintln(w, "# HELP dev11_storage_events Stored events per user.")
+	fmt.Fprintln(w, "# TYPE dev11_storage_events gauge")
+	for _, userID := range userIDs {
+		fmt.Fprintf(w, "dev11_storage_events{user_id=\"%d\"} %d\n", userID, counts[userID])
+	}
+}
+
+// MetricsHandler /metrics handler, s - хранилище для метрик по событиям
+func MetricsHandler(m *Metrics, s Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		m.writeTo(w)
+		writeStorageMetrics(w, s)
+	}
+}
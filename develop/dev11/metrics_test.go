@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorClass(t *testing.T) {
+	for status, want := range map[int]string{
+		http.StatusOK:                    "",
+		http.StatusCreated:               "",
+		http.StatusBadRequest:            "400",
+		http.StatusNotFound:              "400",
+		http.StatusRequestEntityTooLarge: "400",
+		http.StatusConflict:              "409",
+		http.StatusPreconditionFailed:    "412",
+		http.StatusServiceUnavailable:    "503",
+		http.StatusInternalServerError:   "500",
+	} {
+		if got := errorClass(status); got != want {
+			t.Errorf("status %v: class %q, want %q", status, got, want)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	s := newMemoryStorage(time.Now)
+	for _, ev := range []Event{
+		{UserID: 1, Title: "a", Date: time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)},
+		{UserID: 1, Title: "b", Date: time.Date(2024, 1, 11, 10, 0, 0, 0, time.UTC)},
+		{UserID: 2, Title: "c", Date: time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)},
+	} {
+		if _, err := s.Create(&ev, conflictWarn); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// обработчик отвечает статусом из запроса
+	srv := newServer(s, time.Now)
+	m := newMetrics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, _ := strconv.Atoi(r.URL.Query().Get("status"))
+		w.WriteHeader(status)
+	}), srv)
+	for _, status := range []int{200, 400, 409, 409, 412, 503, 500} {
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/update_event?status="+strconv.Itoa(status), nil))
+	}
+
+	w := httptest.NewRecorder()
+	MetricsHandler(m, s)(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`dev11_http_requests_total{route="/update_event",status="409"} 2`,
+		`dev11_http_errors_total{class="400"} 1`,
+		`dev11_http_errors_total{class="409"} 2`,
+		`dev11_http_errors_total{class="412"} 1`,
+		`dev11_http_errors_total{class="503"} 1`,
+		`dev11_http_errors_total{class="500"} 1`,
+		`dev11_storage_events{user_id="1"} 2`,
+		`dev11_storage_events{user_id="2"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("no %q in\n%s", line, body)
+		}
+	}
+
+	// события в корзине не считаются
+	if _, err := s.Delete(&Event{UserID: 2, EventID: 1}); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	MetricsHandler(m, s)(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if line := `dev11_storage_events{user_id="2"} 0`; !strings.Contains(w.Body.String(), line+"\n") {
+		t.Errorf("no %q after delete", line)
+	}
+}
+
+func TestMetricsRequireToken(t *testing.T) {
+	tokens, err := newTokenIssuer("test secret", time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newMemoryStorage(time.Now)
+	h := newAuth(MetricsHandler(newMetrics(http.NotFoundHandler(), newRouter()), s), tokens, publicPaths...)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("without token: %v", w.Code)
+	}
+
+	token, _, err := tokens.issue(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("with token: %v", w.Code)
+	}
+}
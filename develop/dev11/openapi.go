@@ -0,0 +1,17 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec - описание API, при изменении хэндлеров openapi.json правится вместе с ними
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// OpenAPIHandler /openapi.json handler
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Частоты повторения, аналог FREQ из RRULE (RFC 5545)
+const (
+	freqDaily   = "daily"
+	freqWeekly  = "weekly"
+	freqMonthly = "monthly"
+	freqYearly  = "yearly"
+)
+
+// weekdays - коды дней недели как в BYDAY
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// parseByDay разбирает элемент BYDAY: код дня с необязательным номером в периоде,
+// "2MO" - второй понедельник, "-1FR" - последняя пятница, "MO" - каждый понедельник
+func parseByDay(value string) (ord int, wd time.Weekday, ok bool) {
+	if len(value) < 2 {
+		return 0, 0, false
+	}
+	wd, ok = weekdays[value[len(value)-2:]]
+	if !ok {
+		return 0, 0, false
+	}
+	if num := value[:len(value)-2]; num != "" {
+		n, err := strconv.Atoi(num)
+		if err != nil || n == 0 || num[0] == '0' {
+			return 0, 0, false
+		}
+		ord = n
+	}
+	return ord, wd, true
+}
+
+// Recurrence - правило повторения события.
+// Exceptions - даты удалённых вхождений (EXDATE), Overrides - изменённые вхождения,
+// у каждого из них RecurrenceID указывает на исходную дату вхождения.
+type Recurrence struct {
+	Freq       string      `json:"freq"`
+	Interval   int         `json:"interval,omitempty"`
+	ByDay      []string    `json:"by_day,omitempty"`
+	Count      int         `json:"count,omitempty"`
+	Until      *time.Time  `json:"until,omitempty"`
+	Exceptions []time.Time `json:"exceptions,omitempty"`
+	Overrides  []Event     `json:"overrides,omitempty"`
+}
+
+// validate проверяет правило повторения, ошибки полей собираются все сразу
+func (r *Recurrence) validate() error {
+	var errs ValidationError
+
+	switch r.Freq {
+	case freqDaily, freqWeekly, freqMonthly, freqYearly:
+	default:
+		errs.add("recurrence.freq", "must be one of daily, weekly, monthly, yearly")
+	}
+
+	if r.Interval < 0 {
+		errs.add("recurrence.interval", "must not be negative")
+	}
+	if r.Count < 0 {
+		errs.add("recurrence.count", "must not be negative")
+	}
+	if r.Count > 0 && r.Until != nil {
+		errs.add("recurrence.until", "can't be used together with count")
+	}
+	// номер дня в периоде имеет смысл только для monthly (до 5) и yearly (до 53)
+	maxOrd := 0
+	switch r.Freq {
+	case freqMonthly:
+		maxOrd = 5
+	case freqYearly:
+		maxOrd = 53
+	}
+	for _, day := range r.ByDay {
+		ord, _, ok := parseByDay(day)
+		switch {
+		case !ok:
+			errs.add("recurrence.by_day", fmt.Sprintf("unknown day %q", day))
+		case ord != 0 && maxOrd == 0:
+			errs.add("recurrence.by_day", fmt.Sprintf("day %q: numbered days are supported only for monthly and yearly freq", day))
+		case ord > maxOrd || ord < -maxOrd:
+			errs.add("recurrence.by_day", fmt.Sprintf("day %q: number must be between -%v and %v", day, maxOrd, maxOrd))
+		}
+	}
+
+	return errs.err()
+}
+
+// clone глубокая копия правила, хранилище не меняет правила на месте
+func (r *Recurrence) clone() *Recurrence {
+	c := *r
+	c.ByDay = append([]string(nil), r.ByDay...)
+	c.Exceptions = append([]time.Time(nil), r.Exceptions...)
+	c.Overrides = append([]Event(nil), r.Overrides...)
+	return &c
+}
+
+// isException проверяет, удалено ли вхождение
+func (r *Recurrence) isException(t time.Time) bool {
+	for _, ex := range r.Exceptions {
+		if ex.Equal(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// override возвращает индекс изменённого вхождения или -1
+func (r *Recurrence) override(t time.Time) int {
+	for i, o := range r.Overrides {
+		if o.RecurrenceID != nil && o.RecurrenceID.Equal(t) {
+			return i
+		}
+	}
+	return -1
+}
+
+// byDayOffsets переводит BYDAY в смещения от понедельника, по возрастанию
+func (r *Recurrence) byDayOffsets() []int {
+	var offsets []int
+	for _, day := range r.ByDay {
+		offsets = append(offsets, (int(weekdays[day])+6)%7)
+	}
+	sort.Ints(offsets)
+	return offsets
+}
+
+// byDayIn возвращает дни периода из days суток, начинающегося с first, подходящие под BYDAY,
+// по возрастанию; время суток берётся из first
+func (r *Recurrence) byDayIn(first time.Time, days int) []time.Time {
+	y, m, d := first.Date()
+	h, mi, s := first.Clock()
+
+	seen := make(map[int]bool)
+	var offsets []int
+	for _, day := range r.ByDay {
+		ord, wd, _ := parseByDay(day)
+		// смещение первого такого дня недели от начала периода и сколько их в периоде
+		off := (int(wd) - int(first.Weekday()) + 7) % 7
+		if off >= days {
+			continue
+		}
+		count := (days-1-off)/7 + 1
+
+		var ks []int
+		switch {
+		case ord == 0:
+			for k := 0; k < count; k++ {
+				ks = append(ks, k)
+			}
+		case ord > 0 && ord <= count:
+			ks = []int{ord - 1}
+		case ord < 0 && -ord <= count:
+			ks = []int{count + ord}
+		}
+		for _, k := range ks {
+			if o := off + 7*k; !seen[o] {
+				seen[o] = true
+				offsets = append(offsets, o)
+			}
+		}
+	}
+	sort.Ints(offsets)
+
+	res := make([]time.Time, 0, len(offsets))
+	for _, o := range offsets {
+		res = append(res, time.Date(y, m, d+o, h, mi, s, first.Nanosecond(), first.Location()))
+	}
+	return res
+}
+
+// period возвращает начало периода с номером step и кандидатов на вхождение в нём по возрастанию
+func (r *Recurrence) period(start time.Time, step int) (time.Time, []time.Time) {
+	y, m, d := start.Date()
+	h, mi, s := start.Clock()
+	loc := start.Location()
+
+	switch r.Freq {
+	case freqDaily:
+		day := start.AddDate(0, 0, step)
+		if len(r.ByDay) == 0 {
+			return day, []time.Time{day}
+		}
+		for _, off := range r.byDayOffsets() {
+			if (int(day.Weekday())+6)%7 == off {
+				return day, []time.Time{day}
+			}
+		}
+		return day, nil
+	case freqWeekly:
+		if len(r.ByDay) == 0 {
+			day := start.AddDate(0, 0, 7*step)
+			return day, []time.Time{day}
+		}
+		monday := start.AddDate(0, 0, -((int(start.Weekday())+6)%7)+7*step)
+		var res []time.Time
+		for _, off := range r.byDayOffsets() {
+			res = append(res, monday.AddDate(0, 0, off))
+		}
+		return monday, res
+	case freqMonthly:
+		first := time.Date(y, m+time.Month(step), 1, h, mi, s, start.Nanosecond(), loc)
+		if len(r.ByDay) > 0 {
+			return first, r.byDayIn(first, time.Date(first.Year(), first.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day())
+		}
+		day := time.Date(y, m+time.Month(step), d, h, mi, s, start.Nanosecond(), loc)
+		// 31 число бывает не в каждом месяце, такие месяцы пропускаются
+		if day.Day() != d {
+			return first, nil
+		}
+		return first, []time.Time{day}
+	default:
+		first := time.Date(y+step, time.January, 1, h, mi, s, start.Nanosecond(), loc)
+		if len(r.ByDay) > 0 {
+			return first, r.byDayIn(first, time.Date(y+step, time.December, 31, 0, 0, 0, 0, time.UTC).YearDay())
+		}
+		day := time.Date(y+step, m, d, h, mi, s, start.Nanosecond(), loc)
+		if day.Month() != m {
+			return first, nil
+		}
+		return first, []time.Time{day}
+	}
+}
+
+// instances возвращает исходные даты вхождений в окне [from, to) с учётом COUNT/UNTIL, но без исключений
+func (ev *Event) instances(from, to time.Time) []time.Time {
+	r := ev.Recurrence
+	if r == nil {
+		if !ev.Date.Before(from) && ev.Date.Before(to) {
+			return []time.Time{ev.Date}
+		}
+		return nil
+	}
+
+	interval := r.Interval
+	if interval == 0 {
+		interval = 1
+	}
+
+	var res []time.Time
+	n := 0
+	for k := 0; ; k++ {
+		start, candidates := r.period(ev.Date, k*interval)
+		if !start.Before(to) || (r.Until != nil && start.After(*r.Until)) {
+			return res
+		}
+
+		for _, c := range candidates {
+			if c.Before(ev.Date) {
+				continue
+			}
+			if !c.Before(to) || (r.Until != nil && c.After(*r.Until)) {
+				return res
+			}
+			n++
+			if r.Count > 0 && n > r.Count {
+				return res
+			}
+			if !c.Before(from) {
+				res = append(res, c)
+			}
+		}
+	}
+}
+
+// hasOccurrence проверяет, что у серии есть не удалённое вхождение с исходной датой t
+func (ev *Event) hasOccurrence(t time.Time) bool {
+	if ev.Recurrence == nil || ev.Recurrence.isException(t) {
+		return false
+	}
+	return len(ev.instances(t, t.Add(time.Nanosecond))) > 0
+}
+
+// occurrence возвращает вхождение серии с исходной датой t с учётом изменений
+func (ev *Event) occurrence(t time.Time) Event {
+	if i := ev.Recurrence.override(t); i != -1 {
+		return ev.overridden(i)
+	}
+
+	occ := *ev
+	occ.Recurrence = nil
+	occ.Date = t
+	occ.End = ev.endFor(t)
+	occ.RecurrenceID = &t
+	return occ
+}
+
+// overridden возвращает изменённое вхождение с номером i с общими для всей серии полями:
+// версией, участниками и календарём
+func (ev *Event) overridden(i int) Event {
+	occ := ev.Recurrence.Overrides[i]
+	occ.Version = ev.Version
+	occ.Attendees = ev.Attendees
+	occ.CalendarID = ev.CalendarID
+	return occ
+}
+
+// occurrences разворачивает событие в список вхождений, пересекающихся с окном [from, to)
+func (ev *Event) occurrences(from, to time.Time) []Event {
+	if ev.Recurrence == nil {
+		if ev.overlaps(from, to) {
+			return []Event{*ev}
+		}
+		return nil
+	}
+
+	// вхождение, начавшееся раньше окна, может в него заходить
+	span := ev.end().Sub(ev.Date)
+
+	var res []Event
+	for _, t := range ev.instances(from.Add(-span), to) {
+		if ev.Recurrence.isException(t) || ev.Recurrence.override(t) != -1 {
+			continue
+		}
+		if occ := ev.occurrence(t); occ.overlaps(from, to) {
+			res = append(res, occ)
+		}
+	}
+
+	// изменённое вхождение могло быть перенесено в окно или из него
+	for i := range ev.Recurrence.Overrides {
+		if occ := ev.overridden(i); occ.overlaps(from, to) {
+			res = append(res, occ)
+		}
+	}
+
+	return res
+}
+
+// withOverride возвращает серию с изменённым вхождением occ
+func (ev Event) withOverride(occ Event) (Event, error) {
+	if ev.Recurrence == nil {
+		return ev, businessErrorf("event %v for %v user is not recurring", ev.EventID, ev.UserID)
+	}
+	if !ev.hasOccurrence(*occ.RecurrenceID) {
+		return ev, businessErrorf("event %v has no occurrence at %v", ev.EventID, occ.RecurrenceID.Format(time.RFC3339))
+	}
+
+	occ.Recurrence = nil
+	occ.Attendees = nil
+	occ.CalendarID = 0
+	ev.Recurrence = ev.Recurrence.clone()
+	if i := ev.Recurrence.override(*occ.RecurrenceID); i != -1 {
+		ev.Recurrence.Overrides[i] = occ
+	} else {
+		ev.Recurrence.Overrides = append(ev.Recurrence.Overrides, occ)
+	}
+
+	return ev, nil
+}
+
+// withException возвращает серию без вхождения с исходной датой t и само удалённое вхождение
+func (ev Event) withException(t time.Time) (Event, Event, error) {
+	if ev.Recurrence == nil {
+		return ev, Event{}, businessErrorf("event %v for %v user is not recurring", ev.EventID, ev.UserID)
+	}
+	if !ev.hasOccurrence(t) {
+		return ev, Event{}, businessErrorf("event %v has no occurrence at %v", ev.EventID, t.Format(time.RFC3339))
+	}
+
+	deleted := ev.occurrence(t)
+	ev.Recurrence = ev.Recurrence.clone()
+	if i := ev.Recurrence.override(t); i != -1 {
+		ev.Recurrence.Overrides = append(ev.Recurrence.Overrides[:i], ev.Recurrence.Overrides[i+1:]...)
+	}
+	ev.Recurrence.Exceptions = append(ev.Recurrence.Exceptions, t)
+
+	return ev, deleted, nil
+}
+
+// carryOver переносит в новую версию серии удалённые и изменённые вхождения старой, если запрос
+// не задал их явно. Вхождения, которых нет в новом правиле, отбрасываются
+func (ev *Event) carryOver(old *Event) {
+	if ev.Recurrence == nil || old.Recurrence == nil {
+		return
+	}
+	// clone не отличает пустой список от nil, поэтому смотрим на запрос до копирования
+	keepExceptions, keepOverrides := ev.Recurrence.Exceptions == nil, ev.Recurrence.Overrides == nil
+	r := ev.Recurrence.clone()
+	ev.Recurrence = r
+
+	exists := func(t time.Time) bool {
+		return len(ev.instances(t, t.Add(time.Nanosecond))) > 0
+	}
+	if keepExceptions {
+		for _, t := range old.Recurrence.Exceptions {
+			if exists(t) {
+				r.Exceptions = append(r.Exceptions, t)
+			}
+		}
+	}
+	if keepOverrides {
+		for _, o := range old.Recurrence.Overrides {
+			if o.RecurrenceID != nil && exists(*o.RecurrenceID) {
+				r.Overrides = append(r.Overrides, o)
+			}
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func dates(ts []time.Time) []string {
+	res := make([]string, len(ts))
+	for i, t := range ts {
+		res[i] = t.Format(dateFormat)
+	}
+	return res
+}
+
+func TestRecurrenceByDay(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Recurrence
+		want []string
+	}{
+		{
+			name: "monthly second monday",
+			rule: Recurrence{Freq: freqMonthly, ByDay: []string{"2MO"}, Count: 4},
+			want: []string{"2024-01-08", "2024-02-12", "2024-03-11", "2024-04-08"},
+		},
+		{
+			name: "monthly last friday",
+			rule: Recurrence{Freq: freqMonthly, ByDay: []string{"-1FR"}, Count: 3},
+			want: []string{"2024-01-26", "2024-02-23", "2024-03-29"},
+		},
+		{
+			name: "monthly fifth friday skips short months",
+			rule: Recurrence{Freq: freqMonthly, ByDay: []string{"5FR"}, Count: 3},
+			want: []string{"2024-03-29", "2024-05-31", "2024-08-30"},
+		},
+		{
+			name: "monthly first and third tuesday every other month",
+			rule: Recurrence{Freq: freqMonthly, Interval: 2, ByDay: []string{"3TU", "1TU"}, Count: 4},
+			want: []string{"2024-01-02", "2024-01-16", "2024-03-05", "2024-03-19"},
+		},
+		{
+			name: "monthly every wednesday",
+			rule: Recurrence{Freq: freqMonthly, ByDay: []string{"WE"}, Count: 6},
+			want: []string{"2024-01-03", "2024-01-10", "2024-01-17", "2024-01-24", "2024-01-31", "2024-02-07"},
+		},
+		{
+			name: "yearly last sunday of the year",
+			rule: Recurrence{Freq: freqYearly, ByDay: []string{"-1SU"}, Count: 2},
+			want: []string{"2024-12-29", "2025-12-28"},
+		},
+		{
+			name: "yearly tenth monday",
+			rule: Recurrence{Freq: freqYearly, ByDay: []string{"+10MO"}, Count: 2},
+			want: []string{"2024-03-04", "2025-03-10"},
+		},
+	}
+
+	start := time.Date(2024, 1, 1, 9, 30, 0, 0, time.UTC)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule := tt.rule
+			if err := rule.validate(); err != nil {
+				t.Fatal(err)
+			}
+			ev := Event{Date: start, Recurrence: &rule}
+			got := ev.instances(start, start.AddDate(3, 0, 0))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", dates(got), tt.want)
+			}
+			for i, d := range dates(got) {
+				if d != tt.want[i] {
+					t.Fatalf("got %v, want %v", dates(got), tt.want)
+				}
+				if h, m, _ := got[i].Clock(); h != 9 || m != 30 {
+					t.Errorf("occurrence %v lost the time of day", got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRecurrenceByDayValidate(t *testing.T) {
+	for _, r := range []Recurrence{
+		{Freq: freqWeekly, ByDay: []string{"2MO"}},
+		{Freq: freqDaily, ByDay: []string{"-1FR"}},
+		{Freq: freqMonthly, ByDay: []string{"6MO"}},
+		{Freq: freqMonthly, ByDay: []string{"0MO"}},
+		{Freq: freqYearly, ByDay: []string{"54SU"}},
+		{Freq: freqMonthly, ByDay: []string{"MON"}},
+	} {
+		if err := r.validate(); err == nil {
+			t.Errorf("%v %v: no error", r.Freq, r.ByDay)
+		}
+	}
+}
+
+func TestUpdateSeriesKeepsExceptionsAndOverrides(t *testing.T) {
+	s := newMemoryStorage(time.Now)
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	series := Event{UserID: 1, Title: "Стендап", Date: start, Recurrence: &Recurrence{Freq: freqDaily, Count: 5}}
+	if _, err := s.Create(&series, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted := start.AddDate(0, 0, 1)
+	if _, err := s.Delete(&Event{UserID: 1, EventID: series.EventID, RecurrenceID: &deleted}); err != nil {
+		t.Fatal(err)
+	}
+	moved := start.AddDate(0, 0, 2)
+	override := Event{UserID: 1, EventID: series.EventID, Title: "Перенесён", Date: moved.Add(3 * time.Hour), RecurrenceID: &moved}
+	if _, err := s.Update(&override, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+
+	rename := Event{UserID: 1, EventID: series.EventID, Title: "Утренний стендап", Date: start, Recurrence: &Recurrence{Freq: freqDaily, Count: 5}}
+	if _, err := s.Update(&rename, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := s.getEventsInRange(1, start, start.AddDate(0, 0, 7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 4 {
+		t.Fatalf("got %v occurrences, want 4: %+v", len(events), events)
+	}
+	for _, ev := range events {
+		switch {
+		case ev.Date.Equal(deleted):
+			t.Errorf("deleted occurrence came back")
+		case ev.RecurrenceID != nil && ev.RecurrenceID.Equal(moved):
+			if ev.Title != "Перенесён" || !ev.Date.Equal(moved.Add(3*time.Hour)) {
+				t.Errorf("edited occurrence lost: %+v", ev)
+			}
+		case ev.Title != "Утренний стендап":
+			t.Errorf("occurrence %v is not renamed: %q", ev.Date, ev.Title)
+		}
+	}
+
+	// явно переданные пустые списки сбрасывают изменения серии
+	reset := Event{UserID: 1, EventID: series.EventID, Title: "Стендап", Date: start,
+		Recurrence: &Recurrence{Freq: freqDaily, Count: 5, Exceptions: []time.Time{}, Overrides: []Event{}}}
+	if _, err := s.Update(&reset, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+	events, err = s.getEventsInRange(1, start, start.AddDate(0, 0, 7))
+	if err != nil || len(events) != 5 {
+		t.Fatalf("after reset: %v occurrences, %v", len(events), err)
+	}
+
+	// вхождения, которых нет в новом правиле, не переносятся
+	if _, err := s.Delete(&Event{UserID: 1, EventID: series.EventID, RecurrenceID: &deleted}); err != nil {
+		t.Fatal(err)
+	}
+	shifted := Event{UserID: 1, EventID: series.EventID, Title: "Стендап", Date: start.Add(time.Hour), Recurrence: &Recurrence{Freq: freqDaily, Count: 5}}
+	if _, err := s.Update(&shifted, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+	if ex := shifted.Recurrence.Exceptions; len(ex) != 0 {
+		t.Errorf("stale exceptions %v", ex)
+	}
+}
+
+func TestFileStorageReplaysSeriesReset(t *testing.T) {
+	path := t.TempDir() + "/events.log"
+	s, err := newFileStorage(path, time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	series := Event{UserID: 1, Title: "Стендап", Date: start, Recurrence: &Recurrence{Freq: freqDaily, Count: 3}}
+	if _, err := s.Create(&series, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+	deleted := start.AddDate(0, 0, 1)
+	if _, err := s.Delete(&Event{UserID: 1, EventID: series.EventID, RecurrenceID: &deleted}); err != nil {
+		t.Fatal(err)
+	}
+	reset := Event{UserID: 1, EventID: series.EventID, Title: "Стендап", Date: start,
+		Recurrence: &Recurrence{Freq: freqDaily, Count: 3, Exceptions: []time.Time{}}}
+	if _, err := s.Update(&reset, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = newFileStorage(path, time.Now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	events, err := s.getEventsInRange(1, start, start.AddDate(0, 0, 7))
+	if err != nil || len(events) != 3 {
+		t.Fatalf("after restart: %v occurrences, %v", len(events), err)
+	}
+}
+
+func TestOverriddenOccurrenceKeepsSeriesFields(t *testing.T) {
+	s := newMemoryStorage(time.Now)
+	c := Calendar{UserID: 1, Name: "Работа"}
+	if err := s.CreateCalendar(&c); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	series := Event{UserID: 1, Title: "Стендап", Date: start, CalendarID: c.CalendarID,
+		Attendees: []Attendee{{UserID: 2}}, Recurrence: &Recurrence{Freq: freqDaily, Count: 3}}
+	if _, err := s.Create(&series, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+	moved := start.AddDate(0, 0, 1)
+	override := Event{UserID: 1, EventID: series.EventID, Title: "Перенесён", Date: moved.Add(2 * time.Hour), RecurrenceID: &moved}
+	if _, err := s.Update(&override, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(name string, ev Event) {
+		t.Helper()
+		if ev.CalendarID != c.CalendarID || len(ev.Attendees) != 1 || ev.Attendees[0].UserID != 2 || ev.Version != override.Version {
+			t.Errorf("%v: %+v", name, ev)
+		}
+	}
+
+	// вхождение, перенесённое в окно, находит только цикл по изменённым вхождениям
+	events, err := s.getEventsInRange(1, moved.Add(90*time.Minute), moved.AddDate(0, 0, 1))
+	if err != nil || len(events) != 1 {
+		t.Fatalf("range: %+v, %v", events, err)
+	}
+	check("occurrences", events[0])
+
+	series, err = s.getEvent(1, series.EventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("occurrence", series.occurrence(moved))
+
+	// приглашённый видит изменённое вхождение с календарём организатора
+	events, err = s.getEventsInRange(2, moved, moved.AddDate(0, 0, 1))
+	if err != nil || len(events) != 1 {
+		t.Fatalf("attendee range: %+v, %v", events, err)
+	}
+	check("attendee", events[0])
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Ограничения напоминаний
+const (
+	// maxReminderOffset - насколько раньше начала события можно напомнить, в минутах (4 недели)
+	maxReminderOffset = 4 * 7 * 24 * 60
+	// reminderGrace - сколько опоздавшее напоминание (сервер лежал, notifier ошибся) ещё имеет смысл отправлять
+	reminderGrace = 15 * time.Minute
+	// reminderInterval - как часто планировщик ищет наступившие напоминания
+	reminderInterval = 30 * time.Second
+)
+
+// validateReminders проверяет смещения напоминаний события
+func (ev *Event) validateReminders() error {
+	var errs ValidationError
+	for _, offset := range ev.Reminders {
+		if offset < 0 || offset > maxReminderOffset {
+			errs.add("reminders", fmt.Sprintf("offsets must be between 0 and %v minutes", maxReminderOffset))
+		}
+	}
+	return errs.err()
+}
+
+// Reminder - напоминание о конкретном вхождении события.
+// UserID - получатель: организатор или участник, принявший приглашение; OrganizerID и EventID - событие
+type Reminder struct {
+	UserID      int       `json:"user_id"`
+	OrganizerID int       `json:"organizer_id"`
+	EventID     int       `json:"event_id"`
+	Title       string    `json:"title"`
+	Start       time.Time `json:"start"`
+	// Offset - за сколько минут до начала напоминание
+	Offset int       `json:"offset"`
+	FireAt time.Time `json:"fire_at"`
+}
+
+// reminderKey однозначно определяет напоминание: событие, вхождение (исходная дата), получатель и смещение
+type reminderKey struct {
+	UserID      int       `json:"user_id"`
+	OrganizerID int       `json:"organizer_id"`
+	EventID     int       `json:"event_id"`
+	Start       time.Time `json:"start"`
+	Offset      int       `json:"offset"`
+}
+
+func (r *Reminder) key() reminderKey {
+	return reminderKey{UserID: r.UserID, OrganizerID: r.OrganizerID, EventID: r.EventID, Start: r.Start.UTC(), Offset: r.Offset}
+}
+
+// recipients возвращает получателей напоминаний: организатора и принявших приглашение
+func (ev *Event) recipients() []int {
+	res := []int{ev.UserID}
+	for _, a := range ev.Attendees {
+		if a.Status == rsvpAccepted {
+			res = append(res, a.UserID)
+		}
+	}
+	return res
+}
+
+// reminders возвращает напоминания вхождения, по одному на смещение и получателя
+func (ev *Event) reminders() []Reminder {
+	start := ev.Date
+	if ev.RecurrenceID != nil {
+		start = *ev.RecurrenceID
+	}
+
+	recipients := ev.recipients()
+	res := make([]Reminder, 0, len(ev.Reminders)*len(recipients))
+	for _, userID := range recipients {
+		for _, offset := range ev.Reminders {
+			res = append(res, Reminder{
+				UserID:      userID,
+				OrganizerID: ev.UserID,
+				EventID:     ev.EventID,
+				Title:       ev.Title,
+				Start:       start,
+				Offset:      offset,
+				FireAt:      ev.Date.Add(-time.Duration(offset) * time.Minute),
+			})
+		}
+	}
+	return res
+}
+
+// Notifier доставляет напоминание пользователю
+type Notifier interface {
+	Notify(ctx context.Context, r Reminder) error
+}
+
+// logNotifier пишет напоминания в лог
+type logNotifier struct{}
+
+func (logNotifier) Notify(_ context.Context, r Reminder) error {
+	log.Printf("reminder: user %v, event %v of user %v %q starts at %v", r.UserID, r.EventID, r.OrganizerID, r.Title, r.Start.Format(time.RFC3339))
+	return nil
+}
+
+// webhookNotifier отправляет напоминание POST запросом с JSON телом на заданный URL
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func newWebhookNotifier(url string) *webhookNotifier {
+	return &webhookNotifier{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, r Reminder) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook responded with %v", resp.Status)
+	}
+	return nil
+}
+
+// chanNotifier отдаёт напоминания в канал, нужен для встраивания и тестов
+type chanNotifier chan Reminder
+
+func (n chanNotifier) Notify(ctx context.Context, r Reminder) error {
+	select {
+	case n <- r:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newNotifier выбирает notifier по конфигу: log (по умолчанию) или webhook
+func newNotifier(kind, url string) (Notifier, error) {
+	switch kind {
+	case "", "log":
+		return logNotifier{}, nil
+	case "webhook":
+		if url == "" {
+			return nil, fmt.Errorf("webhook notifier requires url")
+		}
+		return newWebhookNotifier(url), nil
+	default:
+		return nil, fmt.Errorf("unknown notifier %q", kind)
+	}
+}
+
+// firedLogPath - файл отправленных напоминаний лежит рядом с файлом данных, у хранилища в памяти его нет
+func firedLogPath(kind, path string) string {
+	if kind != "file" {
+		return ""
+	}
+	if path == "" {
+		path = defaultStoragePath
+	}
+	return path + ".reminders"
+}
+
+// firedLog - множество уже отправленных напоминаний. С непустым path оно дописывается
+// в файл построчно, чтобы после перезапуска напоминания не отправлялись повторно.
+type firedLog struct {
+	mu    sync.Mutex
+	fired map[reminderKey]time.Time
+	path  string
+	f     *os.File
+}
+
+// firedRecord - строка файла отправленных напоминаний
+type firedRecord struct {
+	Key    reminderKey `json:"key"`
+	FireAt time.Time   `json:"fire_at"`
+}
+
+// newFiredLog читает отправленные напоминания и переписывает файл без устаревших записей
+func newFiredLog(path string, now time.Time) (*firedLog, error) {
+	l := &firedLog{fired: make(map[reminderKey]time.Time), path: path}
+	if path == "" {
+		return l, nil
+	}
+
+	if f, err := os.Open(path); err == nil {
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			var rec firedRecord
+			// недописанная при падении строка пропускается
+			if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+				continue
+			}
+			// записи без организатора остались от версии, где напоминал только он сам
+			if rec.Key.OrganizerID == 0 {
+				rec.Key.OrganizerID = rec.Key.UserID
+			}
+			l.fired[rec.Key] = rec.FireAt
+		}
+		f.Close()
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	l.prune(now)
+	if err := l.rewrite(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	l.f = f
+
+	return l, nil
+}
+
+// prune забывает напоминания, которые уже никогда не попадут в окно планировщика
+func (l *firedLog) prune(now time.Time) {
+	for k, at := range l.fired {
+		if at.Before(now.Add(-reminderGrace)) {
+			delete(l.fired, k)
+		}
+	}
+}
+
+// rewrite записывает текущее множество через временный файл и rename
+func (l *firedLog) rewrite() error {
+	var buf bytes.Buffer
+	for k, at := range l.fired {
+		data, err := json.Marshal(firedRecord{Key: k, FireAt: at})
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		buf.WriteByte('\n')
+	}
+
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
+
+func (l *firedLog) has(k reminderKey) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.fired[k]
+	return ok
+}
+
+// mark запоминает отправленное напоминание и сбрасывает запись на диск
+func (l *firedLog) mark(r *Reminder) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.fired[r.key()] = r.FireAt
+	if l.f == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(firedRecord{Key: r.key(), FireAt: r.FireAt})
+	if err != nil {
+		return err
+	}
+	if _, err := l.f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	return l.f.Sync()
+}
+
+func (l *firedLog) Close() error {
+	if l.f == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+// Scheduler раз в interval ищет напоминания, наступившие за последние reminderGrace,
+// и отправляет ещё не отправленные. Неудачная отправка повторяется на следующих тиках.
+type Scheduler struct {
+	storage  Storage
+	notifier Notifier
+	fired    *firedLog
+	interval time.Duration
+	now      func() time.Time
+}
+
+func newScheduler(s Storage, n Notifier, fired *firedLog, now func() time.Time) *Scheduler {
+	return &Scheduler{storage: s, notifier: n, fired: fired, interval: reminderInterval, now: now}
+}
+
+// Run работает до отмены ctx
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		s.tick(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// due возвращает напоминания, время которых наступило в окне (now - reminderGrace, now]
+func (s *Scheduler) due(now time.Time) []Reminder {
+	from := now.Add(-reminderGrace)
+	// событие, о котором пора напомнить, начинается не позже чем через maxReminderOffset
+	to := now.Add(maxReminderOffset*time.Minute + time.Nanosecond)
+
+	// события берутся только у организаторов: приглашения попали бы в выборку каждого участника
+	var res []Reminder
+	seen := make(map[reminderKey]bool)
+	for _, userID := range s.storage.getUserIDs() {
+		events, err := s.storage.getOwnEventsInRange(userID, from, to)
+		if err != nil {
+			continue
+		}
+		for i := range events {
+			for _, r := range events[i].reminders() {
+				k := r.key()
+				if r.FireAt.After(from) && !r.FireAt.After(now) && !seen[k] && !s.fired.has(k) {
+					seen[k] = true
+					res = append(res, r)
+				}
+			}
+		}
+	}
+	return res
+}
+
+func (s *Scheduler) tick(ctx context.Context) {
+	now := s.now()
+	for _, r := range s.due(now) {
+		if err := s.notifier.Notify(ctx, r); err != nil {
+			log.Printf("reminder for event %v of user %v to user %v failed: %v", r.EventID, r.OrganizerID, r.UserID, err)
+			continue
+		}
+		if err := s.fired.mark(&r); err != nil {
+			log.Printf("can't persist fired reminder: %v", err)
+		}
+	}
+
+	s.fired.mu.Lock()
+	s.fired.prune(now)
+	s.fired.mu.Unlock()
+}
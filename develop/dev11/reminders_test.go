@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sort"
+	"testing"
+	"time"
+)
+
+// drain забирает всё, что notifier успел получить
+func drain(n chanNotifier) []Reminder {
+	var res []Reminder
+	for {
+		select {
+		case r := <-n:
+			res = append(res, r)
+		default:
+			return res
+		}
+	}
+}
+
+func TestSchedulerRemindsEachRecipientOnce(t *testing.T) {
+	clock := newTestClock()
+	s := newMemoryStorage(clock.now)
+	start := clock.now().Add(time.Hour)
+
+	ev := Event{UserID: 1, Title: "Созвон", Date: start, Reminders: []int{10},
+		Attendees: []Attendee{{UserID: 2}, {UserID: 3}, {UserID: 4}}}
+	if _, err := s.Create(&ev, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+	// у участников есть и свои события: они попадают в getUserIDs
+	for _, userID := range []int{2, 3} {
+		own := Event{UserID: userID, Title: "Своё", Date: start.Add(24 * time.Hour)}
+		if _, err := s.Create(&own, conflictWarn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Respond(1, ev.EventID, 2, rsvpAccepted); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Respond(1, ev.EventID, 3, rsvpDeclined); err != nil {
+		t.Fatal(err)
+	}
+
+	notifier := make(chanNotifier, 16)
+	fired, _ := newFiredLog("", clock.now())
+	sched := newScheduler(s, notifier, fired, clock.now)
+
+	sched.tick(context.Background())
+	if got := drain(notifier); len(got) != 0 {
+		t.Fatalf("reminders before time: %+v", got)
+	}
+
+	clock.advance(50 * time.Minute)
+	sched.tick(context.Background())
+	got := drain(notifier)
+	var users []int
+	for _, r := range got {
+		if r.OrganizerID != 1 || r.EventID != ev.EventID || !r.Start.Equal(start) {
+			t.Errorf("reminder %+v", r)
+		}
+		users = append(users, r.UserID)
+	}
+	sort.Ints(users)
+	if len(users) != 2 || users[0] != 1 || users[1] != 2 {
+		t.Fatalf("recipients %v, want organizer 1 and accepted attendee 2", users)
+	}
+
+	clock.advance(time.Minute)
+	sched.tick(context.Background())
+	if got := drain(notifier); len(got) != 0 {
+		t.Fatalf("repeated reminders: %+v", got)
+	}
+}
+
+func TestFiredLogReadsRecordsWithoutOrganizer(t *testing.T) {
+	path := t.TempDir() + "/events.log.reminders"
+	now := time.Date(2024, 1, 10, 9, 55, 0, 0, time.UTC)
+	line := `{"key":{"user_id":1,"event_id":7,"start":"2024-01-10T10:00:00Z","offset":10},"fire_at":"2024-01-10T09:50:00Z"}` + "\n"
+	if err := os.WriteFile(path, []byte(line), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := newFiredLog(path, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	r := Reminder{UserID: 1, OrganizerID: 1, EventID: 7, Start: time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC), Offset: 10}
+	if !l.has(r.key()) {
+		t.Error("reminder fired before the upgrade is sent again")
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+// applyPath переносит user_id и event_id из пути /users/{id}/events/{eventID} в событие.
+// Если они заданы и в теле, значения должны совпадать
+func (ev *Event) applyPath(r *http.Request) error {
+	var errs ValidationError
+	bind := func(param, field string, dst *int) {
+		v := pathParam(r, param)
+		if v == "" {
+			return
+		}
+		id, err := strconv.Atoi(v)
+		if err != nil || id <= 0 {
+			errs.add(field, "must be a positive integer in path")
+			return
+		}
+		if *dst != 0 && *dst != id {
+			errs.add(field, "doesn't match path")
+			return
+		}
+		*dst = id
+	}
+
+	bind("id", "user_id", &ev.UserID)
+	bind("eventID", "event_id", &ev.EventID)
+
+	return errs.err()
+}
+
+// eventFromPath - ссылка на событие из пути с проверкой доступа
+func eventFromPath(r *http.Request) (Event, error) {
+	var ev Event
+	if err := ev.applyPath(r); err != nil {
+		return ev, err
+	}
+	return ev, authorize(r, &ev.UserID)
+}
+
+// GetEventHandler GET /users/{id}/events/{eventID} handler
+func (srv *Server) GetEventHandler(w http.ResponseWriter, r *http.Request) {
+	ref, err := eventFromPath(r)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	ev, err := srv.storage.getEvent(ref.UserID, ref.EventID)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(ev.Version))
+	getResponse(w, "Запрос успешно выполнен!", []Event{ev}, http.StatusOK)
+}
+
+// PatchEventHandler PATCH /users/{id}/events/{eventID} handler: JSON merge patch поверх текущего события,
+// отсутствующие в теле поля не меняются
+func (srv *Server) PatchEventHandler(w http.ResponseWriter, r *http.Request) {
+	ref, err := eventFromPath(r)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	switch mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType {
+	case "application/json", "application/merge-patch+json":
+	default:
+		getErrorResponse(w, &FieldError{Field: "content_type", Reason: "must be application/json or application/merge-patch+json"})
+		return
+	}
+
+	current, err := srv.storage.getEvent(ref.UserID, ref.EventID)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	// копия через JSON, чтобы патч не менял срезы события в хранилище
+	data, err := json.Marshal(current)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+	var ev Event
+	if err := json.Unmarshal(data, &ev); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	var errs ValidationError
+	if err := ev.decode(r.Body); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+	errs.merge(ev.applyPath(r))
+	errs.merge(ev.validateUpdate())
+	if err := errs.err(); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	srv.updateEvent(w, r, &ev)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// route - шаблон пути с параметрами вида {name} и обработчики по методам
+type route struct {
+	pattern  string
+	segments []string
+	handlers map[string]http.Handler
+}
+
+// match сравнивает путь с шаблоном и возвращает значения параметров
+func (rt *route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+
+	var params map[string]string
+	for i, s := range rt.segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[s[1:len(s)-1]] = segments[i]
+			continue
+		}
+		if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// allow - значение заголовка Allow; HEAD есть везде, где есть GET, его обслуживает обработчик GET
+func (rt *route) allow() string {
+	methods := make([]string, 0, len(rt.handlers)+1)
+	for m := range rt.handlers {
+		methods = append(methods, m)
+	}
+	_, head := rt.handlers[http.MethodHead]
+	if _, get := rt.handlers[http.MethodGet]; get && !head {
+		methods = append(methods, http.MethodHead)
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
+// Router - маршрутизатор с параметрами пути и проверкой метода.
+// На неподходящий метод отвечает 405 со списком допустимых в Allow, HEAD обслуживается обработчиком GET
+type Router struct {
+	routes []*route
+}
+
+func newRouter() *Router {
+	return &Router{}
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// Handle регистрирует обработчик метода для шаблона пути
+func (rt *Router) Handle(method, pattern string, handler http.Handler) {
+	for _, r := range rt.routes {
+		if r.pattern == pattern {
+			r.handlers[method] = handler
+			return
+		}
+	}
+	rt.routes = append(rt.routes, &route{
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		handlers: map[string]http.Handler{method: handler},
+	})
+}
+
+// HandleFunc регистрирует функцию-обработчик метода для шаблона пути
+func (rt *Router) HandleFunc(method, pattern string, handler func(http.ResponseWriter, *http.Request)) {
+	rt.Handle(method, pattern, http.HandlerFunc(handler))
+}
+
+// find ищет маршрут для пути
+func (rt *Router) find(path string) (*route, map[string]string) {
+	segments := splitPath(path)
+	for _, r := range rt.routes {
+		if params, ok := r.match(segments); ok {
+			return r, params
+		}
+	}
+	return nil, nil
+}
+
+// Handler возвращает обработчик и шаблон маршрута запроса, как http.ServeMux
+func (rt *Router) Handler(r *http.Request) (http.Handler, string) {
+	found, _ := rt.find(r.URL.Path)
+	if found == nil {
+		return nil, ""
+	}
+	return rt, found.pattern
+}
+
+type pathParamsKey struct{}
+
+// pathParam возвращает параметр пути запроса, пустую строку - если его нет
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(pathParamsKey{}).(map[string]string)
+	return params[name]
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	found, params := rt.find(r.URL.Path)
+	if found == nil {
+		getErrResponse(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	handler, ok := found.handlers[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		handler, ok = found.handlers[http.MethodGet]
+	}
+	if !ok {
+		w.Header().Set("Allow", found.allow())
+		getErrResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if params != nil {
+		r = r.WithContext(context.WithValue(r.Context(), pathParamsKey{}, params))
+	}
+	handler.ServeHTTP(w, r)
+}
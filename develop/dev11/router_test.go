@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	named := func(name string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Handler", name)
+			w.Header().Set("X-ID", pathParam(r, "id"))
+		}
+	}
+	rt := newRouter()
+	rt.Handle(http.MethodGet, "/items", named("list"))
+	rt.Handle(http.MethodPost, "/items", named("create"))
+	rt.Handle(http.MethodGet, "/items/{id}", named("get"))
+	rt.Handle(http.MethodPut, "/items/{id}", named("put"))
+	rt.Handle(http.MethodGet, "/items/{id}/tags", named("tags"))
+	rt.Handle(http.MethodDelete, "/trash/{id}", named("purge"))
+	rt.Handle(http.MethodGet, "/status", named("status"))
+	rt.Handle(http.MethodHead, "/status", named("status head"))
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		status  int
+		handler string
+		id      string
+		allow   string
+	}{
+		{"get", http.MethodGet, "/items", http.StatusOK, "list", "", ""},
+		{"head through get", http.MethodHead, "/items", http.StatusOK, "list", "", ""},
+		{"head with a parameter", http.MethodHead, "/items/7", http.StatusOK, "get", "7", ""},
+		{"own head handler", http.MethodHead, "/status", http.StatusOK, "status head", "", ""},
+		{"trailing slash", http.MethodPost, "/items/", http.StatusOK, "create", "", ""},
+		{"wrong method", http.MethodPatch, "/items", http.StatusMethodNotAllowed, "", "", "GET, HEAD, POST"},
+		{"wrong method with a parameter", http.MethodPost, "/items/7", http.StatusMethodNotAllowed, "", "", "GET, HEAD, PUT"},
+		{"head without get", http.MethodHead, "/trash/7", http.StatusMethodNotAllowed, "", "", "DELETE"},
+		{"head listed once", http.MethodPost, "/status", http.StatusMethodNotAllowed, "", "", "GET, HEAD"},
+		{"unknown path", http.MethodGet, "/nothing", http.StatusNotFound, "", "", ""},
+		{"nested", http.MethodGet, "/items/7/tags", http.StatusOK, "tags", "7", ""},
+		{"extra segment", http.MethodGet, "/items/7/more", http.StatusNotFound, "", "", ""},
+		{"empty parameter", http.MethodGet, "/items//tags", http.StatusNotFound, "", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rt.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, nil))
+			if w.Code != tt.status || w.Header().Get("X-Handler") != tt.handler || w.Header().Get("X-ID") != tt.id {
+				t.Errorf("%v, handler %q, id %q", w.Code, w.Header().Get("X-Handler"), w.Header().Get("X-ID"))
+			}
+			if got := w.Header().Get("Allow"); got != tt.allow {
+				t.Errorf("Allow %q, want %q", got, tt.allow)
+			}
+		})
+	}
+}
+
+func TestRouterHandlerPattern(t *testing.T) {
+	rt := newRouter()
+	rt.Handle(http.MethodGet, "/users/{id}/events", http.NotFoundHandler())
+
+	if _, pattern := rt.Handler(httptest.NewRequest(http.MethodGet, "/users/3/events", nil)); pattern != "/users/{id}/events" {
+		t.Errorf("pattern %q", pattern)
+	}
+	if h, pattern := rt.Handler(httptest.NewRequest(http.MethodGet, "/users/3", nil)); h != nil || pattern != "" {
+		t.Errorf("unknown path: %v, %q", h, pattern)
+	}
+}
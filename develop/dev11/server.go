@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Server - маршруты и хэндлеры API календаря. Хранилище и часы передаются снаружи,
+// поэтому сервер можно поднять поверх любого Storage, например в httptest.
+// Аутентификация, лимиты, метрики и логирование навешиваются поверх в main
+type Server struct {
+	*Router
+	storage Storage
+	// now - текущее время, в тестах подменяется
+	now func() time.Time
+	// availability - рабочее время и праздники для /suggest_slot
+	availability *Availability
+}
+
+// newServer создаёт сервер над хранилищем s, now - источник текущего времени (time.Now).
+// Рабочее время у всех по умолчанию, праздников нет - до замены availability
+func newServer(s Storage, now func() time.Time) *Server {
+	srv := &Server{Router: newRouter(), storage: s, now: now, availability: defaultAvailability()}
+	srv.routes()
+	return srv
+}
+
+// routes регистрирует пути API
+func (srv *Server) routes() {
+	// Пропишем пути для GET
+	srv.HandleFunc(http.MethodGet, "/events_for_day", srv.ForDayHandler)
+	srv.HandleFunc(http.MethodGet, "/events_for_week", srv.ForWeekHandler)
+	srv.HandleFunc(http.MethodGet, "/events_for_month", srv.ForMonthHandler)
+	srv.HandleFunc(http.MethodGet, "/export.ics", srv.ExportHandler)
+	srv.HandleFunc(http.MethodGet, "/free_busy", srv.FreeBusyHandler)
+	srv.HandleFunc(http.MethodGet, "/events", srv.EventsHandler)
+	srv.HandleFunc(http.MethodGet, "/trash", srv.TrashHandler)
+	srv.HandleFunc(http.MethodGet, "/event_history", srv.EventHistoryHandler)
+	srv.HandleFunc(http.MethodGet, "/calendars", srv.CalendarsHandler)
+
+	// Пропишем пути для POST
+	srv.HandleFunc(http.MethodPost, "/create_event", srv.CreateEventHandler)
+	srv.HandleFunc(http.MethodPost, "/update_event", srv.UpdateEventHandler)
+	srv.HandleFunc(http.MethodPost, "/delete_event", srv.DeleteEventHandler)
+	srv.HandleFunc(http.MethodPost, "/restore_event", srv.RestoreEventHandler)
+	srv.HandleFunc(http.MethodPost, "/rsvp_event", srv.RSVPEventHandler)
+	srv.HandleFunc(http.MethodPost, "/import", srv.ImportHandler)
+	srv.HandleFunc(http.MethodPost, "/events/batch", srv.BatchHandler)
+	srv.HandleFunc(http.MethodPost, "/create_calendar", srv.CreateCalendarHandler)
+	srv.HandleFunc(http.MethodPost, "/update_calendar", srv.UpdateCalendarHandler)
+	srv.HandleFunc(http.MethodPost, "/delete_calendar", srv.DeleteCalendarHandler)
+	srv.HandleFunc(http.MethodPost, "/suggest_slot", srv.SuggestSlotHandler)
+
+	// REST ресурсы, старые пути выше остаются для совместимости
+	srv.HandleFunc(http.MethodGet, "/users/{id}/events", srv.EventsHandler)
+	srv.HandleFunc(http.MethodPost, "/users/{id}/events", srv.CreateEventHandler)
+	srv.HandleFunc(http.MethodGet, "/users/{id}/events/{eventID}", srv.GetEventHandler)
+	srv.HandleFunc(http.MethodPost, "/users/{id}/events/{eventID}", srv.CreateEventHandler)
+	srv.HandleFunc(http.MethodPut, "/users/{id}/events/{eventID}", srv.UpdateEventHandler)
+	srv.HandleFunc(http.MethodPatch, "/users/{id}/events/{eventID}", srv.PatchEventHandler)
+	srv.HandleFunc(http.MethodDelete, "/users/{id}/events/{eventID}", srv.DeleteEventHandler)
+
+	srv.HandleFunc(http.MethodGet, "/openapi.json", OpenAPIHandler)
+}
@@ -0,0 +1,566 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testClock - часы, которые двигает тест
+type testClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{t: time.Date(2024, 1, 1, 8, 0, 0, 0, time.UTC)}
+}
+
+func (c *testClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+// apiResponse - объединение полей всех ответов API
+type apiResponse struct {
+	Result     string          `json:"result"`
+	Error      string          `json:"error"`
+	Events     []Event         `json:"events"`
+	Warnings   []Event         `json:"warnings"`
+	Fields     []FieldError    `json:"fields"`
+	Conflicts  []Event         `json:"conflicts"`
+	NextCursor string          `json:"next_cursor"`
+	Trash      []TrashedEvent  `json:"trash"`
+	History    []Revision      `json:"history"`
+	Calendars  []Calendar      `json:"calendars"`
+	Busy       []Interval      `json:"busy"`
+	Free       []Interval      `json:"free"`
+	Slots      []Interval      `json:"slots"`
+	Results    []batchOpResult `json:"results"`
+	Entries    []ImportEntry   `json:"entries"`
+}
+
+// testServer - сервер API над хранилищем в памяти с тестовыми часами
+type testServer struct {
+	*httptest.Server
+	srv   *Server
+	clock *testClock
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	clock := newTestClock()
+	return newTestServerWith(t, newMemoryStorage(clock.now), clock)
+}
+
+func newTestServerWith(t *testing.T, s Storage, clock *testClock) *testServer {
+	t.Helper()
+	srv := newServer(s, clock.now)
+	ts := &testServer{Server: httptest.NewServer(srv), srv: srv, clock: clock}
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// do выполняет запрос; JSON ответ разбирается в apiResponse, остальные отдаются телом
+func (ts *testServer) do(t *testing.T, method, path, contentType, body string, header ...string) (*http.Response, apiResponse, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var res apiResponse
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && !strings.HasSuffix(path, "openapi.json") {
+		if err := json.Unmarshal(data, &res); err != nil {
+			t.Fatalf("%v %v: %v: %s", method, path, err, data)
+		}
+	}
+	return resp, res, string(data)
+}
+
+func (ts *testServer) form(t *testing.T, path string, values url.Values, header ...string) (*http.Response, apiResponse) {
+	t.Helper()
+	resp, res, _ := ts.do(t, http.MethodPost, path, "application/x-www-form-urlencoded", values.Encode(), header...)
+	return resp, res
+}
+
+func (ts *testServer) get(t *testing.T, path string) (*http.Response, apiResponse) {
+	t.Helper()
+	resp, res, _ := ts.do(t, http.MethodGet, path, "", "")
+	return resp, res
+}
+
+// create создаёт событие через /create_event и возвращает его
+func (ts *testServer) create(t *testing.T, values url.Values) Event {
+	t.Helper()
+	resp, res := ts.form(t, "/create_event", values)
+	if resp.StatusCode != http.StatusCreated || len(res.Events) != 1 {
+		t.Fatalf("create %v: status %v, %+v", values.Encode(), resp.StatusCode, res)
+	}
+	return res.Events[0]
+}
+
+func wantStatus(t *testing.T, resp *http.Response, res apiResponse, status int) {
+	t.Helper()
+	if resp.StatusCode != status {
+		t.Fatalf("%v %v: status %v, want %v: %+v", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, status, res)
+	}
+}
+
+func TestServerEventLifecycle(t *testing.T) {
+	ts := newTestServer(t)
+
+	ev := ts.create(t, url.Values{"user_id": {"1"}, "title": {"Встреча"}, "date": {"2024-01-10T10:00:00Z"}, "end": {"2024-01-10T11:00:00Z"}})
+	if ev.EventID <= 0 || ev.Version != 1 {
+		t.Fatalf("created %+v", ev)
+	}
+
+	for _, path := range []string{
+		"/events_for_day?user_id=1&date=2024-01-10",
+		"/events_for_week?user_id=1&date=2024-01-08",
+		"/events_for_month?user_id=1&date=2024-01-31",
+		"/events?user_id=1&from=2024-01-01&to=2024-02-01",
+		"/users/1/events?from=2024-01-01&to=2024-02-01",
+	} {
+		resp, res := ts.get(t, path)
+		wantStatus(t, resp, res, http.StatusOK)
+		if len(res.Events) != 1 || res.Events[0].Title != "Встреча" {
+			t.Errorf("%v: %+v", path, res.Events)
+		}
+	}
+
+	resp, res := ts.get(t, "/events_for_day?user_id=1&date=2024-01-11")
+	wantStatus(t, resp, res, http.StatusOK)
+	if len(res.Events) != 0 {
+		t.Errorf("next day: %+v", res.Events)
+	}
+
+	path := fmt.Sprintf("/users/1/events/%v", ev.EventID)
+	resp, res = ts.get(t, path)
+	wantStatus(t, resp, res, http.StatusOK)
+	if resp.Header.Get("ETag") != etag(1) {
+		t.Errorf("ETag %q", resp.Header.Get("ETag"))
+	}
+
+	update := url.Values{"user_id": {"1"}, "event_id": {fmt.Sprint(ev.EventID)}, "title": {"Планёрка"}, "date": {"2024-01-10T10:00:00Z"}}
+	resp, res = ts.form(t, "/update_event", update, "If-Match", etag(5))
+	wantStatus(t, resp, res, http.StatusPreconditionFailed)
+
+	ts.clock.advance(time.Hour)
+	resp, res = ts.form(t, "/update_event", update, "If-Match", etag(1))
+	wantStatus(t, resp, res, http.StatusOK)
+	if res.Events[0].Version != 2 || res.Events[0].Title != "Планёрка" {
+		t.Fatalf("updated %+v", res.Events[0])
+	}
+
+	resp, res, _ = ts.do(t, http.MethodPatch, path, "application/merge-patch+json", `{"description":"еженедельная"}`)
+	wantStatus(t, resp, res, http.StatusOK)
+	if got := res.Events[0]; got.Title != "Планёрка" || got.Description != "еженедельная" || got.Version != 3 {
+		t.Fatalf("patched %+v", got)
+	}
+
+	resp, res, _ = ts.do(t, http.MethodPut, path, "application/json", `{"title":"Ретро","date":"2024-01-10T15:00:00Z","end":"2024-01-10T16:00:00Z"}`)
+	wantStatus(t, resp, res, http.StatusOK)
+	if got := res.Events[0]; got.Title != "Ретро" || got.Version != 4 {
+		t.Fatalf("put %+v", got)
+	}
+
+	resp, res = ts.get(t, "/free_busy?user_id=1&from=2024-01-10&to=2024-01-11")
+	wantStatus(t, resp, res, http.StatusOK)
+	if len(res.Busy) != 1 || len(res.Free) != 2 {
+		t.Errorf("free_busy: busy %v, free %v", res.Busy, res.Free)
+	}
+
+	resp, _, body := ts.do(t, http.MethodGet, "/export.ics?user_id=1", "", "")
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "SUMMARY:Ретро") {
+		t.Errorf("export: %v\n%v", resp.StatusCode, body)
+	}
+
+	resp, res, _ = ts.do(t, http.MethodDelete, path, "", "")
+	wantStatus(t, resp, res, http.StatusOK)
+
+	resp, res = ts.get(t, path)
+	wantStatus(t, resp, res, http.StatusServiceUnavailable)
+
+	resp, res = ts.get(t, "/trash?user_id=1")
+	wantStatus(t, resp, res, http.StatusOK)
+	if len(res.Trash) != 1 || !res.Trash[0].DeletedAt.Equal(ts.clock.now()) {
+		t.Fatalf("trash %+v", res.Trash)
+	}
+
+	resp, res = ts.form(t, "/restore_event", url.Values{"user_id": {"1"}, "event_id": {fmt.Sprint(ev.EventID)}})
+	wantStatus(t, resp, res, http.StatusOK)
+	if res.Events[0].Version != 5 {
+		t.Errorf("restored %+v", res.Events[0])
+	}
+
+	resp, res = ts.get(t, fmt.Sprintf("/event_history?user_id=1&event_id=%v", ev.EventID))
+	wantStatus(t, resp, res, http.StatusOK)
+	if len(res.History) != 6 {
+		t.Fatalf("history %+v", res.History)
+	}
+	if created, updated := res.History[0].Time, res.History[1].Time; !updated.Equal(created.Add(time.Hour)) {
+		t.Errorf("history times %v, %v: storage must use the server clock", created, updated)
+	}
+
+	resp, res = ts.form(t, "/delete_event", url.Values{"user_id": {"1"}, "event_id": {fmt.Sprint(ev.EventID)}})
+	wantStatus(t, resp, res, http.StatusOK)
+}
+
+func TestServerCreateVariants(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, res, _ := ts.do(t, http.MethodPost, "/users/2/events", "application/json", `{"title":"JSON","date":"2024-01-10","all_day":true,"time_zone":"Europe/Moscow"}`)
+	wantStatus(t, resp, res, http.StatusCreated)
+	ev := res.Events[0]
+	if ev.UserID != 2 || !ev.AllDay || !ev.Date.Equal(time.Date(2024, 1, 9, 21, 0, 0, 0, time.UTC)) {
+		t.Errorf("all day event %+v", ev)
+	}
+
+	resp, res, _ = ts.do(t, http.MethodPost, "/users/2/events/77", "application/json", `{"title":"С номером","date":"2024-01-11T10:00:00Z"}`)
+	wantStatus(t, resp, res, http.StatusCreated)
+	if res.Events[0].EventID != 77 {
+		t.Errorf("event_id %v", res.Events[0].EventID)
+	}
+
+	series := ts.create(t, url.Values{"user_id": {"2"}, "title": {"Стендап"}, "date": {"2024-01-15T09:00:00Z"}, "freq": {"daily"}, "count": {"5"}})
+	resp, res = ts.get(t, "/events_for_week?user_id=2&date=2024-01-15")
+	wantStatus(t, resp, res, http.StatusOK)
+	n := 0
+	for _, e := range res.Events {
+		if e.EventID == series.EventID {
+			n++
+		}
+	}
+	if n != 5 {
+		t.Errorf("series occurrences %v, want 5", n)
+	}
+
+	resp, res = ts.form(t, "/create_event?on_conflict=reject", url.Values{"user_id": {"2"}, "title": {"Пересечение"}, "date": {"2024-01-11T10:00:00Z"}})
+	wantStatus(t, resp, res, http.StatusConflict)
+	if len(res.Conflicts) != 1 || res.Conflicts[0].EventID != 77 {
+		t.Errorf("conflicts %+v", res.Conflicts)
+	}
+
+	resp, res = ts.form(t, "/create_event", url.Values{"user_id": {"2"}, "title": {"Пересечение"}, "date": {"2024-01-11T10:00:00Z"}})
+	wantStatus(t, resp, res, http.StatusCreated)
+	if len(res.Warnings) != 1 {
+		t.Errorf("warnings %+v", res.Warnings)
+	}
+}
+
+func TestServerCalendars(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, res := ts.form(t, "/create_calendar", url.Values{"user_id": {"1"}, "name": {"Работа"}, "color": {"#ff0000"}})
+	wantStatus(t, resp, res, http.StatusCreated)
+	cal := res.Calendars[0]
+
+	resp, res = ts.form(t, "/update_calendar", url.Values{"user_id": {"1"}, "calendar_id": {fmt.Sprint(cal.CalendarID)}, "name": {"Офис"}})
+	wantStatus(t, resp, res, http.StatusOK)
+
+	ts.create(t, url.Values{"user_id": {"1"}, "title": {"Отчёт"}, "date": {"2024-01-10T10:00:00Z"}, "calendar_id": {fmt.Sprint(cal.CalendarID)}, "tags": {"срочно"}})
+	ts.create(t, url.Values{"user_id": {"1"}, "title": {"Личное"}, "date": {"2024-01-10T12:00:00Z"}})
+
+	resp, res = ts.get(t, "/calendars?user_id=1")
+	wantStatus(t, resp, res, http.StatusOK)
+	if len(res.Calendars) != 1 || res.Calendars[0].Name != "Офис" {
+		t.Errorf("calendars %+v", res.Calendars)
+	}
+
+	for path, want := range map[string]string{
+		fmt.Sprintf("/events_for_day?user_id=1&date=2024-01-10&calendars=%v", cal.CalendarID): "Отчёт",
+		"/events_for_day?user_id=1&date=2024-01-10&calendars=0":                               "Личное",
+		"/events_for_day?user_id=1&date=2024-01-10&tags=срочно":                               "Отчёт",
+	} {
+		resp, res = ts.get(t, path)
+		wantStatus(t, resp, res, http.StatusOK)
+		if len(res.Events) != 1 || res.Events[0].Title != want {
+			t.Errorf("%v: %+v", path, res.Events)
+		}
+	}
+
+	resp, res = ts.form(t, "/create_event", url.Values{"user_id": {"1"}, "title": {"?"}, "date": {"2024-01-10"}, "calendar_id": {"99"}})
+	wantStatus(t, resp, res, http.StatusServiceUnavailable)
+
+	resp, res = ts.form(t, "/delete_calendar", url.Values{"user_id": {"1"}, "calendar_id": {fmt.Sprint(cal.CalendarID)}})
+	wantStatus(t, resp, res, http.StatusServiceUnavailable)
+}
+
+func TestServerInvitations(t *testing.T) {
+	ts := newTestServer(t)
+
+	ev := ts.create(t, url.Values{"user_id": {"1"}, "title": {"Созвон"}, "date": {"2024-01-10T10:00:00Z"}, "attendees": {"2"}})
+
+	resp, res := ts.get(t, "/events_for_day?user_id=2&date=2024-01-10")
+	wantStatus(t, resp, res, http.StatusOK)
+	if len(res.Events) != 1 || res.Events[0].EventID != ev.EventID {
+		t.Fatalf("attendee events %+v", res.Events)
+	}
+
+	resp, res = ts.form(t, "/rsvp_event", url.Values{"user_id": {"2"}, "organizer_id": {"1"}, "event_id": {fmt.Sprint(ev.EventID)}, "status": {"accepted"}})
+	wantStatus(t, resp, res, http.StatusOK)
+	if got := res.Events[0].Attendees; len(got) != 1 || got[0].Status != rsvpAccepted {
+		t.Errorf("attendees %+v", got)
+	}
+
+	resp, res = ts.form(t, "/rsvp_event", url.Values{"user_id": {"3"}, "organizer_id": {"1"}, "event_id": {fmt.Sprint(ev.EventID)}, "status": {"accepted"}})
+	if resp.StatusCode < 400 {
+		t.Errorf("rsvp of a user who is not invited: %v", resp.StatusCode)
+	}
+}
+
+func TestServerImport(t *testing.T) {
+	ts := newTestServer(t)
+
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"BEGIN:VEVENT",
+		"UID:one@example.com",
+		"DTSTART:20240110T100000Z",
+		"DTEND:20240110T110000Z",
+		"SUMMARY:Импорт",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n") + "\r\n"
+
+	resp, res, _ := ts.do(t, http.MethodPost, "/import?user_id=5", "text/calendar", ics)
+	wantStatus(t, resp, res, http.StatusOK)
+	if len(res.Entries) != 1 || res.Entries[0].EventID <= 0 {
+		t.Fatalf("entries %+v", res.Entries)
+	}
+
+	resp, res = ts.get(t, "/events_for_day?user_id=5&date=2024-01-10")
+	wantStatus(t, resp, res, http.StatusOK)
+	if len(res.Events) != 1 || res.Events[0].Title != "Импорт" {
+		t.Errorf("imported %+v", res.Events)
+	}
+
+	resp, res, _ = ts.do(t, http.MethodPost, "/import?user_id=5", "text/calendar", "not a calendar")
+	wantStatus(t, resp, res, http.StatusBadRequest)
+}
+
+func TestServerBatch(t *testing.T) {
+	ts := newTestServer(t)
+
+	body := `{"operations":[
+		{"op":"create","event":{"user_id":1,"title":"a","date":"2024-01-10T10:00:00Z"}},
+		{"op":"create","event":{"user_id":1,"title":"b","date":"2024-01-11T10:00:00Z"}},
+		{"op":"delete","event":{"user_id":1,"event_id":1000}}
+	]}`
+	resp, res, _ := ts.do(t, http.MethodPost, "/events/batch", "application/json", body)
+	wantStatus(t, resp, res, http.StatusOK)
+	want := []int{http.StatusCreated, http.StatusCreated, http.StatusServiceUnavailable}
+	for i, r := range res.Results {
+		if r.Status != want[i] {
+			t.Errorf("operation %v: status %v, want %v", i, r.Status, want[i])
+		}
+	}
+
+	resp, res, _ = ts.do(t, http.MethodPost, "/events/batch", "application/x-www-form-urlencoded", "operations=1")
+	wantStatus(t, resp, res, http.StatusBadRequest)
+}
+
+func TestServerSuggestSlot(t *testing.T) {
+	ts := newTestServer(t)
+	ts.create(t, url.Values{"user_id": {"1"}, "title": {"Занято"}, "date": {"2024-01-10T09:00:00Z"}, "end": {"2024-01-10T12:00:00Z"}})
+
+	resp, res := ts.form(t, "/suggest_slot", url.Values{"user_id": {"1"}, "attendees": {"2"}, "from": {"2024-01-10"}, "to": {"2024-01-11"}, "duration": {"1h"}, "limit": {"1"}})
+	wantStatus(t, resp, res, http.StatusOK)
+	if len(res.Slots) != 1 || !res.Slots[0].Start.Equal(time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("slots %+v", res.Slots)
+	}
+
+	resp, res = ts.form(t, "/suggest_slot", url.Values{"user_id": {"1"}, "from": {"2024-01-10"}, "to": {"2024-06-10"}, "duration": {"1h"}})
+	wantStatus(t, resp, res, http.StatusBadRequest)
+}
+
+func TestServerOpenAPI(t *testing.T) {
+	ts := newTestServer(t)
+
+	resp, _, body := ts.do(t, http.MethodGet, "/openapi.json", "", "")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("openapi: %v", resp.StatusCode)
+	}
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		t.Fatal(err)
+	}
+	documented := make(map[string]bool)
+	for path, item := range doc.Paths {
+		for method := range item {
+			if method != "parameters" {
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	// маршруты, которые main регистрирует сам: они зависят от настроек
+	registered := map[string]bool{"POST /login": true, "GET /metrics": true, "GET /events/stream": true}
+	for _, r := range ts.srv.Router.routes {
+		for method := range r.handlers {
+			registered[method+" "+r.pattern] = true
+		}
+	}
+
+	for route := range registered {
+		if !documented[route] {
+			t.Errorf("%v is not documented", route)
+		}
+	}
+	for route := range documented {
+		if !registered[route] {
+			t.Errorf("%v is documented but not registered", route)
+		}
+	}
+}
+
+// failingStorage - хранилище, у которого ломаются чтения
+type failingStorage struct {
+	Storage
+}
+
+func (failingStorage) getEventsForDay(int, time.Time) ([]Event, error) {
+	return nil, errors.New("disk is on fire")
+}
+
+func TestServerErrorStatus(t *testing.T) {
+	ts := newTestServer(t)
+	ts.create(t, url.Values{"user_id": {"1"}, "title": {"Событие"}, "date": {"2024-01-10T10:00:00Z"}})
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        string
+		status      int
+		field       string
+	}{
+		{"unknown path", http.MethodGet, "/nope", "", "", http.StatusNotFound, ""},
+		{"wrong method", http.MethodPost, "/events_for_day", "", "", http.StatusMethodNotAllowed, ""},
+		{"missing date", http.MethodGet, "/events_for_day?user_id=1", "", "", http.StatusBadRequest, "date"},
+		{"invalid date", http.MethodGet, "/events_for_day?user_id=1&date=10.01.2024", "", "", http.StatusBadRequest, "date"},
+		{"invalid user_id", http.MethodGet, "/events_for_week?user_id=x&date=2024-01-10", "", "", http.StatusBadRequest, "user_id"},
+		{"missing title", http.MethodPost, "/create_event", "application/x-www-form-urlencoded", "user_id=1&date=2024-01-10", http.StatusBadRequest, "title"},
+		{"broken json", http.MethodPost, "/create_event", "application/json", `{"user_id":`, http.StatusBadRequest, ""},
+		{"unsupported body", http.MethodPost, "/create_event", "text/plain", "user_id=1", http.StatusBadRequest, "content_type"},
+		{"unknown user", http.MethodGet, "/events_for_month?user_id=42&date=2024-01-10", "", "", http.StatusServiceUnavailable, ""},
+		{"unknown event", http.MethodPost, "/update_event", "application/x-www-form-urlencoded", "user_id=1&event_id=99&title=x&date=2024-01-10", http.StatusServiceUnavailable, ""},
+		{"conflict", http.MethodPost, "/create_event?on_conflict=reject", "application/x-www-form-urlencoded", "user_id=1&title=x&date=2024-01-10T10:00:00Z", http.StatusConflict, ""},
+		{"stale version", http.MethodPost, "/delete_event", "application/x-www-form-urlencoded", "user_id=1&event_id=1&version=7", http.StatusPreconditionFailed, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, res, _ := ts.do(t, tt.method, tt.path, tt.contentType, tt.body)
+			wantStatus(t, resp, res, tt.status)
+			if tt.status == http.StatusMethodNotAllowed && resp.Header.Get("Allow") != "GET, HEAD" {
+				t.Errorf("Allow %q", resp.Header.Get("Allow"))
+			}
+			if tt.status != http.StatusNotFound && res.Error == "" {
+				t.Error("empty error")
+			}
+			if tt.field == "" {
+				return
+			}
+			for _, f := range res.Fields {
+				if f.Field == tt.field {
+					return
+				}
+			}
+			t.Errorf("fields %+v, want %v", res.Fields, tt.field)
+		})
+	}
+
+	broken := newTestServerWith(t, failingStorage{ts.srv.storage}, ts.clock)
+	resp, res := broken.get(t, "/events_for_day?user_id=1&date=2024-01-10")
+	wantStatus(t, resp, res, http.StatusInternalServerError)
+	if res.Error != "internal server error" {
+		t.Errorf("500 must not leak the cause: %q", res.Error)
+	}
+}
+
+func TestServerConcurrent(t *testing.T) {
+	ts := newTestServer(t)
+
+	const users, perUser = 8, 20
+	var wg sync.WaitGroup
+	for u := 1; u <= users; u++ {
+		wg.Add(2)
+		go func(userID int) {
+			defer wg.Done()
+			for i := 0; i < perUser; i++ {
+				values := url.Values{
+					"user_id":   {fmt.Sprint(userID)},
+					"title":     {fmt.Sprint("событие ", i)},
+					"date":      {time.Date(2024, 1, 10, i, 0, 0, 0, time.UTC).Format(time.RFC3339)},
+					"attendees": {fmt.Sprint(userID%users + 1)},
+				}
+				resp, res := ts.form(t, "/create_event", values)
+				if resp.StatusCode != http.StatusCreated {
+					t.Errorf("create: %v %+v", resp.StatusCode, res)
+					return
+				}
+				ev := res.Events[0]
+				resp, res = ts.form(t, "/rsvp_event", url.Values{"user_id": {fmt.Sprint(userID%users + 1)}, "organizer_id": {fmt.Sprint(userID)}, "event_id": {fmt.Sprint(ev.EventID)}, "status": {"accepted"}})
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("rsvp: %v %+v", resp.StatusCode, res)
+				}
+			}
+		}(u)
+		go func(userID int) {
+			defer wg.Done()
+			for i := 0; i < perUser; i++ {
+				// до первого события пользователя выборка - 503, это тоже допустимый ответ
+				resp, _ := ts.get(t, fmt.Sprintf("/events?user_id=%v&from=2024-01-10&to=2024-01-11", userID))
+				if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusServiceUnavailable {
+					t.Errorf("events: %v", resp.StatusCode)
+				}
+			}
+		}(u)
+	}
+	wg.Wait()
+
+	for u := 1; u <= users; u++ {
+		resp, res := ts.get(t, fmt.Sprintf("/events_for_day?user_id=%v&date=2024-01-10", u))
+		wantStatus(t, resp, res, http.StatusOK)
+		// свои события и приглашения от предыдущего пользователя
+		if len(res.Events) != 2*perUser {
+			t.Errorf("user %v: %v events, want %v", u, len(res.Events), 2*perUser)
+		}
+	}
+}
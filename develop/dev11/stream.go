@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Настройки потока изменений
+const (
+	// changeLogSize - сколько последних изменений хранится для возобновления по Last-Event-ID
+	changeLogSize = 1024
+	// subscriberBuffer - очередь подписчика; не успевающий подписчик отключается и переподключается с Last-Event-ID
+	subscriberBuffer = 64
+	// streamHeartbeat - как часто в поток пишется комментарий, чтобы прокси не закрывали соединение
+	streamHeartbeat = 15 * time.Second
+)
+
+// Change - изменение события, рассылается организатору и участникам
+type Change struct {
+	ID    uint64    `json:"id"`
+	Op    string    `json:"op"`
+	Time  time.Time `json:"time"`
+	Event Event     `json:"event"`
+	// users - кому доставляется изменение
+	users []int
+}
+
+func (c *Change) visibleTo(userID int) bool {
+	for _, u := range c.users {
+		if u == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// appendUser добавляет пользователя, если его ещё нет в списке
+func appendUser(users []int, userID int) []int {
+	for _, u := range users {
+		if u == userID {
+			return users
+		}
+	}
+	return append(users, userID)
+}
+
+type subscriber struct {
+	userID int
+	ch     chan Change
+}
+
+// Hub - публикация изменений и подписки на них с ограниченным журналом последних изменений
+type Hub struct {
+	mu     sync.Mutex
+	seq    uint64
+	log    []Change
+	subs   map[*subscriber]struct{}
+	closed bool
+	// now - время изменений
+	now func() time.Time
+}
+
+func newHub(now func() time.Time) *Hub {
+	return &Hub{subs: make(map[*subscriber]struct{}), now: now}
+}
+
+// publish записывает изменение в журнал и рассылает подписчикам: организатору и участникам,
+// а при изменении - и участникам старой версии old, чтобы удалённые из события узнали об этом
+func (h *Hub) publish(op string, ev Event, old *Event) {
+	users := []int{ev.UserID}
+	for _, a := range ev.Attendees {
+		users = appendUser(users, a.UserID)
+	}
+	if old != nil {
+		for _, a := range old.Attendees {
+			users = appendUser(users, a.UserID)
+		}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	c := Change{ID: h.seq, Op: op, Time: h.now(), Event: ev, users: users}
+	if len(h.log) == changeLogSize {
+		copy(h.log, h.log[1:])
+		h.log = h.log[:changeLogSize-1]
+	}
+	h.log = append(h.log, c)
+
+	for s := range h.subs {
+		if !c.visibleTo(s.userID) {
+			continue
+		}
+		select {
+		case s.ch <- c:
+		default:
+			delete(h.subs, s)
+			close(s.ch)
+		}
+	}
+}
+
+// subscribe подписывает пользователя. С lastID > 0 возвращает изменения после lastID из журнала;
+// complete == false, если часть из них уже вытеснена из журнала или lastID выдан до перезапуска сервера
+func (h *Hub) subscribe(userID int, lastID uint64) (s *subscriber, backlog []Change, complete bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s = &subscriber{userID: userID, ch: make(chan Change, subscriberBuffer)}
+	if h.closed {
+		close(s.ch)
+		return s, nil, true
+	}
+	h.subs[s] = struct{}{}
+
+	if lastID == 0 {
+		return s, nil, true
+	}
+
+	oldest := h.seq + 1
+	if len(h.log) > 0 {
+		oldest = h.log[0].ID
+	}
+	complete = lastID <= h.seq && lastID+1 >= oldest
+	for _, c := range h.log {
+		if c.ID > lastID && c.visibleTo(userID) {
+			backlog = append(backlog, c)
+		}
+	}
+	return s, backlog, complete
+}
+
+func (h *Hub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.ch)
+	}
+}
+
+// Close отключает всех подписчиков, вызывается при остановке сервера
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for s := range h.subs {
+		delete(h.subs, s)
+		close(s.ch)
+	}
+}
+
+// publishingStorage - хранилище, публикующее успешные изменения в Hub.
+// Изменение и его публикация идут под блокировкой организатора: изменения одного события
+// получают номера в том же порядке, в каком применялись, а события разных пользователей меняются параллельно
+type publishingStorage struct {
+	Storage
+	hub   *Hub
+	locks [storageShards]sync.Mutex
+}
+
+func newPublishingStorage(s Storage, hub *Hub) *publishingStorage {
+	return &publishingStorage{Storage: s, hub: hub}
+}
+
+// lock блокирует организаторов userIDs по возрастанию номера блокировки и возвращает разблокировку
+func (s *publishingStorage) lock(userIDs ...int) func() {
+	var locked [storageShards]bool
+	for _, userID := range userIDs {
+		locked[uint(userID)%storageShards] = true
+	}
+	for i, ok := range locked {
+		if ok {
+			s.locks[i].Lock()
+		}
+	}
+	return func() {
+		for i, ok := range locked {
+			if ok {
+				s.locks[i].Unlock()
+			}
+		}
+	}
+}
+
+// previous возвращает событие до изменения, nil - если его нет
+func (s *publishingStorage) previous(userID, eventID int) *Event {
+	old, err := s.Storage.getEvent(userID, eventID)
+	if err != nil {
+		return nil
+	}
+	return &old
+}
+
+func (s *publishingStorage) Create(ev *Event, policy ConflictPolicy) ([]Event, error) {
+	defer s.lock(ev.UserID)()
+
+	conflicts, err := s.Storage.Create(ev, policy)
+	if err == nil {
+		s.hub.publish(opCreate, *ev, nil)
+	}
+	return conflicts, err
+}
+
+func (s *publishingStorage) Update(ev *Event, policy ConflictPolicy) ([]Event, error) {
+	defer s.lock(ev.UserID)()
+
+	old := s.previous(ev.UserID, ev.EventID)
+	conflicts, err := s.Storage.Update(ev, policy)
+	if err == nil {
+		s.hub.publish(opUpdate, *ev, old)
+	}
+	return conflicts, err
+}
+
+func (s *publishingStorage) Delete(ev *Event) (*Event, error) {
+	defer s.lock(ev.UserID)()
+
+	deleted, err := s.Storage.Delete(ev)
+	if err == nil {
+		s.hub.publish(opDelete, *deleted, nil)
+	}
+	return deleted, err
+}
+
+func (s *publishingStorage) Batch(ops []BatchOp, policy ConflictPolicy, atomic bool) ([]BatchResult, error) {
+	userIDs := make([]int, len(ops))
+	for i, op := range ops {
+		userIDs[i] = op.Event.UserID
+	}
+	defer s.lock(userIDs...)()
+
+	// участники до пакета; несколько изменений одного события в пакете сравниваются с состоянием до пакета
+	old := make([]*Event, len(ops))
+	for i, op := range ops {
+		if op.Op == opUpdate {
+			old[i] = s.previous(op.Event.UserID, op.Event.EventID)
+		}
+	}
+
+	results, err := s.Storage.Batch(ops, policy, atomic)
+	for i, res := range results {
+		if res.Err == nil {
+			s.hub.publish(ops[i].Op, *res.Event, old[i])
+		}
+	}
+	return results, err
+}
+
+func (s *publishingStorage) Restore(ev *Event) ([]Event, error) {
+	defer s.lock(ev.UserID)()
+
+	conflicts, err := s.Storage.Restore(ev)
+	if err == nil {
+		s.hub.publish(opRestore, *ev, nil)
+	}
+	return conflicts, err
+}
+
+func (s *publishingStorage) Respond(organizerID, eventID, userID int, status string) (*Event, error) {
+	defer s.lock(organizerID)()
+
+	ev, err := s.Storage.Respond(organizerID, eventID, userID, status)
+	if err == nil {
+		s.hub.publish(opRespond, *ev, nil)
+	}
+	return ev, err
+}
+
+// writeSSE пишет одно событие Server-Sent Events
+func writeSSE(w http.ResponseWriter, c *Change) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", c.ID, c.Op, data)
+	return err
+}
+
+// StreamHandler /events/stream handler: изменения событий пользователя в формате Server-Sent Events.
+// Переподключившийся клиент передаёт Last-Event-ID (или last_event_id) и получает пропущенное из журнала;
+// если журнал уже не содержит всех пропущенных изменений, первым приходит событие reset - данные надо перечитать
+func StreamHandler(hub *Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b := binder{values: r.URL.Query()}
+		userID := b.userID(r)
+		lastID := r.Header.Get("Last-Event-ID")
+		if lastID == "" {
+			lastID = b.str("last_event_id")
+		}
+		var after uint64
+		if lastID != "" {
+			var err error
+			if after, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+				b.errs.add("last_event_id", "must be a non-negative integer")
+			}
+		}
+		if err := b.err(); err != nil {
+			getErrorResponse(w, err)
+			return
+		}
+
+		rc := http.NewResponseController(w)
+		// поток живёт дольше write_timeout сервера
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil && err != http.ErrNotSupported {
+			log.Printf("stream: %v", err)
+		}
+
+		sub, backlog, complete := hub.subscribe(userID, after)
+		defer hub.unsubscribe(sub)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		if !complete {
+			fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+		}
+		for i := range backlog {
+			if writeSSE(w, &backlog[i]) != nil {
+				return
+			}
+		}
+		if rc.Flush() != nil {
+			return
+		}
+
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case c, ok := <-sub.ch:
+				if !ok {
+					return
+				}
+				if writeSSE(w, &c) != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+					return
+				}
+			}
+			if rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// sseEvent - одно событие потока
+type sseEvent struct {
+	id, name string
+	change   Change
+}
+
+// sseStream - открытый поток изменений пользователя
+type sseStream struct {
+	events chan sseEvent
+}
+
+func newStreamServer(t *testing.T) (*httptest.Server, *publishingStorage) {
+	t.Helper()
+	hub := newHub(time.Now)
+	s := newPublishingStorage(newMemoryStorage(time.Now), hub)
+	srv := newServer(s, time.Now)
+	srv.Handle(http.MethodGet, "/events/stream", StreamHandler(hub))
+	ts := httptest.NewServer(srv)
+	// Close ждёт открытые потоки, их закрывает Hub
+	t.Cleanup(func() {
+		hub.Close()
+		ts.Close()
+	})
+	return ts, s
+}
+
+// openStream подключается к потоку; после ответа подписка уже действует
+func openStream(t *testing.T, ts *httptest.Server, userID int, lastID string) *sseStream {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%v/events/stream?user_id=%v", ts.URL, userID), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("stream: %v %v", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	s := &sseStream{events: make(chan sseEvent, 64)}
+	go func() {
+		defer resp.Body.Close()
+		defer close(s.events)
+		r := bufio.NewReader(resp.Body)
+		var ev sseEvent
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case line == "":
+				if ev.name != "" {
+					s.events <- ev
+				}
+				ev = sseEvent{}
+			case strings.HasPrefix(line, "id: "):
+				ev.id = strings.TrimPrefix(line, "id: ")
+			case strings.HasPrefix(line, "event: "):
+				ev.name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev.change)
+			}
+		}
+	}()
+	return s
+}
+
+func (s *sseStream) next(t *testing.T) sseEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-s.events:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		return ev
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event in the stream")
+	}
+	return sseEvent{}
+}
+
+// none проверяет, что в поток ничего не пришло
+func (s *sseStream) none(t *testing.T) {
+	t.Helper()
+	select {
+	case ev := <-s.events:
+		t.Errorf("unexpected %v of event %v", ev.name, ev.change.Event.EventID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestStreamAttendeeFanOut(t *testing.T) {
+	ts, s := newStreamServer(t)
+	organizer, attendee, other := openStream(t, ts, 1, ""), openStream(t, ts, 2, ""), openStream(t, ts, 3, "")
+
+	ev := Event{UserID: 1, Title: "Созвон", Date: time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC), Attendees: []Attendee{{UserID: 2}}}
+	if _, err := s.Create(&ev, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+	for _, stream := range []*sseStream{organizer, attendee} {
+		if got := stream.next(t); got.name != opCreate || got.change.Event.EventID != ev.EventID {
+			t.Errorf("create: %+v", got)
+		}
+	}
+	other.none(t)
+
+	// участника 2 заменили на 3: удалённый тоже узнаёт об изменении
+	update := Event{UserID: 1, EventID: ev.EventID, Title: "Созвон", Date: ev.Date, Attendees: []Attendee{{UserID: 3}}}
+	if _, err := s.Update(&update, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+	for i, stream := range []*sseStream{organizer, attendee, other} {
+		got := stream.next(t)
+		if got.name != opUpdate || len(got.change.Event.Attendees) != 1 || got.change.Event.Attendees[0].UserID != 3 {
+			t.Errorf("stream %v: update %+v", i, got)
+		}
+	}
+
+	// после этого бывший участник изменений события не получает
+	update.Title = "Перенесён"
+	if _, err := s.Update(&update, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+	organizer.next(t)
+	other.next(t)
+	attendee.none(t)
+}
+
+func TestStreamResume(t *testing.T) {
+	ts, s := newStreamServer(t)
+	first := openStream(t, ts, 1, "")
+
+	date := time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		ev := Event{UserID: 1, Title: fmt.Sprint("событие ", i), Date: date.Add(time.Duration(i) * time.Hour)}
+		if _, err := s.Create(&ev, conflictWarn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, first.next(t).id)
+	}
+
+	// переподключение после первого изменения отдаёт остальные по порядку
+	resumed := openStream(t, ts, 1, ids[0])
+	for _, want := range ids[1:] {
+		if got := resumed.next(t); got.id != want {
+			t.Errorf("resumed id %v, want %v", got.id, want)
+		}
+	}
+	resumed.none(t)
+
+	// номер из будущего (выдан до перезапуска) - сначала reset
+	if got := openStream(t, ts, 1, "1000").next(t); got.name != "reset" {
+		t.Errorf("stale Last-Event-ID: %+v", got)
+	}
+
+	resp, err := ts.Client().Get(ts.URL + "/events/stream?user_id=1&last_event_id=x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad last_event_id: %v", resp.StatusCode)
+	}
+}
+
+func TestPublishInMutationOrder(t *testing.T) {
+	hub := newHub(time.Now)
+	s := newPublishingStorage(newMemoryStorage(time.Now), hub)
+	ev := Event{UserID: 1, Title: "x", Date: time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)}
+	if _, err := s.Create(&ev, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				update := Event{UserID: 1, EventID: ev.EventID, Title: fmt.Sprint(g, "-", i), Date: ev.Date}
+				if _, err := s.Update(&update, conflictWarn); err != nil {
+					t.Error(err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	// номера изменений идут в порядке версий, последнее изменение - итоговое состояние
+	final, err := s.getEvent(1, ev.EventID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < len(hub.log); i++ {
+		if hub.log[i].Event.Version <= hub.log[i-1].Event.Version {
+			t.Fatalf("change %v has version %v after %v", hub.log[i].ID, hub.log[i].Event.Version, hub.log[i-1].Event.Version)
+		}
+	}
+	if last := hub.log[len(hub.log)-1].Event; last.Title != final.Title || last.Version != final.Version {
+		t.Errorf("last change %q v%v, stored %q v%v", last.Title, last.Version, final.Title, final.Version)
+	}
+}
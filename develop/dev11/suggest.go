@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ограничения /suggest_slot
+const (
+	maxSuggestUsers  = 50
+	maxSuggestLimit  = 100
+	maxSuggestWindow = 90 * 24 * time.Hour
+	defaultSlotStep  = 30 * time.Minute
+	defaultSlotLimit = 10
+)
+
+// WorkingHours - рабочее время пользователя: дни недели (коды BYDAY) и часы "15:04" в его поясе
+type WorkingHours struct {
+	TimeZone string   `json:"time_zone,omitempty"`
+	Days     []string `json:"days"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+
+	loc        *time.Location
+	days       [7]bool
+	start, end time.Duration
+}
+
+// defaultWorkingHours - рабочее время тех, для кого оно не задано: будни с 9 до 18 UTC
+func defaultWorkingHours() WorkingHours {
+	h := WorkingHours{Days: []string{"MO", "TU", "WE", "TH", "FR"}, Start: "09:00", End: "18:00"}
+	h.parse()
+	return h
+}
+
+// parseClock разбирает время суток "15:04", допускается "24:00"
+func parseClock(value string) (time.Duration, error) {
+	hh, mm, ok := strings.Cut(value, ":")
+	h, errH := strconv.Atoi(hh)
+	m, errM := strconv.Atoi(mm)
+	if !ok || errH != nil || errM != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time of day %q, want 15:04", value)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// parse проверяет рабочее время и заполняет разобранные поля
+func (h *WorkingHours) parse() error {
+	h.loc = time.UTC
+	if h.TimeZone != "" {
+		loc, err := time.LoadLocation(h.TimeZone)
+		if err != nil {
+			return fmt.Errorf("unknown time zone %q", h.TimeZone)
+		}
+		h.loc = loc
+	}
+
+	if len(h.Days) == 0 {
+		return fmt.Errorf("days are required")
+	}
+	for _, day := range h.Days {
+		wd, ok := weekdays[day]
+		if !ok {
+			return fmt.Errorf("unknown day %q", day)
+		}
+		h.days[wd] = true
+	}
+
+	var err error
+	if h.start, err = parseClock(h.Start); err != nil {
+		return err
+	}
+	if h.end, err = parseClock(h.End); err != nil {
+		return err
+	}
+	if h.end <= h.start {
+		return fmt.Errorf("end must be after start")
+	}
+	return nil
+}
+
+// Availability - рабочее время пользователей и праздники, когда не работает никто
+type Availability struct {
+	defaults WorkingHours
+	users    map[int]WorkingHours
+	// holidays - даты 2006-01-02, сравниваются с датой в поясе пользователя
+	holidays map[string]bool
+}
+
+// defaultAvailability - рабочее время по умолчанию у всех, праздников нет
+func defaultAvailability() *Availability {
+	return &Availability{defaults: defaultWorkingHours(), users: make(map[int]WorkingHours), holidays: make(map[string]bool)}
+}
+
+// newAvailability читает файл рабочего времени и файл праздников, пустой путь - значения по умолчанию.
+// Файл рабочего времени - JSON {"default": WorkingHours, "users": {"<user_id>": WorkingHours}},
+// файл праздников - по дате 2006-01-02 в строке, после даты может идти название, # - комментарий
+func newAvailability(hoursPath, holidaysPath string) (*Availability, error) {
+	a := defaultAvailability()
+
+	if hoursPath != "" {
+		data, err := os.ReadFile(hoursPath)
+		if err != nil {
+			return nil, err
+		}
+		var file struct {
+			Default *WorkingHours        `json:"default"`
+			Users   map[int]WorkingHours `json:"users"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("working hours %v: %v", hoursPath, err)
+		}
+		if file.Default != nil {
+			if err := file.Default.parse(); err != nil {
+				return nil, fmt.Errorf("working hours %v: default: %v", hoursPath, err)
+			}
+			a.defaults = *file.Default
+		}
+		for userID, h := range file.Users {
+			if err := h.parse(); err != nil {
+				return nil, fmt.Errorf("working hours %v: user %v: %v", hoursPath, userID, err)
+			}
+			a.users[userID] = h
+		}
+	}
+
+	if holidaysPath != "" {
+		f, err := os.Open(holidaysPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		sc := bufio.NewScanner(f)
+		for n := 1; sc.Scan(); n++ {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			date, _, _ := strings.Cut(line, " ")
+			if _, err := time.Parse(dateFormat, date); err != nil {
+				return nil, fmt.Errorf("holidays %v:%v: invalid date %q", holidaysPath, n, date)
+			}
+			a.holidays[date] = true
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// hours возвращает рабочее время пользователя
+func (a *Availability) hours(userID int) WorkingHours {
+	if h, ok := a.users[userID]; ok {
+		return h
+	}
+	return a.defaults
+}
+
+// working возвращает рабочие промежутки пользователя в окне [from, to) без выходных и праздников
+func (a *Availability) working(userID int, from, to time.Time) []Interval {
+	h := a.hours(userID)
+
+	var res []Interval
+	// начинаем с предыдущего дня: рабочий день мог начаться до from по времени пользователя
+	for day := midnight(from.In(h.loc)).AddDate(0, 0, -1); day.Before(to); day = day.AddDate(0, 0, 1) {
+		if !h.days[day.Weekday()] || a.holidays[day.Format(dateFormat)] {
+			continue
+		}
+		y, m, d := day.Date()
+		start := time.Date(y, m, d, 0, 0, 0, 0, h.loc).Add(h.start)
+		end := time.Date(y, m, d, 0, 0, 0, 0, h.loc).Add(h.end)
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if end.After(start) {
+			res = append(res, Interval{Start: start, End: end})
+		}
+	}
+	return res
+}
+
+// subtractIntervals вычитает из упорядоченных промежутков a упорядоченные непересекающиеся промежутки b
+func subtractIntervals(a, b []Interval) []Interval {
+	var res []Interval
+	for _, iv := range a {
+		for _, cut := range b {
+			if !cut.End.After(iv.Start) || !cut.Start.Before(iv.End) {
+				continue
+			}
+			if cut.Start.After(iv.Start) {
+				res = append(res, Interval{Start: iv.Start, End: cut.Start})
+			}
+			iv.Start = cut.End
+			if !iv.End.After(iv.Start) {
+				break
+			}
+		}
+		if iv.End.After(iv.Start) {
+			res = append(res, iv)
+		}
+	}
+	return res
+}
+
+// intersectIntervals - пересечение двух упорядоченных списков непересекающихся промежутков
+func intersectIntervals(a, b []Interval) []Interval {
+	var res []Interval
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		start, end := a[i].Start, a[i].End
+		if b[j].Start.After(start) {
+			start = b[j].Start
+		}
+		if b[j].End.Before(end) {
+			end = b[j].End
+		}
+		if end.After(start) {
+			res = append(res, Interval{Start: start, End: end})
+		}
+		if a[i].End.Before(b[j].End) {
+			i++
+		} else {
+			j++
+		}
+	}
+	return res
+}
+
+// declinedBy проверяет, что пользователь отказался от приглашения
+func declinedBy(ev *Event, userID int) bool {
+	for _, a := range ev.Attendees {
+		if a.UserID == userID {
+			return a.Status == rsvpDeclined
+		}
+	}
+	return false
+}
+
+// available возвращает свободное рабочее время пользователя в окне [from, to).
+// События, от которых пользователь отказался, время не занимают; пользователь без событий свободен
+func (srv *Server) available(userID int, from, to time.Time) ([]Interval, error) {
+	events, err := srv.storage.getEventsInRange(userID, from, to)
+	var businessErr *BusinessError
+	if err != nil && !errors.As(err, &businessErr) {
+		return nil, err
+	}
+
+	accepted := events[:0]
+	for i := range events {
+		if !declinedBy(&events[i], userID) {
+			accepted = append(accepted, events[i])
+		}
+	}
+	busy, _ := freeBusy(accepted, from, to)
+
+	return subtractIntervals(srv.availability.working(userID, from, to), busy), nil
+}
+
+// suggestSlots нарезает общие свободные промежутки на слоты длиной duration с началом, кратным step
+func suggestSlots(free []Interval, duration, step time.Duration, limit int) []Interval {
+	res := []Interval{}
+	for _, iv := range free {
+		start := iv.Start.Truncate(step)
+		if start.Before(iv.Start) {
+			start = start.Add(step)
+		}
+		for ; !start.Add(duration).After(iv.End); start = start.Add(step) {
+			if len(res) == limit {
+				return res
+			}
+			res = append(res, Interval{Start: start, End: start.Add(duration)})
+		}
+	}
+	return res
+}
+
+// SuggestSlotHandler /suggest_slot handler: ближайшие слоты, когда свободны организатор user_id и
+// все attendees в их рабочее время, не в праздники и без пересечения с их событиями
+func (srv *Server) SuggestSlotHandler(w http.ResponseWriter, r *http.Request) {
+	values, err := formValues(r)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	b := binder{values: values}
+	userIDs := []int{b.userID(r)}
+	for _, id := range b.ints("attendees") {
+		if id <= 0 {
+			b.errs.add("attendees", "user_id must be positive")
+			continue
+		}
+		userIDs = append(userIDs, id)
+	}
+	if len(userIDs) > maxSuggestUsers {
+		b.errs.add("attendees", fmt.Sprintf("must have at most %v items", maxSuggestUsers-1))
+	}
+	loc := b.location("tz")
+	from := b.time("from", loc, true)
+	to := b.time("to", loc, true)
+	switch {
+	case from.IsZero() || to.IsZero():
+	case !to.After(from):
+		b.errs.add("to", "must be after from")
+	case to.Sub(from) > maxSuggestWindow:
+		b.errs.add("to", fmt.Sprintf("window must be at most %v days", int(maxSuggestWindow.Hours()/24)))
+	}
+	duration := b.duration("duration", true)
+	if b.str("duration") != "" && duration <= 0 {
+		b.errs.add("duration", "must be positive")
+	}
+	step := defaultSlotStep
+	if b.str("step") != "" {
+		if step = b.duration("step", false); step < time.Minute {
+			b.errs.add("step", "must be at least 1m")
+		}
+	}
+	limit := defaultSlotLimit
+	if b.str("limit") != "" {
+		if limit = b.int("limit", false); limit <= 0 || limit > maxSuggestLimit {
+			b.errs.add("limit", fmt.Sprintf("must be between 1 and %v", maxSuggestLimit))
+		}
+	}
+	if err := b.err(); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	free := []Interval{{Start: from, End: to}}
+	seen := make(map[int]bool, len(userIDs))
+	for _, userID := range userIDs {
+		if seen[userID] {
+			continue
+		}
+		seen[userID] = true
+
+		available, err := srv.available(userID, from, to)
+		if err != nil {
+			getErrorResponse(w, err)
+			return
+		}
+		free = intersectIntervals(free, available)
+	}
+
+	slots := suggestSlots(free, duration, step, limit)
+	for i := range slots {
+		slots[i].Start, slots[i].End = slots[i].Start.In(loc), slots[i].End.In(loc)
+	}
+
+	resp := struct {
+		Result string     `json:"result"`
+		Slots  []Interval `json:"slots"`
+	}{Result: "Запрос успешно выполнен!", Slots: slots}
+
+	writeJSON(w, resp, http.StatusOK)
+}
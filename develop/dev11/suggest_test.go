@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile пишет файл во временный каталог теста и возвращает путь
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := t.TempDir() + "/" + name
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewAvailability(t *testing.T) {
+	hours := writeFile(t, "hours.json", `{
+		"default": {"days": ["MO", "TU", "WE", "TH"], "start": "10:00", "end": "17:00"},
+		"users": {"2": {"time_zone": "Asia/Tokyo", "days": ["MO", "TU", "WE", "TH", "FR"], "start": "09:00", "end": "18:00"}}
+	}`)
+	holidays := writeFile(t, "holidays.txt", "# праздники\n2024-01-01 Новый год\n\n2024-01-10\n")
+
+	a, err := newAvailability(hours, holidays)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := a.hours(1); h.days[time.Friday] || h.start != 10*time.Hour {
+		t.Errorf("default hours %+v", h)
+	}
+	if h := a.hours(2); h.loc.String() != "Asia/Tokyo" || !h.days[time.Friday] {
+		t.Errorf("user hours %+v", h)
+	}
+	if !a.holidays["2024-01-01"] || !a.holidays["2024-01-10"] || len(a.holidays) != 2 {
+		t.Errorf("holidays %v", a.holidays)
+	}
+
+	for name, path := range map[string]string{
+		"bad date":      writeFile(t, "bad.txt", "2024-13-01\n"),
+		"missing file":  t.TempDir() + "/none.txt",
+		"bad hours":     writeFile(t, "bad.json", `{"default": {"days": ["MO"], "start": "18:00", "end": "09:00"}}`),
+		"unknown zone":  writeFile(t, "zone.json", `{"users": {"3": {"time_zone": "Mars/Olympus", "days": ["MO"], "start": "09:00", "end": "18:00"}}}`),
+		"no days":       writeFile(t, "days.json", `{"default": {"days": [], "start": "09:00", "end": "18:00"}}`),
+		"unknown day":   writeFile(t, "day.json", `{"default": {"days": ["XX"], "start": "09:00", "end": "18:00"}}`),
+		"bad time":      writeFile(t, "time.json", `{"default": {"days": ["MO"], "start": "9", "end": "18:00"}}`),
+		"not json":      writeFile(t, "garbage.json", `days`),
+		"past midnight": writeFile(t, "late.json", `{"default": {"days": ["MO"], "start": "09:00", "end": "24:30"}}`),
+	} {
+		var err error
+		if name == "bad date" || name == "missing file" {
+			_, err = newAvailability("", path)
+		} else {
+			_, err = newAvailability(path, "")
+		}
+		if err == nil {
+			t.Errorf("%v: no error", name)
+		}
+	}
+}
+
+func TestWorkingSkipsHolidays(t *testing.T) {
+	a := defaultAvailability()
+	a.holidays["2024-01-10"] = true
+	tokyo := WorkingHours{TimeZone: "Asia/Tokyo", Days: []string{"MO", "TU", "WE", "TH", "FR"}, Start: "09:00", End: "18:00"}
+	if err := tokyo.parse(); err != nil {
+		t.Fatal(err)
+	}
+	a.users[2] = tokyo
+
+	// понедельник - понедельник: праздничная среда и выходные пропускаются
+	from := time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC)
+	var days []string
+	for _, iv := range a.working(1, from, from.AddDate(0, 0, 7)) {
+		days = append(days, iv.Start.Format(dateFormat))
+		if iv.End.Sub(iv.Start) != 9*time.Hour {
+			t.Errorf("working interval %v - %v", iv.Start, iv.End)
+		}
+	}
+	if got, want := strings.Join(days, " "), "2024-01-08 2024-01-09 2024-01-11 2024-01-12"; got != want {
+		t.Errorf("working days %v, want %v", got, want)
+	}
+
+	// праздник определяется по дате в поясе пользователя: 10 января в Токио начинается 9 января в 15:00 UTC
+	got := a.working(2, time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC))
+	if len(got) != 1 || !got[0].Start.Equal(time.Date(2024, 1, 9, 0, 0, 0, 0, time.UTC)) || !got[0].End.Equal(time.Date(2024, 1, 9, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("tokyo working %+v", got)
+	}
+}
+
+func TestSuggestSlotAcrossHolidays(t *testing.T) {
+	ts := newTestServer(t)
+	ts.srv.availability = defaultAvailability()
+	ts.srv.availability.holidays["2024-01-10"] = true
+	moscow := WorkingHours{TimeZone: "Europe/Moscow", Days: []string{"MO", "TU", "WE", "TH", "FR"}, Start: "10:00", End: "19:00"}
+	if err := moscow.parse(); err != nil {
+		t.Fatal(err)
+	}
+	ts.srv.availability.users[2] = moscow
+
+	// общее рабочее время - с 9 до 16 UTC, утро четверга занято у участника
+	ts.create(t, url.Values{"user_id": {"2"}, "title": {"Занято"}, "date": {"2024-01-11T09:00:00Z"}, "end": {"2024-01-11T10:00:00Z"}})
+
+	values := url.Values{"user_id": {"1"}, "attendees": {"2"}, "from": {"2024-01-09"}, "to": {"2024-01-16"}, "duration": {"6h"}, "step": {"1h"}, "limit": {"10"}}
+	resp, res := ts.form(t, "/suggest_slot", values)
+	wantStatus(t, resp, res, http.StatusOK)
+
+	want := []time.Time{
+		time.Date(2024, 1, 9, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 9, 10, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 11, 10, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 12, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 12, 10, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 15, 9, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+	}
+	if len(res.Slots) != len(want) {
+		t.Fatalf("slots %+v", res.Slots)
+	}
+	for i, slot := range res.Slots {
+		if !slot.Start.Equal(want[i]) || slot.End.Sub(slot.Start) != 6*time.Hour {
+			t.Errorf("slot %v: %v - %v, want start %v", i, slot.Start, slot.End, want[i])
+		}
+	}
+
+	// окно из одних праздников и выходных - пустой список, а не ошибка
+	values.Set("from", "2024-01-13")
+	values.Set("to", "2024-01-15")
+	resp, res = ts.form(t, "/suggest_slot", values)
+	wantStatus(t, resp, res, http.StatusOK)
+	if len(res.Slots) != 0 {
+		t.Errorf("slots on a weekend %+v", res.Slots)
+	}
+}
@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -39,406 +45,584 @@ import (
 
 const dateFormat = "2006-01-02"
 
-// Logger - для логирования запросов
-type Logger struct {
-	handler http.Handler
-}
-
-// Конструктор логгера
-func newLogger(handler http.Handler) *Logger {
-	return &Logger{handler: handler}
-}
-
-// ServeHTTP логика хэндлера, опишем этот метод, чтобы удовлетворить интерфейсу
-func (l *Logger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	start := time.Now()
-	l.handler.ServeHTTP(w, r)
-	log.Printf("%s %s %v\n", r.Method, r.URL, time.Since(start))
-}
-
 // Event - модель JSON хранилища
 type Event struct {
-	UserID      int       `json:"user_id"`
-	EventID     int       `json:"event_id"`
-	Title       string    `json:"title"`
-	Description string    `json:"description"`
-	Date        time.Time `json:"date"`
+	UserID      int    `json:"user_id"`
+	EventID     int    `json:"event_id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	// Date - начало события, End - конец (не включая), для события на весь день - полночь в его поясе
+	Date     time.Time  `json:"date"`
+	End      *time.Time `json:"end,omitempty"`
+	TimeZone string     `json:"time_zone,omitempty"`
+	AllDay   bool       `json:"all_day,omitempty"`
+	// Attendees - приглашённые пользователи, событие видно и в их календарях
+	Attendees []Attendee `json:"attendees,omitempty"`
+	// Reminders - за сколько минут до начала (каждого вхождения) напомнить о событии
+	Reminders []int `json:"reminders,omitempty"`
+	// CalendarID - календарь пользователя, 0 - основной; Tags - метки для отбора событий
+	CalendarID int      `json:"calendar_id,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	// Recurrence - правило повторения, у обычного события nil
+	Recurrence *Recurrence `json:"recurrence,omitempty"`
+	// RecurrenceID - исходная дата вхождения серии, задаётся при изменении или удалении одного вхождения
+	RecurrenceID *time.Time `json:"recurrence_id,omitempty"`
+	// Version - номер версии, назначается хранилищем и растёт при каждом изменении
+	Version int `json:"version,omitempty"`
+	// UID - идентификатор события из импортированного iCalendar, задаётся при создании и дальше не меняется
+	UID string `json:"uid,omitempty"`
 }
 
-// decode декодирует данные из reader в json
+// decode декодирует данные из reader в json, ошибки разбора отдаются как ошибки входных данных
 func (ev *Event) decode(r io.Reader) error {
-	err := json.NewDecoder(r).Decode(&ev)
-	if err != nil {
-		log.Printf("%+v error from decoder", err)
+	if err := json.NewDecoder(r).Decode(ev); err != nil && err != io.EOF {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return maxErr
+		}
+		var errs ValidationError
+		errs.merge(jsonFieldError(err))
+		return &errs
 	}
 	return nil
 }
 
-// validate проверяет наличие данных в обязательных полях
-func (ev *Event) validate() error {
+// jsonFieldError превращает ошибку декодера в ошибку конкретного поля, если это возможно
+func jsonFieldError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	var fieldErr *FieldError
 	switch {
-	case ev.UserID <= 0:
-		return fmt.Errorf("invalid user_id")
-	case ev.EventID <= 0:
-		return fmt.Errorf("invalid event_id")
-	case ev.Title == "":
-		return fmt.Errorf("invalid title")
+	case errors.As(err, &fieldErr):
+		return fieldErr
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return &FieldError{Field: typeErr.Field, Reason: "must be " + typeErr.Type.String()}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return &FieldError{Field: field, Reason: "unknown field"}
 	default:
-		return nil
+		return err
 	}
 }
 
-// Storage структура хранилища эвентов
-type Storage struct {
-	mu     *sync.Mutex
-	events map[int][]Event
-}
-
-//Create создание события в календаре
-func (s *Storage) Create(ev *Event) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+// validate проверяет данные события и возвращает ошибки по всем полям сразу,
+// event_id 0 - идентификатор назначит сервер
+func (ev *Event) validate() error {
+	var errs ValidationError
 
-	if events, ok := s.events[ev.UserID]; ok {
-		for _, event := range events {
-			if event.EventID == ev.EventID {
-				return fmt.Errorf("%v event for %v user already exists", ev.EventID, ev.UserID)
-			}
-		}
+	if ev.UserID <= 0 {
+		errs.add("user_id", "must be positive")
 	}
-	s.events[ev.UserID] = append(s.events[ev.UserID], *ev)
-
-	return nil
-}
-
-// Update обновление информации о событии в календаре
-func (s *Storage) Update(ev *Event) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	var index = -1
-
-	events := make([]Event, 0)
-	ok := false
-
-	if events, ok = s.events[ev.UserID]; !ok {
-		return fmt.Errorf("user %v doesn't exist", ev.UserID)
+	if ev.EventID < 0 {
+		errs.add("event_id", "must not be negative")
 	}
-
-	for i, event := range events {
-		if event.EventID == ev.EventID {
-			index = i
-			break
-		}
+	if ev.Title == "" {
+		errs.add("title", "is required")
 	}
-	if index == -1 {
-		fmt.Errorf("can't find event with %v id for %v user id", ev.EventID, ev.UserID)
+	if _, err := ev.location(); err != nil {
+		errs.merge(err)
 	}
-
-	s.events[ev.UserID][index] = *ev
-
-	return nil
-}
-
-func (s *Storage) Delete(ev *Event) (*Event, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	index := -1
-
-	events := make([]Event, 0)
-	ok := false
-
-	if events, ok = s.events[ev.UserID]; !ok {
-		return nil, fmt.Errorf("user %v doesn't exist", ev.UserID)
+	if ev.Date.IsZero() {
+		errs.add("date", "is required")
 	}
-
-	for i, event := range events {
-		if event.EventID == ev.EventID {
-			index = i
-			break
-		}
+	if ev.End != nil && !ev.End.After(ev.Date) {
+		errs.add("end", "must be after date")
 	}
-
-	if index == -1 {
-		fmt.Errorf("can't find event with %v id for %v user id", ev.EventID, ev.UserID)
+	if ev.Recurrence != nil && ev.RecurrenceID != nil {
+		errs.add("recurrence", "can't be set for a single occurrence")
 	}
-
-	evLen := len(s.events[ev.UserID])
-	deleted := s.events[ev.UserID][index]
-	s.events[ev.UserID][index] = s.events[ev.UserID][evLen-1]
-	s.events[ev.UserID] = s.events[ev.UserID][:evLen-1]
-
-	return &deleted, nil
-}
-
-func (s *Storage) getEventsForDay(userID int, date time.Time) ([]Event, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	var res []Event
-
-	events := make([]Event, 0)
-	ok := false
-
-	if events, ok = s.events[userID]; !ok {
-		return nil, fmt.Errorf("user %v doesn't exist", userID)
-	}
-
-	for _, ev := range events {
-		if ev.Date.Year() == date.Year() && ev.Date.Month() == date.Month() && ev.Date.Day() == date.Day() {
-			res = append(res, ev)
-		}
+	if ev.Recurrence != nil {
+		errs.merge(ev.Recurrence.validate())
 	}
+	errs.merge(ev.validateReminders())
+	errs.merge(ev.validateAttendees())
+	errs.merge(ev.validateTags())
 
-	return res, nil
+	return errs.err()
 }
 
-func (s *Storage) getEventsForWeek(userID int, date time.Time) ([]Event, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	var res []Event
-
-	events := make([]Event, 0)
-	ok := false
-
-	if events, ok = s.events[userID]; !ok {
-		return nil, fmt.Errorf("user %v doesn't exist", userID)
-	}
+// Storage интерфейс хранилища событий, хэндлеры работают только с ним и не зависят от реализации
+type Storage interface {
+	// Create и Update возвращают пересечения с другими событиями пользователя.
+	// Create назначает EventID, если он не задан, и проставляет в ev идентификатор и версию
+	Create(ev *Event, policy ConflictPolicy) ([]Event, error)
+	Update(ev *Event, policy ConflictPolicy) ([]Event, error)
+	// Delete переносит событие в корзину, Restore возвращает его оттуда с новой версией,
+	// Purge окончательно удаляет события, попавшие в корзину раньше before
+	Delete(ev *Event) (*Event, error)
+	Restore(ev *Event) ([]Event, error)
+	Purge(before time.Time) ([]TrashedEvent, error)
+	// Batch применяет операции по порядку, с atomic - все или ни одной; ошибки операций - в результатах
+	Batch(ops []BatchOp, policy ConflictPolicy, atomic bool) ([]BatchResult, error)
+	// Respond сохраняет ответ участника userID на приглашение в событие организатора
+	Respond(organizerID, eventID, userID int, status string) (*Event, error)
+	// Выборки за период включают события других пользователей, куда пользователь приглашён,
+	// getOwnEventsInRange и getUserEvents возвращают только собственные события
+	getEventsForDay(userID int, date time.Time) ([]Event, error)
+	getEventsForWeek(userID int, date time.Time) ([]Event, error)
+	getEventsForMonth(userID int, date time.Time) ([]Event, error)
+	getEventsInRange(userID int, from, to time.Time) ([]Event, error)
+	getOwnEventsInRange(userID int, from, to time.Time) ([]Event, error)
+	getUserEvents(userID int) ([]Event, error)
+	// getEvent возвращает событие (серию целиком) по идентификатору
+	getEvent(userID, eventID int) (Event, error)
+	// getUserIDs возвращает идентификаторы всех пользователей, у которых есть события
+	getUserIDs() []int
+	// eventCounts возвращает число событий каждого пользователя (серия - одно событие), не копируя события
+	eventCounts() map[int]int
+	// Календари пользователя: удалить можно только календарь без событий, в том числе в корзине
+	CreateCalendar(c *Calendar) error
+	UpdateCalendar(c *Calendar) error
+	DeleteCalendar(userID, calendarID int) (*Calendar, error)
+	getCalendars(userID int) ([]Calendar, error)
+	// getTrash возвращает корзину пользователя, getHistory - изменения события, в том числе удалённого
+	getTrash(userID int) ([]TrashedEvent, error)
+	getHistory(userID, eventID int) ([]Revision, error)
+	Close() error
+}
 
-	for _, ev := range events {
-		y1, w1 := ev.Date.ISOWeek()
-		y2, w2 := date.ISOWeek()
-		if y1 == y2 && w1 == w2 {
-			res = append(res, ev)
+// newStorage выбирает реализацию хранилища по конфигу
+func newStorage(kind, path string, now func() time.Time) (Storage, error) {
+	switch kind {
+	case "", "memory":
+		return newMemoryStorage(now), nil
+	case "file":
+		if path == "" {
+			path = defaultStoragePath
 		}
+		return newFileStorage(path, now)
+	default:
+		return nil, fmt.Errorf("unknown storage %q", kind)
 	}
-
-	return res, nil
 }
 
-func (s *Storage) getEventsForMonth(userID int, date time.Time) ([]Event, error) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	var res []Event
-
-	events := make([]Event, 0)
-	ok := false
-
-	if events, ok = s.events[userID]; !ok {
-		return nil, fmt.Errorf("user %v doesn't exist", userID)
-	}
-
-	for _, ev := range events {
-		if ev.Date.Year() == date.Year() && ev.Date.Month() == date.Month() {
-			res = append(res, ev)
-		}
-	}
+// dayRange - окно суток, в которые попадает date, в поясе date
+func dayRange(date time.Time) (time.Time, time.Time) {
+	from := midnight(date)
+	return from, from.AddDate(0, 0, 1)
+}
 
-	return res, nil
+// weekRange - окно ISO недели (с понедельника), в которую попадает date
+func weekRange(date time.Time) (time.Time, time.Time) {
+	from, _ := dayRange(date)
+	from = from.AddDate(0, 0, -((int(from.Weekday()) + 6) % 7))
+	return from, from.AddDate(0, 0, 7)
 }
 
-func getResponse(w http.ResponseWriter, r string, ev []Event, status int) {
-	resp := struct {
-		Result string  `json:"result"`
-		Events []Event `json:"events"`
-	}{Result: r, Events: ev}
+// monthRange - окно месяца, в который попадает date
+func monthRange(date time.Time) (time.Time, time.Time) {
+	from := time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, date.Location())
+	return from, from.AddDate(0, 1, 0)
+}
 
-	jsMarsh, err := json.Marshal(resp)
+// writeJSON сериализует ответ в JSON и отправляет его с нужным статусом
+func writeJSON(w http.ResponseWriter, v interface{}, status int) {
+	jsMarsh, err := json.Marshal(v)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	w.WriteHeader(status)
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(jsMarsh)
 }
 
+func getResponse(w http.ResponseWriter, r string, ev []Event, status int) {
+	getWarnResponse(w, r, ev, nil, status)
+}
+
+// getWarnResponse успешный ответ с предупреждениями о пересекающихся событиях
+func getWarnResponse(w http.ResponseWriter, r string, ev []Event, warnings []Event, status int) {
+	resp := struct {
+		Result   string  `json:"result"`
+		Events   []Event `json:"events"`
+		Warnings []Event `json:"warnings,omitempty"`
+	}{Result: r, Events: ev, Warnings: warnings}
+
+	writeJSON(w, resp, status)
+}
+
 func getErrResponse(w http.ResponseWriter, e string, status int) {
 	errResp := struct {
 		Error string `json:"error"`
 	}{Error: e}
 
-	jsMarsh, err := json.Marshal(errResp)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	writeJSON(w, errResp, status)
+}
+
+// errorStatus выбирает HTTP статус по типу ошибки, как требует задание:
+// ошибка входных данных - 400, бизнес-логики - 503, остальные - 500.
+// Пересечение событий и устаревшая версия - тоже ошибки бизнес-логики, но им отданы
+// более точные 409 и 412, чтобы клиент мог их отличить.
+func errorStatus(err error) int {
+	var (
+		validationErr *ValidationError
+		fieldErr      *FieldError
+		conflictErr   *ConflictError
+		versionErr    *VersionError
+		businessErr   *BusinessError
+		authErr       *AuthError
+		forbiddenErr  *ForbiddenError
+		maxErr        *http.MaxBytesError
+	)
+	switch {
+	case errors.As(err, &validationErr), errors.As(err, &fieldErr):
+		return http.StatusBadRequest
+	case errors.As(err, &maxErr):
+		return http.StatusRequestEntityTooLarge
+	case errors.As(err, &authErr):
+		return http.StatusUnauthorized
+	case errors.As(err, &forbiddenErr):
+		return http.StatusForbidden
+	case errors.As(err, &conflictErr):
+		return http.StatusConflict
+	case errors.As(err, &versionErr):
+		return http.StatusPreconditionFailed
+	case errors.As(err, &businessErr):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
 	}
+}
 
-	w.WriteHeader(status)
-	w.Header().Set("Content-Type", "application/json")
-	w.Write(jsMarsh)
+// getErrorResponse отвечает на ошибку со статусом по её типу; ошибки полей и пересечения
+// отдаются вместе с подробностями
+func getErrorResponse(w http.ResponseWriter, err error) {
+	var (
+		validationErr *ValidationError
+		fieldErr      *FieldError
+		conflictErr   *ConflictError
+		maxErr        *http.MaxBytesError
+	)
+	switch {
+	case errors.As(err, &maxErr):
+		getErrResponse(w, tooLargeMessage(maxErr), http.StatusRequestEntityTooLarge)
+	case errors.As(err, &validationErr):
+		resp := struct {
+			Error  string       `json:"error"`
+			Fields []FieldError `json:"fields"`
+		}{Error: err.Error(), Fields: validationErr.Fields}
+		writeJSON(w, resp, http.StatusBadRequest)
+	case errors.As(err, &fieldErr):
+		resp := struct {
+			Error  string       `json:"error"`
+			Fields []FieldError `json:"fields"`
+		}{Error: err.Error(), Fields: []FieldError{*fieldErr}}
+		writeJSON(w, resp, http.StatusBadRequest)
+	case errors.As(err, &conflictErr):
+		resp := struct {
+			Error     string  `json:"error"`
+			Conflicts []Event `json:"conflicts"`
+		}{Error: err.Error(), Conflicts: conflictErr.Conflicts}
+		writeJSON(w, resp, http.StatusConflict)
+	default:
+		status := errorStatus(err)
+		if status == http.StatusInternalServerError {
+			// подробности внутренних ошибок остаются в логе
+			log.Printf("internal error: %v", err)
+			getErrResponse(w, "internal server error", status)
+			return
+		}
+		getErrResponse(w, err.Error(), status)
+	}
 }
 
 // CreateEventHandler /create_event handler
-func CreateEventHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) CreateEventHandler(w http.ResponseWriter, r *http.Request) {
 	var ev Event
 
-	if err := ev.decode(r.Body); err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+	if err := bindEvent(r, &ev, (*Event).validate); err != nil {
+		getErrorResponse(w, err)
 		return
 	}
 
-	if err := ev.validate(); err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+	policy, err := parseConflictPolicy(r.URL.Query().Get("on_conflict"))
+	if err != nil {
+		getErrorResponse(w, err)
 		return
 	}
 
-	if err := storage.Create(&ev); err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+	warnings, err := srv.storage.Create(&ev, policy)
+	if err != nil {
+		getErrorResponse(w, err)
 		return
 	}
 
-	getResponse(w, "Событие успешно создано!", []Event{ev}, http.StatusCreated)
-
-	fmt.Println(storage.events)
+	w.Header().Set("ETag", etag(ev.Version))
+	getWarnResponse(w, "Событие успешно создано!", []Event{ev}, warnings, http.StatusCreated)
 }
 
 // UpdateEventHandler /update_event handler
-func UpdateEventHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) UpdateEventHandler(w http.ResponseWriter, r *http.Request) {
 	var ev Event
 
-	if err := ev.decode(r.Body); err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+	if err := bindEvent(r, &ev, (*Event).validateUpdate); err != nil {
+		getErrorResponse(w, err)
 		return
 	}
 
-	if err := ev.validate(); err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+	srv.updateEvent(w, r, &ev)
+}
+
+// updateEvent сохраняет проверенное событие с учётом If-Match и on_conflict
+func (srv *Server) updateEvent(w http.ResponseWriter, r *http.Request, ev *Event) {
+	if err := applyIfMatch(r, ev); err != nil {
+		getErrorResponse(w, err)
 		return
 	}
 
-	if err := storage.Update(&ev); err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+	policy, err := parseConflictPolicy(r.URL.Query().Get("on_conflict"))
+	if err != nil {
+		getErrorResponse(w, err)
 		return
 	}
 
-	getResponse(w, "Событие обновлено!", []Event{ev}, http.StatusOK)
+	warnings, err := srv.storage.Update(ev, policy)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
 
-	fmt.Println(storage.events)
+	w.Header().Set("ETag", etag(ev.Version))
+	getWarnResponse(w, "Событие обновлено!", []Event{*ev}, warnings, http.StatusOK)
 }
 
 // DeleteEventHandler /delete_event handler
-func DeleteEventHandler(w http.ResponseWriter, r *http.Request) {
+func (srv *Server) DeleteEventHandler(w http.ResponseWriter, r *http.Request) {
 	var ev Event
 
-	if err := ev.decode(r.Body); err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+	if err := bindEvent(r, &ev, (*Event).validateRef); err != nil {
+		getErrorResponse(w, err)
 		return
 	}
 
-	var deleted *Event
-	var err error
-	if deleted, err = storage.Delete(&ev); err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+	if err := applyIfMatch(r, &ev); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	deleted, err := srv.storage.Delete(&ev)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
 	}
 
 	getResponse(w, "Событие удалено!", []Event{*deleted}, http.StatusOK)
 }
 
-// ForDayHandler /events_for_day handler
-func ForDayHandler(w http.ResponseWriter, r *http.Request) {
-	var ev []Event
+// parseRangeQuery разбирает user_id, date и фильтр по календарям и меткам из queryString,
+// date берётся в поясе из параметра tz
+func parseRangeQuery(r *http.Request) (time.Time, EventFilter, error) {
+	b := binder{values: r.URL.Query()}
+
+	userID := b.userID(r)
+	date := b.time("date", b.location("tz"), true)
+	filter := parseEventFilter(&b, userID)
+
+	return date, filter, b.err()
+}
 
-	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+// rangeHandler общий обработчик выборок за день, неделю и месяц
+func rangeHandler(w http.ResponseWriter, r *http.Request, query func(int, time.Time) ([]Event, error)) {
+	date, filter, err := parseRangeQuery(r)
 	if err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		getErrorResponse(w, err)
 		return
 	}
 
-	date, err := time.Parse(dateFormat, r.URL.Query().Get("date"))
+	ev, err := query(filter.UserID, date)
 	if err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+		getErrorResponse(w, err)
 		return
 	}
 
-	if ev, err = storage.getEventsForDay(userID, date); err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
-		return
-	}
+	getResponse(w, "Запрос успешно выполнен!", filter.apply(ev), http.StatusOK)
+}
 
-	getResponse(w, "Запрос успешно выполнен!", ev, http.StatusOK)
+// ForDayHandler /events_for_day handler
+func (srv *Server) ForDayHandler(w http.ResponseWriter, r *http.Request) {
+	rangeHandler(w, r, srv.storage.getEventsForDay)
 }
 
 // ForWeekHandler /events_for_week handler
-func ForWeekHandler(w http.ResponseWriter, r *http.Request) {
-	var ev []Event
+func (srv *Server) ForWeekHandler(w http.ResponseWriter, r *http.Request) {
+	rangeHandler(w, r, srv.storage.getEventsForWeek)
+}
 
-	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
-	if err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
+// ForMonthHandler /events_for_month handler
+func (srv *Server) ForMonthHandler(w http.ResponseWriter, r *http.Request) {
+	rangeHandler(w, r, srv.storage.getEventsForMonth)
+}
+
+func main() {
+	// dev11 hash-password <пароль> печатает хэш для файла пользователей
+	if len(os.Args) == 3 && os.Args[1] == "hash-password" {
+		hash, err := hashPassword(os.Args[2])
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Println(hash)
 		return
 	}
 
-	date, err := time.Parse(dateFormat, r.URL.Query().Get("date"))
+	cfg, err := loadConfig(os.Args[1:])
 	if err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
-		return
+		log.Fatalln(err)
 	}
 
-	if ev, err = storage.getEventsForDay(userID, date); err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
-		return
+	// Хранилище из конфига: storage=memory|file, storage_path - путь к файлу данных
+	// clock - общие часы сервера, хранилища и фоновых задач
+	clock := time.Now
+
+	storage, err := newStorage(cfg.Storage, cfg.StoragePath, clock)
+	if err != nil {
+		log.Fatalln(err)
 	}
 
-	getResponse(w, "Запрос успешно выполнен!", ev, http.StatusOK)
-}
+	// Изменения событий публикуются подписчикам /events/stream
+	hub := newHub(clock)
+	storage = newPublishingStorage(storage, hub)
 
-// ForMonthHandler /events_for_month handler
-func ForMonthHandler(w http.ResponseWriter, r *http.Request) {
-	var ev []Event
+	mux := newServer(storage, clock)
+	mux.Handle(http.MethodGet, "/events/stream", StreamHandler(hub))
 
-	userID, err := strconv.Atoi(r.URL.Query().Get("user_id"))
+	// Рабочее время working_hours_path и праздники holidays_path для /suggest_slot
+	mux.availability, err = newAvailability(cfg.WorkingHoursPath, cfg.HolidaysPath)
 	if err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
-		return
+		log.Fatalln(err)
 	}
 
-	date, err := time.Parse(dateFormat, r.URL.Query().Get("date"))
+	// Предел тела max_body_size, у /import и пакетов свой - maxImportSize
+	var handler http.Handler = newBodyLimit(mux, cfg.MaxBodySize, map[string]int64{
+		"/import":       maxImportSize,
+		"/events/batch": maxImportSize,
+	})
+
+	// Аутентификация включается файлом пользователей users_path, секрет подписи токенов - auth_secret
+	var tokens *TokenIssuer
+	if cfg.UsersPath != "" {
+		users, err := newUserStore(cfg.UsersPath)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		tokens, err = newTokenIssuer(cfg.AuthSecret, clock)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		mux.Handle(http.MethodPost, "/login", LoginHandler(users, tokens))
+		handler = newAuth(handler, tokens, publicPaths...)
+	} else {
+		log.Println("users_path is not set, authentication is disabled")
+	}
+
+	// Ограничение частоты rate_limit/rate_burst перед аутентификацией: подбор паролей на /login
+	// и запросы с неверным токеном тоже ограничиваются, по IP клиента
+	if cfg.RateLimit > 0 {
+		handler = newRateLimiter(handler, cfg.RateLimit, int(cfg.RateBurst))
+	}
+
+	// Метрики в формате Prometheus
+	metrics := newMetrics(handler, mux)
+	mux.Handle(http.MethodGet, "/metrics", MetricsHandler(metrics, storage))
+
+	// Logger, log_format=json|text
+	wMux, err := newLogger(metrics, cfg.LogFormat)
 	if err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
-		return
+		log.Fatalln(err)
 	}
 
-	if ev, err = storage.getEventsForDay(userID, date); err != nil {
-		getErrResponse(w, err.Error(), http.StatusBadRequest)
-		return
+	// Напоминания: notifier=log|webhook, notifier_url - адрес webhook.
+	// Отправленные напоминания хранятся рядом с файлом данных, если хранилище файловое
+	notifier, err := newNotifier(cfg.Notifier, cfg.NotifierURL)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	fired, err := newFiredLog(firedLogPath(cfg.Storage, cfg.StoragePath), clock())
+	if err != nil {
+		log.Fatalln(err)
 	}
 
-	getResponse(w, "Запрос успешно выполнен!", ev, http.StatusOK)
-}
+	// SIGINT и SIGTERM останавливают сервер: новые запросы не принимаются, начатые дорабатывают
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-// storage - глобальное хранилище событий
-var storage Storage = Storage{events: make(map[int][]Event), mu: &sync.Mutex{}}
+	var workers sync.WaitGroup
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		newScheduler(storage, notifier, fired, clock).Run(ctx)
+	}()
 
-func main() {
-	mux := http.NewServeMux()
-
-	// Пропишем пути для GET
-	mux.HandleFunc("/events_for_day", ForDayHandler)
-	mux.HandleFunc("/events_for_week", ForWeekHandler)
-	mux.HandleFunc("/events_for_month", ForMonthHandler)
-
-	// Пропишем пути для POST
-	mux.HandleFunc("/create_event", CreateEventHandler)
-	mux.HandleFunc("/update_event", UpdateEventHandler)
-	mux.HandleFunc("/delete_event", DeleteEventHandler)
-
-	// Logger
-	wMux := newLogger(mux)
-
-	// Назначение порта из конфига
-	port := ":8080"
-	func() {
-		temp := os.Getenv("PORT")
-		if temp != "" {
-			port = temp
-		}
+	// Корзина очищается от событий старше trash_retention
+	if cfg.TrashRetention.Duration > 0 {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			newPurger(storage, cfg.TrashRetention.Duration, clock).Run(ctx)
+		}()
+	}
+
+	srv := &http.Server{
+		Addr:         cfg.Port,
+		Handler:      wMux,
+		ReadTimeout:  cfg.ReadTimeout.Duration,
+		WriteTimeout: cfg.WriteTimeout.Duration,
+		IdleTimeout:  cfg.IdleTimeout.Duration,
+	}
+	// Shutdown не прерывает длинные запросы, потоки изменений закрываются отдельно
+	srv.RegisterOnShutdown(hub.Close)
+
+	serveErr := make(chan error, 2)
+	go func() {
+		log.Printf("Server is listening for requests port%v", cfg.Port)
+		serveErr <- srv.ListenAndServe()
 	}()
 
-	log.Printf("Server is listening for requests port%v", port)
-	log.Fatalln(http.ListenAndServe(port, wMux))
+	// Бинарный протокол binary_port к тому же хранилищу, с теми же токенами и пределом тела
+	var binSrv *BinaryServer
+	if cfg.BinaryPort != "" {
+		ln, err := net.Listen("tcp", cfg.BinaryPort)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		binSrv = newBinaryServer(storage, tokens, cfg.MaxBodySize)
+		binSrv.idleTimeout, binSrv.readTimeout, binSrv.writeTimeout = cfg.IdleTimeout.Duration, cfg.ReadTimeout.Duration, cfg.WriteTimeout.Duration
+		binSrv.maxConns = int(cfg.BinaryMaxConns)
+		go func() {
+			log.Printf("Binary protocol is listening port%v", cfg.BinaryPort)
+			if err := binSrv.Serve(ln); err != nil {
+				serveErr <- err
+			}
+		}()
+	}
+
+	select {
+	case err = <-serveErr:
+		log.Println(err)
+		stop()
+		// упал один из транспортов - второй тоже останавливается
+		srv.Close()
+	case <-ctx.Done():
+		log.Println("shutting down")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}
+	if binSrv != nil {
+		binSrv.Close()
+	}
+
+	// после остановки хэндлеров, планировщика и очистки корзины сбрасываем данные на диск
+	workers.Wait()
+	if err := storage.Close(); err != nil {
+		log.Printf("storage close: %v", err)
+	}
+	if err := fired.Close(); err != nil {
+		log.Printf("reminders close: %v", err)
+	}
+	if err != nil {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	// база часовых поясов встраивается в бинарник, чтобы не зависеть от системы
+	_ "time/tzdata"
+)
+
+// location возвращает часовой пояс события, по умолчанию UTC
+func (ev *Event) location() (*time.Location, error) {
+	if ev.TimeZone == "" {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(ev.TimeZone)
+	if err != nil {
+		return nil, &FieldError{Field: "time_zone", Reason: "unknown time zone"}
+	}
+	return loc, nil
+}
+
+// parseDate принимает RFC 3339 или просто дату (2006-01-02), дата без времени - полночь в loc
+func parseDate(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.In(loc), nil
+	}
+	t, err := time.ParseInLocation(dateFormat, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	return t, nil
+}
+
+// midnight - начало суток в часовом поясе t
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// setTimes разбирает начало и конец события в его часовом поясе.
+// У события на весь день время отбрасывается, конец - полночь следующего за последним днём.
+func (ev *Event) setTimes(date, end string) error {
+	loc, err := ev.location()
+	if err != nil {
+		return err
+	}
+
+	if date != "" {
+		if ev.Date, err = parseDate(date, loc); err != nil {
+			return &FieldError{Field: "date", Reason: "must be a date (2006-01-02) or RFC 3339 time"}
+		}
+	}
+	if end != "" {
+		t, err := parseDate(end, loc)
+		if err != nil {
+			return &FieldError{Field: "end", Reason: "must be a date (2006-01-02) or RFC 3339 time"}
+		}
+		ev.End = &t
+	}
+
+	ev.truncateAllDay()
+	return nil
+}
+
+// setRecurrenceID разбирает исходную дату вхождения в часовом поясе события, как date и until
+func (ev *Event) setRecurrenceID(value string) error {
+	if value == "" {
+		return nil
+	}
+	loc, err := ev.location()
+	if err != nil {
+		return err
+	}
+
+	t, err := parseDate(value, loc)
+	if err != nil {
+		return &FieldError{Field: "recurrence_id", Reason: "must be a date (2006-01-02) or RFC 3339 time"}
+	}
+	ev.RecurrenceID = &t
+	return nil
+}
+
+// inLocation - setTimes для времени, пришедшего готовым значением, а не строкой (бинарный протокол):
+// начало и конец переводятся в пояс события, у события на весь день отбрасывается время
+func (ev *Event) inLocation(loc *time.Location) {
+	ev.Date = ev.Date.In(loc)
+	if ev.End != nil {
+		t := ev.End.In(loc)
+		ev.End = &t
+	}
+	ev.truncateAllDay()
+}
+
+// truncateAllDay у события на весь день заменяет начало и конец полуночью тех же суток
+func (ev *Event) truncateAllDay() {
+	if ev.AllDay {
+		ev.Date = midnight(ev.Date)
+		if ev.End != nil {
+			t := midnight(*ev.End)
+			ev.End = &t
+		}
+	}
+}
+
+// UnmarshalJSON разбирает событие, даты (и recurrence_id) принимаются в RFC 3339 или в формате 2006-01-02.
+// Неизвестные поля считаются ошибкой, чтобы опечатка в имени поля не терялась молча.
+func (ev *Event) UnmarshalJSON(data []byte) error {
+	type plain Event
+	aux := struct {
+		*plain
+		Date         string `json:"date"`
+		End          string `json:"end"`
+		RecurrenceID string `json:"recurrence_id"`
+	}{plain: (*plain)(ev)}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&aux); err != nil {
+		return err
+	}
+
+	if err := ev.setTimes(aux.Date, aux.End); err != nil {
+		return err
+	}
+	return ev.setRecurrenceID(aux.RecurrenceID)
+}
+
+// end возвращает момент окончания события; событие без конца длится мгновение, на весь день - сутки
+func (ev *Event) end() time.Time {
+	switch {
+	case ev.End != nil:
+		return *ev.End
+	case ev.AllDay:
+		return ev.Date.AddDate(0, 0, 1)
+	default:
+		return ev.Date
+	}
+}
+
+// endFor возвращает конец вхождения серии, начинающегося в start, с той же длительностью
+func (ev *Event) endFor(start time.Time) *time.Time {
+	if ev.End == nil {
+		return nil
+	}
+
+	var end time.Time
+	if ev.AllDay {
+		// сутки при переходе на летнее время короче, поэтому считаем в днях
+		days := int(ev.End.Sub(ev.Date).Hours()/24 + 0.5)
+		end = start.AddDate(0, 0, days)
+	} else {
+		end = start.Add(ev.End.Sub(ev.Date))
+	}
+	return &end
+}
+
+// overlaps проверяет пересечение события с окном [from, to)
+func (ev *Event) overlaps(from, to time.Time) bool {
+	if !ev.Date.Before(to) {
+		return false
+	}
+	return !ev.Date.Before(from) || ev.end().After(from)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSetTimes(t *testing.T) {
+	msk, _ := time.LoadLocation("Europe/Moscow")
+	tests := []struct {
+		name      string
+		timeZone  string
+		allDay    bool
+		date, end string
+		wantDate  time.Time
+		wantEnd   time.Time
+		field     string
+	}{
+		{"rfc3339 in utc", "", false, "2024-01-10T10:00:00Z", "2024-01-10T11:30:00Z",
+			time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC), time.Date(2024, 1, 10, 11, 30, 0, 0, time.UTC), ""},
+		{"date is midnight in the zone", "Europe/Moscow", false, "2024-01-10", "",
+			time.Date(2024, 1, 10, 0, 0, 0, 0, msk), time.Time{}, ""},
+		{"offset is kept as an instant", "Europe/Moscow", false, "2024-01-10T07:00:00Z", "",
+			time.Date(2024, 1, 10, 10, 0, 0, 0, msk), time.Time{}, ""},
+		{"all day drops the time", "Europe/Moscow", true, "2024-01-10T15:00:00+03:00", "2024-01-12T01:00:00+03:00",
+			time.Date(2024, 1, 10, 0, 0, 0, 0, msk), time.Date(2024, 1, 12, 0, 0, 0, 0, msk), ""},
+		{"all day in the event zone, not in utc", "Europe/Moscow", true, "2024-01-09T22:00:00Z", "",
+			time.Date(2024, 1, 10, 0, 0, 0, 0, msk), time.Time{}, ""},
+		{"bad date", "", false, "10.01.2024", "", time.Time{}, time.Time{}, "date"},
+		{"bad end", "", false, "2024-01-10", "tomorrow", time.Time{}, time.Time{}, "end"},
+		{"unknown zone", "Mars/Olympus", false, "2024-01-10", "", time.Time{}, time.Time{}, "time_zone"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev := Event{TimeZone: tt.timeZone, AllDay: tt.allDay}
+			err := ev.setTimes(tt.date, tt.end)
+			if tt.field != "" {
+				if fe, ok := err.(*FieldError); !ok || fe.Field != tt.field {
+					t.Errorf("error %v, want field %v", err, tt.field)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ev.Date.Equal(tt.wantDate) || ev.Date.Location().String() != tt.wantDate.Location().String() {
+				t.Errorf("date %v, want %v", ev.Date, tt.wantDate)
+			}
+			if (ev.End == nil) != tt.wantEnd.IsZero() || ev.End != nil && !ev.End.Equal(tt.wantEnd) {
+				t.Errorf("end %v, want %v", ev.End, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestRangeWindows(t *testing.T) {
+	ny, _ := time.LoadLocation("America/New_York")
+	tests := []struct {
+		name     string
+		window   func(time.Time) (time.Time, time.Time)
+		date     time.Time
+		from, to time.Time
+		hours    float64
+	}{
+		{"day", dayRange, time.Date(2024, 1, 10, 15, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC), 24},
+		{"day of the spring transition", dayRange, time.Date(2024, 3, 10, 12, 0, 0, 0, ny),
+			time.Date(2024, 3, 10, 0, 0, 0, 0, ny), time.Date(2024, 3, 11, 0, 0, 0, 0, ny), 23},
+		{"week from sunday", weekRange, time.Date(2024, 1, 14, 23, 0, 0, 0, time.UTC),
+			time.Date(2024, 1, 8, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), 168},
+		{"week of the autumn transition", weekRange, time.Date(2024, 11, 3, 12, 0, 0, 0, ny),
+			time.Date(2024, 10, 28, 0, 0, 0, 0, ny), time.Date(2024, 11, 4, 0, 0, 0, 0, ny), 169},
+		{"leap february", monthRange, time.Date(2024, 2, 29, 23, 59, 0, 0, time.UTC),
+			time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), 29 * 24},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			from, to := tt.window(tt.date)
+			if !from.Equal(tt.from) || !to.Equal(tt.to) || to.Sub(from).Hours() != tt.hours {
+				t.Errorf("[%v, %v), want [%v, %v) of %v hours", from, to, tt.from, tt.to, tt.hours)
+			}
+		})
+	}
+}
+
+func TestEventsForDayInRequestZone(t *testing.T) {
+	ts := newTestServer(t)
+	// 22:30 UTC 10 января - это уже 11 января в Москве
+	ts.create(t, url.Values{"user_id": {"1"}, "title": {"Поздний созвон"}, "date": {"2024-01-10T22:30:00Z"}})
+
+	tests := []struct {
+		query string
+		found bool
+	}{
+		{"date=2024-01-10", true},
+		{"date=2024-01-11", false},
+		{"date=2024-01-11&tz=Europe/Moscow", true},
+		{"date=2024-01-10&tz=Europe/Moscow", false},
+		{"date=2024-01-10&tz=America/New_York", true},
+	}
+	for _, tt := range tests {
+		resp, res := ts.get(t, "/events_for_day?user_id=1&"+tt.query)
+		wantStatus(t, resp, res, http.StatusOK)
+		if found := len(res.Events) == 1; found != tt.found {
+			t.Errorf("%v: %v events", tt.query, len(res.Events))
+		}
+	}
+
+	resp, res := ts.get(t, "/events_for_day?user_id=1&date=2024-01-10&tz=Mars/Olympus")
+	wantStatus(t, resp, res, http.StatusBadRequest)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// purgeInterval - как часто корзина очищается от событий старше trash_retention
+const purgeInterval = time.Hour
+
+// TrashedEvent - событие в корзине и время удаления
+type TrashedEvent struct {
+	Event
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// UnmarshalJSON отделяет deleted_at: разбор Event не пропускает незнакомые поля
+func (t *TrashedEvent) UnmarshalJSON(data []byte) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if v, ok := fields["deleted_at"]; ok {
+		if err := json.Unmarshal(v, &t.DeletedAt); err != nil {
+			return err
+		}
+		delete(fields, "deleted_at")
+	}
+
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return t.Event.UnmarshalJSON(data)
+}
+
+// Revision - одно изменение события: кто, когда и какие поля поменял
+type Revision struct {
+	Version int       `json:"version"`
+	Op      string    `json:"op"`
+	Time    time.Time `json:"time"`
+	// UserID - кто изменил: организатор или ответивший на приглашение участник
+	UserID  int           `json:"user_id"`
+	Changes []FieldChange `json:"changes,omitempty"`
+}
+
+// FieldChange - значение поля до и после изменения, отсутствующее значение опускается
+type FieldChange struct {
+	Field string          `json:"field"`
+	Old   json.RawMessage `json:"old,omitempty"`
+	New   json.RawMessage `json:"new,omitempty"`
+}
+
+// eventFields - поля события в JSON представлении
+func eventFields(ev *Event) map[string]json.RawMessage {
+	if ev == nil {
+		return nil
+	}
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return nil
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil
+	}
+	// идентификаторы не меняются, версия есть в самой ревизии
+	delete(fields, "user_id")
+	delete(fields, "event_id")
+	delete(fields, "version")
+	return fields
+}
+
+// diffEvents возвращает изменившиеся поля по алфавиту, old == nil - событие создано
+func diffEvents(old, cur *Event) []FieldChange {
+	before, after := eventFields(old), eventFields(cur)
+
+	names := make([]string, 0, len(after))
+	for name := range after {
+		names = append(names, name)
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var res []FieldChange
+	for _, name := range names {
+		if !bytes.Equal(before[name], after[name]) {
+			res = append(res, FieldChange{Field: name, Old: before[name], New: after[name]})
+		}
+	}
+	return res
+}
+
+// TrashHandler /trash handler: события в корзине пользователя
+func (srv *Server) TrashHandler(w http.ResponseWriter, r *http.Request) {
+	b := binder{values: r.URL.Query()}
+	userID := b.userID(r)
+	if err := b.err(); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	trash, err := srv.storage.getTrash(userID)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	resp := struct {
+		Result string         `json:"result"`
+		Trash  []TrashedEvent `json:"trash"`
+	}{Result: "Запрос успешно выполнен!", Trash: trash}
+
+	writeJSON(w, resp, http.StatusOK)
+}
+
+// RestoreEventHandler /restore_event handler
+func (srv *Server) RestoreEventHandler(w http.ResponseWriter, r *http.Request) {
+	var ev Event
+
+	if err := bindEvent(r, &ev, (*Event).validateRef); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	warnings, err := srv.storage.Restore(&ev)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(ev.Version))
+	getWarnResponse(w, "Событие восстановлено!", []Event{ev}, warnings, http.StatusOK)
+}
+
+// EventHistoryHandler /event_history handler: изменения события от создания, по возрастанию версии
+func (srv *Server) EventHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	b := binder{values: r.URL.Query()}
+	userID := b.userID(r)
+	eventID := b.int("event_id", true)
+	if err := b.err(); err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	history, err := srv.storage.getHistory(userID, eventID)
+	if err != nil {
+		getErrorResponse(w, err)
+		return
+	}
+
+	resp := struct {
+		Result  string     `json:"result"`
+		History []Revision `json:"history"`
+	}{Result: "Запрос успешно выполнен!", History: history}
+
+	writeJSON(w, resp, http.StatusOK)
+}
+
+// Purger раз в interval окончательно удаляет события, пролежавшие в корзине дольше retention
+type Purger struct {
+	storage   Storage
+	retention time.Duration
+	interval  time.Duration
+	now       func() time.Time
+}
+
+func newPurger(s Storage, retention time.Duration, now func() time.Time) *Purger {
+	return &Purger{storage: s, retention: retention, interval: purgeInterval, now: now}
+}
+
+// Run работает до отмены ctx
+func (p *Purger) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.tick()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Purger) tick() {
+	purged, err := p.storage.Purge(p.now().Add(-p.retention))
+	if err != nil {
+		log.Printf("trash purge failed: %v", err)
+		return
+	}
+	if len(purged) > 0 {
+		log.Printf("purged %v events from trash", len(purged))
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffEvents(t *testing.T) {
+	date := time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)
+	end := date.Add(time.Hour)
+	base := Event{UserID: 1, EventID: 1, Title: "Созвон", Date: date, End: &end, Version: 1}
+
+	changed := func(f func(*Event)) *Event {
+		ev := base
+		f(&ev)
+		return &ev
+	}
+	tests := []struct {
+		name   string
+		old    *Event
+		cur    *Event
+		fields string
+	}{
+		{"no changes", &base, &base, ""},
+		{"ids and version are not changes", &base, changed(func(ev *Event) { ev.EventID, ev.Version = 2, 5 }), ""},
+		{"one field", &base, changed(func(ev *Event) { ev.Title = "Встреча" }), "title"},
+		{"sorted", &base, changed(func(ev *Event) { ev.Title, ev.Description = "Встреча", "повестка" }), "description,title"},
+		{"removed field", &base, changed(func(ev *Event) { ev.End = nil }), "end-"},
+		{"added field", changed(func(ev *Event) { ev.End = nil }), &base, "end+"},
+		{"created", nil, &base, "date+,description+,end+,title+"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fields []string
+			for _, c := range diffEvents(tt.old, tt.cur) {
+				name := c.Field
+				switch {
+				case c.Old == nil:
+					name += "+"
+				case c.New == nil:
+					name += "-"
+				}
+				fields = append(fields, name)
+			}
+			if got := strings.Join(fields, ","); got != tt.fields {
+				t.Errorf("changes %q, want %q", got, tt.fields)
+			}
+		})
+	}
+}
+
+func TestTrashedEventJSON(t *testing.T) {
+	deletedAt := time.Date(2024, 1, 10, 12, 0, 0, 0, time.UTC)
+	in := TrashedEvent{Event: Event{UserID: 1, EventID: 3, Title: "Созвон", Date: deletedAt.Add(-time.Hour)}, DeletedAt: deletedAt}
+	data, err := json.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out TrashedEvent
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.DeletedAt.Equal(deletedAt) || out.EventID != 3 || out.Title != "Созвон" || !out.Date.Equal(in.Date) {
+		t.Errorf("round trip %+v", out)
+	}
+	if err := json.Unmarshal([]byte(`{"event_id":3,"deleted_at":"yesterday"}`), &out); err == nil {
+		t.Error("bad deleted_at accepted")
+	}
+}
+
+func TestTrashRestorePurge(t *testing.T) {
+	clock := newTestClock()
+	s := newMemoryStorage(clock.now)
+	date := time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)
+	for _, title := range []string{"Первое", "Второе", "Третье"} {
+		end := date.Add(time.Hour)
+		ev := Event{UserID: 1, Title: title, Date: date, End: &end}
+		if _, err := s.Create(&ev, conflictWarn); err != nil {
+			t.Fatal(err)
+		}
+		date = date.Add(2 * time.Hour)
+	}
+	// первое удалено раньше второго, третье остаётся на месте
+	start := clock.now()
+	for _, id := range []int{1, 2} {
+		if _, err := s.Delete(&Event{UserID: 1, EventID: id}); err != nil {
+			t.Fatal(err)
+		}
+		clock.advance(time.Hour)
+	}
+	// на месте второго появилось новое событие
+	takenEnd := time.Date(2024, 1, 10, 13, 0, 0, 0, time.UTC)
+	taken := Event{UserID: 1, Title: "Занял слот", Date: takenEnd.Add(-time.Hour), End: &takenEnd}
+	if _, err := s.Create(&taken, conflictReject); err != nil {
+		t.Fatal(err)
+	}
+
+	if trash, err := s.getTrash(1); err != nil || len(trash) != 2 || trash[0].EventID != 1 || trash[1].EventID != 2 {
+		t.Fatalf("trash %+v, %v", trash, err)
+	}
+	if _, err := s.getTrash(2); err == nil {
+		t.Error("trash of an unknown user")
+	}
+
+	steps := []struct {
+		name      string
+		do        func() (int, error)
+		want      int
+		business  bool
+		trashSize int
+	}{
+		{"restore a live event", func() (int, error) {
+			_, err := s.Restore(&Event{UserID: 1, EventID: 3})
+			return 0, err
+		}, 0, true, 2},
+		{"restore with conflicts", func() (int, error) {
+			ev := Event{UserID: 1, EventID: 2}
+			conflicts, err := s.Restore(&ev)
+			if err == nil && ev.Version != 2 {
+				t.Errorf("restored version %v", ev.Version)
+			}
+			return len(conflicts), err
+		}, 1, false, 1},
+		{"restore twice", func() (int, error) {
+			_, err := s.Restore(&Event{UserID: 1, EventID: 2})
+			return 0, err
+		}, 0, true, 1},
+		{"purge keeps events deleted at the boundary", func() (int, error) {
+			purged, err := s.Purge(start)
+			return len(purged), err
+		}, 0, false, 1},
+		{"purge older", func() (int, error) {
+			purged, err := s.Purge(start.Add(time.Minute))
+			return len(purged), err
+		}, 1, false, 0},
+		{"restore purged", func() (int, error) {
+			_, err := s.Restore(&Event{UserID: 1, EventID: 1})
+			return 0, err
+		}, 0, true, 0},
+		{"history of a purged event", func() (int, error) {
+			history, err := s.getHistory(1, 1)
+			return len(history), err
+		}, 0, true, 0},
+		{"history of a restored event", func() (int, error) {
+			history, err := s.getHistory(1, 2)
+			return len(history), err
+		}, 3, false, 0},
+	}
+	for _, st := range steps {
+		n, err := st.do()
+		var be *BusinessError
+		if st.business != errors.As(err, &be) || !st.business && (err != nil || n != st.want) {
+			t.Errorf("%v: %v, %v", st.name, n, err)
+		}
+		if trash, _ := s.getTrash(1); len(trash) != st.trashSize {
+			t.Errorf("%v: trash size %v, want %v", st.name, len(trash), st.trashSize)
+		}
+	}
+}
+
+func TestPurgerRetention(t *testing.T) {
+	clock := newTestClock()
+	s := newMemoryStorage(clock.now)
+	ev := Event{UserID: 1, Title: "Созвон", Date: clock.now()}
+	if _, err := s.Create(&ev, conflictWarn); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Delete(&Event{UserID: 1, EventID: ev.EventID}); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newPurger(s, 24*time.Hour, clock.now)
+	for _, tt := range []struct {
+		after time.Duration
+		left  int
+	}{{time.Hour, 1}, {23 * time.Hour, 1}, {time.Second, 0}} {
+		clock.advance(tt.after)
+		p.tick()
+		if trash, _ := s.getTrash(1); len(trash) != tt.left {
+			t.Errorf("after %v: %v in trash, want %v", tt.after, len(trash), tt.left)
+		}
+	}
+}
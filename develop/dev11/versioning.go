@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// VersionError - ошибка бизнес-логики: событие уже изменил кто-то другой
+type VersionError struct {
+	EventID  int
+	Expected int
+	Current  int
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("event %v was modified: expected version %v, current version %v", e.EventID, e.Expected, e.Current)
+}
+
+// checkVersion сверяет ожидаемую клиентом версию с текущей, версия 0 - без проверки
+func checkVersion(ev *Event, current Event) error {
+	if ev.Version != 0 && ev.Version != current.Version {
+		return &VersionError{EventID: current.EventID, Expected: ev.Version, Current: current.Version}
+	}
+	return nil
+}
+
+// etag строит ETag по версии события
+func etag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// applyIfMatch переносит версию из заголовка If-Match в событие, заголовок важнее поля version.
+// If-Match: * означает любую существующую версию.
+func applyIfMatch(r *http.Request, ev *Event) error {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "" {
+		return nil
+	}
+	if value == "*" {
+		ev.Version = 0
+		return nil
+	}
+
+	version, err := strconv.Atoi(strings.Trim(strings.TrimPrefix(value, "W/"), `"`))
+	if err != nil || version <= 0 {
+		return &FieldError{Field: "If-Match", Reason: fmt.Sprintf("invalid version %q", value)}
+	}
+	ev.Version = version
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestApplyIfMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		field   int
+		version int
+		invalid bool
+	}{
+		{"no header keeps the field", "", 4, 4, false},
+		{"strong tag", `"3"`, 4, 3, false},
+		{"weak tag", `W/"3"`, 0, 3, false},
+		{"unquoted", " 3 ", 0, 3, false},
+		{"any version", "*", 4, 0, false},
+		{"zero", `"0"`, 0, 0, true},
+		{"negative", `"-1"`, 0, 0, true},
+		{"not a number", `"abc"`, 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("PUT", "/users/1/events/1", nil)
+			if tt.header != "" {
+				r.Header.Set("If-Match", tt.header)
+			}
+			ev := Event{Version: tt.field}
+			err := applyIfMatch(r, &ev)
+			if tt.invalid {
+				if fe, ok := err.(*FieldError); !ok || fe.Field != "If-Match" {
+					t.Errorf("error %v", err)
+				}
+				return
+			}
+			if err != nil || ev.Version != tt.version {
+				t.Errorf("version %v, %v; want %v", ev.Version, err, tt.version)
+			}
+		})
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	current := Event{EventID: 5, Version: 3}
+	tests := []struct {
+		expected int
+		ok       bool
+	}{
+		{0, true},
+		{3, true},
+		{2, false},
+		{4, false},
+	}
+	for _, tt := range tests {
+		err := checkVersion(&Event{Version: tt.expected}, current)
+		var ve *VersionError
+		switch {
+		case tt.ok && err != nil:
+			t.Errorf("expected %v: %v", tt.expected, err)
+		case !tt.ok && (!errors.As(err, &ve) || ve.EventID != 5 || ve.Expected != tt.expected || ve.Current != 3):
+			t.Errorf("expected %v: %v", tt.expected, err)
+		}
+	}
+}
+
+func TestEventIDsAndVersions(t *testing.T) {
+	s := newMemoryStorage(time.Now)
+	date := time.Date(2024, 1, 10, 10, 0, 0, 0, time.UTC)
+	create := func(userID, eventID int) (Event, error) {
+		ev := Event{UserID: userID, EventID: eventID, Title: "x", Date: date}
+		_, err := s.Create(&ev, conflictWarn)
+		date = date.Add(time.Hour)
+		return ev, err
+	}
+
+	steps := []struct {
+		name            string
+		userID, eventID int
+		want            int
+		fails           bool
+	}{
+		{"first", 1, 0, 1, false},
+		{"second", 1, 0, 2, false},
+		{"explicit id", 1, 10, 10, false},
+		{"after the explicit id", 1, 0, 11, false},
+		{"explicit id below the next", 1, 5, 5, false},
+		{"taken id", 1, 2, 0, true},
+		{"other user starts from 1", 2, 0, 1, false},
+	}
+	for _, st := range steps {
+		ev, err := create(st.userID, st.eventID)
+		if st.fails != (err != nil) || !st.fails && (ev.EventID != st.want || ev.Version != 1) {
+			t.Errorf("%v: id %v version %v, %v", st.name, ev.EventID, ev.Version, err)
+		}
+	}
+
+	// идентификатор удалённого события не выдаётся снова
+	if _, err := s.Delete(&Event{UserID: 1, EventID: 11}); err != nil {
+		t.Fatal(err)
+	}
+	if ev, err := create(1, 0); err != nil || ev.EventID != 12 {
+		t.Errorf("after delete: id %v, %v", ev.EventID, err)
+	}
+
+	// каждое изменение увеличивает версию, устаревшая версия не меняет событие
+	update := Event{UserID: 1, EventID: 1, Title: "v2", Date: date, Version: 1}
+	if _, err := s.Update(&update, conflictWarn); err != nil || update.Version != 2 {
+		t.Fatalf("update: version %v, %v", update.Version, err)
+	}
+	stale := Event{UserID: 1, EventID: 1, Title: "stale", Date: date, Version: 1}
+	var ve *VersionError
+	if _, err := s.Update(&stale, conflictWarn); !errors.As(err, &ve) || ve.Current != 2 {
+		t.Errorf("stale update: %v", err)
+	}
+	if _, err := s.Delete(&Event{UserID: 1, EventID: 1, Version: 1}); !errors.As(err, &ve) {
+		t.Errorf("stale delete: %v", err)
+	}
+	if ev, _ := s.getEvent(1, 1); ev.Title != "v2" || ev.Version != 2 {
+		t.Errorf("after stale writes %+v", ev)
+	}
+}